@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/audit"
+	cli "github.com/urfave/cli/v2"
+)
+
+func getAuditCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "audit",
+		Usage: "Print the audit log of a server as JSON lines, e.g. who started and stopped load tests",
+		Flags: clientFlags(
+			&cli.StringFlag{
+				Name:  "action",
+				Usage: "Only print events of an action: load_test.start, load_test.stop, worker.remove",
+			},
+			&cli.StringFlag{
+				Name:  "user",
+				Usage: "Only print events of a user",
+			},
+			&cli.StringFlag{
+				Name:  "run-id",
+				Usage: "Only print events of a load test run",
+			},
+			&cli.DurationFlag{
+				Name:  "since",
+				Usage: "Only print events more recent than a duration, e.g. 24h",
+			},
+			&cli.IntFlag{
+				Name:  "limit",
+				Usage: "Only print the most recent events",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			cl, err := newClient(c)
+			if err != nil {
+				return err
+			}
+
+			q := audit.Query{
+				Action: c.String("action"),
+				User:   c.String("user"),
+				RunID:  c.String("run-id"),
+				Limit:  c.Int("limit"),
+			}
+			if since := c.Duration("since"); since > 0 {
+				q.Since = time.Now().Add(-since)
+			}
+
+			events, err := cl.GetAuditLog(q)
+			if err != nil {
+				return err
+			}
+
+			encoder := json.NewEncoder(os.Stdout)
+			for i := range events {
+				if err := encoder.Encode(&events[i]); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/tls"
+
+	"github.com/andylibrian/terjang/pkg/client"
+	"github.com/andylibrian/terjang/pkg/tlsconfig"
+	cli "github.com/urfave/cli/v2"
+)
+
+// clientFlags returns the flags of the commands using the server's HTTP API, followed by flags.
+func clientFlags(flags ...cli.Flag) []cli.Flag {
+	base := []cli.Flag{
+		&cli.StringFlag{
+			Name:  "host",
+			Usage: "Server's host address to connect to",
+			Value: "localhost",
+		},
+		&cli.StringFlag{
+			Name:  "port",
+			Usage: "Server's host port to connect to",
+			Value: "9009",
+		},
+		&cli.StringFlag{
+			Name:    "token",
+			Usage:   "API token, for servers that require one",
+			EnvVars: []string{"TERJANG_API_TOKEN"},
+		},
+	}
+
+	return append(append(base, tlsClientFlags()...), flags...)
+}
+
+// newClient creates a client of the server selected by the clientFlags.
+func newClient(c *cli.Context) (*client.Client, error) {
+	cl := client.NewClient(c.String("host") + ":" + c.String("port"))
+	cl.SetToken(c.String("token"))
+
+	config, err := tlsClientConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if config != nil {
+		cl.SetTLSConfig(config)
+	}
+
+	return cl, nil
+}
+
+// tlsClientFlags returns the flags to connect to a server over TLS, shared by workers and the API commands.
+func tlsClientFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "tls",
+			Usage: "Connect to the server with TLS. Implied by the other tls flags",
+		},
+		&cli.StringFlag{
+			Name:  "tls-ca",
+			Usage: "PEM file of the CA certificates to verify the server's certificate with. By default, the system's CAs are used",
+		},
+		&cli.StringFlag{
+			Name:  "tls-cert",
+			Usage: "PEM file of the client certificate to present to the server",
+		},
+		&cli.StringFlag{
+			Name:  "tls-key",
+			Usage: "PEM file of the key of the client certificate",
+		},
+	}
+}
+
+// tlsClientConfig returns the TLS configuration selected by the tlsClientFlags, or nil without TLS.
+func tlsClientConfig(c *cli.Context) (*tls.Config, error) {
+	if !c.Bool("tls") && c.String("tls-ca") == "" && c.String("tls-cert") == "" && c.String("tls-key") == "" {
+		return nil, nil
+	}
+
+	return tlsconfig.Client(c.String("tls-ca"), c.String("tls-cert"), c.String("tls-key"))
+}
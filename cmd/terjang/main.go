@@ -1,10 +1,18 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/andylibrian/terjang/pkg/audit"
 	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/sink"
+	"github.com/andylibrian/terjang/pkg/store"
+	"github.com/andylibrian/terjang/pkg/tlsconfig"
+	"github.com/andylibrian/terjang/pkg/transport"
 	"github.com/andylibrian/terjang/pkg/worker"
 	cli "github.com/urfave/cli/v2"
 	"go.uber.org/zap"
@@ -52,16 +60,173 @@ func getCliApp() *cli.App {
 						Usage: "Host port to listen on",
 						Value: "9009",
 					},
+					&cli.StringFlag{
+						Name:  "data-dir",
+						Usage: "Directory to persist load test history in. If empty, history is kept in memory only",
+						Value: "terjang-data",
+					},
+					&cli.StringFlag{
+						Name:  "audit-log",
+						Usage: "JSON lines file to append the audit log of started and stopped load tests to. By default, audit.jsonl in --data-dir, or in memory if it is empty",
+					},
+					&cli.IntFlag{
+						Name:  "local-workers",
+						Usage: "Number of workers to run in the server process, connected in memory. Remote workers can still join",
+						Value: 0,
+					},
+					&cli.StringFlag{
+						Name:    "cluster-secret",
+						Usage:   "Secret workers must present to join. If empty, any worker can join",
+						EnvVars: []string{"TERJANG_CLUSTER_SECRET"},
+					},
+					&cli.StringSliceFlag{
+						Name:    "api-token",
+						Usage:   "Token granting the admin role on the HTTP API, the notifications and the metrics, can be repeated. Without tokens nor users, the API is open",
+						EnvVars: []string{"TERJANG_API_TOKENS"},
+					},
+					&cli.StringFlag{
+						Name:  "users-file",
+						Usage: "JSON file of the API users, with their token and role (viewer, operator or admin): {\"users\": [{\"name\": \"alice\", \"token\": \"...\", \"role\": \"operator\"}]}",
+					},
+					&cli.StringFlag{
+						Name:  "tls-cert",
+						Usage: "PEM file of the server's certificate. Serves HTTPS and wss when set, along with --tls-key",
+					},
+					&cli.StringFlag{
+						Name:  "tls-key",
+						Usage: "PEM file of the key of the server's certificate",
+					},
+					&cli.StringFlag{
+						Name:  "tls-client-ca",
+						Usage: "PEM file of the CA certificates workers' client certificates must be signed by to join (mutual TLS)",
+					},
+					&cli.StringSliceFlag{
+						Name:  "allowed-origin",
+						Usage: "Origin browsers may use the API from besides the server's own, e.g. http://localhost:8080, can be repeated. \"*\" allows any origin",
+					},
+					&cli.DurationFlag{
+						Name:  "resume-grace-period",
+						Usage: "How long a load test keeps running after its last worker lost its connection, so that workers can reconnect and resume it",
+						Value: 10 * time.Second,
+					},
+					&cli.DurationFlag{
+						Name:  "heartbeat-interval",
+						Usage: "How often workers and notification subscribers are pinged. Zero disables heartbeats",
+						Value: 5 * time.Second,
+					},
+					&cli.DurationFlag{
+						Name:  "heartbeat-timeout",
+						Usage: "How long a worker or notification subscriber may stay silent before being considered unresponsive",
+						Value: 15 * time.Second,
+					},
+					&cli.DurationFlag{
+						Name:  "eviction-grace-period",
+						Usage: "How long a worker stays unresponsive before being evicted",
+						Value: 30 * time.Second,
+					},
+					&cli.DurationFlag{
+						Name:  "ack-timeout",
+						Usage: "How long to wait for workers to accept or reject a load test",
+						Value: 5 * time.Second,
+					},
+					&cli.StringSliceFlag{
+						Name:  "sink",
+						Usage: "URL of a backend to push per second load test metrics to, can be repeated: influxdb://host:8086/write?db=terjang, influxdb+udp://host:8089, statsd://host:8125, dogstatsd://host:8125, otlp://host:4318",
+					},
 				},
 				Action: func(c *cli.Context) error {
 					host := c.String("host")
 					port := c.String("port")
+					dataDir := c.String("data-dir")
 					logLevel := c.String("log-level")
 
 					logger := getLogger(logLevel)
 					server.SetLogger(logger)
+					store.SetLogger(logger)
 
 					srv := server.NewServer()
+					srv.SetVersion(version)
+
+					if dataDir != "" {
+						fileStore, err := store.NewFileStore(dataDir)
+						if err != nil {
+							return err
+						}
+
+						srv.SetStore(fileStore)
+					}
+
+					auditLogPath := c.String("audit-log")
+					if auditLogPath == "" && dataDir != "" {
+						auditLogPath = filepath.Join(dataDir, "audit.jsonl")
+					}
+
+					if auditLogPath != "" {
+						auditLog, err := audit.NewFileLog(auditLogPath)
+						if err != nil {
+							return err
+						}
+						defer auditLog.Close()
+
+						srv.SetAuditLog(auditLog)
+					}
+
+					if c.String("tls-cert") != "" || c.String("tls-key") != "" {
+						config, err := tlsconfig.Server(c.String("tls-cert"), c.String("tls-key"), c.String("tls-client-ca"))
+						if err != nil {
+							return err
+						}
+
+						srv.SetTLSConfig(config)
+					} else if c.String("tls-client-ca") != "" {
+						return fmt.Errorf("--tls-client-ca requires --tls-cert and --tls-key")
+					}
+
+					srv.SetResumeGracePeriod(c.Duration("resume-grace-period"))
+					srv.SetHeartbeat(c.Duration("heartbeat-interval"), c.Duration("heartbeat-timeout"))
+					srv.SetEvictionGracePeriod(c.Duration("eviction-grace-period"))
+					srv.SetAckTimeout(c.Duration("ack-timeout"))
+					srv.SetClusterSecret(c.String("cluster-secret"))
+					srv.SetAllowedOrigins(c.StringSlice("allowed-origin"))
+					for _, token := range c.StringSlice("api-token") {
+						srv.AddAPIToken(token)
+					}
+
+					if usersFile := c.String("users-file"); usersFile != "" {
+						users, err := server.LoadUsers(usersFile)
+						if err != nil {
+							return err
+						}
+
+						for _, u := range users {
+							srv.AddUser(u)
+						}
+					}
+
+					for _, sinkURL := range c.StringSlice("sink") {
+						sk, err := sink.New(sinkURL)
+						if err != nil {
+							return err
+						}
+
+						srv.AddSink(sk)
+					}
+
+					if n := c.Int("local-workers"); n > 0 {
+						worker.SetLogger(logger)
+
+						for i := 1; i <= n; i++ {
+							name := fmt.Sprintf("local-%d", i)
+							serverConn, workerConn := transport.Pipe()
+
+							w := worker.NewWorker()
+							w.SetName(name)
+							w.SetVersion(version)
+
+							go srv.ServeWorker(serverConn, name, 1)
+							go w.RunWithConn(workerConn)
+						}
+					}
 
 					err := srv.Run(host + ":" + port)
 					defer srv.Close()
@@ -76,7 +241,7 @@ func getCliApp() *cli.App {
 			{
 				Name:  "worker",
 				Usage: "Run worker",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:        "name",
 						Usage:       "name of the worker",
@@ -92,7 +257,41 @@ func getCliApp() *cli.App {
 						Usage: "Server's host port to connect to",
 						Value: "9009",
 					},
-				},
+					&cli.IntFlag{
+						Name:  "weight",
+						Usage: "Weight of the worker when the server divides a total rate across workers",
+						Value: 1,
+					},
+					&cli.StringFlag{
+						Name:  "results-dir",
+						Usage: "Directory to write raw results to, for load tests with a file results output",
+						Value: "terjang-results",
+					},
+					&cli.StringFlag{
+						Name:  "bodies-dir",
+						Usage: "Directory to read the bodies referenced with @path in targets files from. Such targets files are rejected without it",
+					},
+					&cli.DurationFlag{
+						Name:  "connect-retry-interval",
+						Usage: "Time to wait before reconnecting to the server, doubled on each failed attempt",
+						Value: 5 * time.Second,
+					},
+					&cli.DurationFlag{
+						Name:  "max-connect-retry-interval",
+						Usage: "Maximum time to wait before reconnecting to the server",
+						Value: time.Minute,
+					},
+					&cli.DurationFlag{
+						Name:  "heartbeat-timeout",
+						Usage: "How long the server may stay silent before the worker reconnects, raised to fit the server's heartbeat. Zero disables it",
+						Value: 30 * time.Second,
+					},
+					&cli.StringFlag{
+						Name:    "cluster-secret",
+						Usage:   "Secret to present to the server when joining",
+						EnvVars: []string{"TERJANG_CLUSTER_SECRET"},
+					},
+				}, tlsClientFlags()...),
 				Action: func(c *cli.Context) error {
 					name := c.String("name")
 					if name == "" {
@@ -113,12 +312,34 @@ func getCliApp() *cli.App {
 
 					w := worker.NewWorker()
 					w.SetName(name)
+					w.SetVersion(version)
+					w.SetWeight(c.Int("weight"))
+					w.SetResultsDir(c.String("results-dir"))
+					w.SetBodiesDir(c.String("bodies-dir"))
+					w.SetConnectRetryInterval(c.Duration("connect-retry-interval"))
+					w.SetMaxConnectRetryInterval(c.Duration("max-connect-retry-interval"))
+					w.SetHeartbeatTimeout(c.Duration("heartbeat-timeout"))
+					w.SetClusterSecret(c.String("cluster-secret"))
+
+					config, err := tlsClientConfig(c)
+					if err != nil {
+						return err
+					}
+
+					if config != nil {
+						w.SetTLSConfig(config)
+					}
 
 					w.Run(host + ":" + port)
 
 					return nil
 				},
 			},
+			getRunCommand(),
+			getReportCommand(),
+			getResultsCommand(),
+			getPlotCommand(),
+			getAuditCommand(),
 		},
 	}
 }
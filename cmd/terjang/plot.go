@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+func getPlotCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "plot",
+		Usage:     "Download a standalone HTML plot of the latencies of a load test run over time",
+		ArgsUsage: "RUN_ID",
+		Flags: clientFlags(
+			&cli.StringFlag{
+				Name:  "source",
+				Usage: "Data to plot: results (raw results), timeseries (per second percentiles). By default, raw results are plotted when the run has them",
+			},
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "File to write the plot to. By default, the plot is written to stdout",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			id := c.Args().First()
+			if id == "" {
+				return fmt.Errorf("a load test run ID is required, see `terjang plot -h`")
+			}
+
+			out := os.Stdout
+			if path := c.String("output"); path != "" {
+				f, err := os.Create(path)
+				if err != nil {
+					return fmt.Errorf("Failed to create plot file: %w", err)
+				}
+				defer f.Close()
+
+				out = f
+			}
+
+			cl, err := newClient(c)
+			if err != nil {
+				return err
+			}
+
+			return cl.DownloadPlot(id, c.String("source"), out)
+		},
+	}
+}
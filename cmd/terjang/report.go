@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/andylibrian/terjang/pkg/report"
+	cli "github.com/urfave/cli/v2"
+)
+
+func getReportCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "report",
+		Usage:     "Print the report of a load test run",
+		ArgsUsage: "RUN_ID",
+		Flags: clientFlags(
+			&cli.StringFlag{
+				Name:  "type",
+				Usage: "Report type: text, json, hdrplot, hist[buckets] (e.g. hist[0,10ms,50ms,100ms])",
+				Value: report.TypeText,
+			},
+			&cli.StringFlag{
+				Name:  "worker",
+				Usage: "ID of a worker within the run to report on: its name, or name#N for repeated names. By default, the report covers all workers",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			id := c.Args().First()
+			if id == "" {
+				return fmt.Errorf("a load test run ID is required, see `terjang report -h`")
+			}
+
+			cl, err := newClient(c)
+			if err != nil {
+				return err
+			}
+
+			body, err := cl.GetReport(id, c.String("type"), c.String("worker"))
+			if err != nil {
+				return err
+			}
+
+			_, err = os.Stdout.Write(body)
+
+			return err
+		},
+	}
+}
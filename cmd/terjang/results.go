@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	cli "github.com/urfave/cli/v2"
+)
+
+func getResultsCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "results",
+		Usage:     "Download the raw results of a load test run, e.g. to analyze them with vegeta",
+		ArgsUsage: "RUN_ID",
+		Flags: clientFlags(
+			&cli.StringFlag{
+				Name:  "encoding",
+				Usage: "Results encoding: gob, csv, json",
+				Value: messages.ResultsEncodingGob,
+			},
+			&cli.StringFlag{
+				Name:  "worker",
+				Usage: "Name of a worker to download the results of. By default, the results of all workers are downloaded",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			id := c.Args().First()
+			if id == "" {
+				return fmt.Errorf("a load test run ID is required, see `terjang results -h`")
+			}
+
+			cl, err := newClient(c)
+			if err != nil {
+				return err
+			}
+
+			return cl.DownloadResults(id, c.String("encoding"), c.String("worker"), os.Stdout)
+		},
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/report"
+	cli "github.com/urfave/cli/v2"
+)
+
+func getRunCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "run",
+		Usage: "Run a load test on a remote server and wait for its result",
+		Flags: clientFlags(
+			&cli.StringFlag{
+				Name:  "method",
+				Usage: "HTTP method of the target",
+				Value: "GET",
+			},
+			&cli.StringFlag{
+				Name:  "url",
+				Usage: "URL of the target",
+			},
+			&cli.StringSliceFlag{
+				Name:  "header",
+				Usage: "Header of the target, as \"Key: Value\". Can be repeated",
+			},
+			&cli.StringFlag{
+				Name:  "body",
+				Usage: "Body of the target",
+			},
+			&cli.StringFlag{
+				Name:  "targets-file",
+				Usage: "Path of a vegeta targets file, used instead of --method, --url, --header and --body. Bodies referenced with @path are read from the workers' --bodies-dir",
+			},
+			&cli.StringFlag{
+				Name:  "targets-format",
+				Usage: "Format of the targets file: http, json",
+				Value: messages.TargetsFormatHTTP,
+			},
+			&cli.Uint64Flag{
+				Name:  "duration",
+				Usage: "Duration of the load test in seconds",
+				Value: 10,
+			},
+			&cli.Uint64Flag{
+				Name:  "rate",
+				Usage: "Number of requests per second",
+				Value: 10,
+			},
+			&cli.StringFlag{
+				Name:  "rate-mode",
+				Usage: "How the rate applies to the workers: per_worker, total",
+				Value: messages.RateModePerWorker,
+			},
+			&cli.BoolFlag{
+				Name:  "template",
+				Usage: "Render the targets as templates for every request",
+			},
+			&cli.StringFlag{
+				Name:  "data-feed",
+				Usage: "Path of a CSV or JSON data file used by the request templates",
+			},
+			&cli.StringSliceFlag{
+				Name:  "threshold",
+				Usage: "Pass/fail criterion, e.g. \"p99 < 300ms\", \"success > 99.5%\" or \"no 5xx\". Can be repeated",
+			},
+			&cli.BoolFlag{
+				Name:  "abort-on-breach",
+				Usage: "Stop the load test as soon as a latency, success or status threshold is breached",
+			},
+			&cli.StringFlag{
+				Name:  "results-output",
+				Usage: "Where workers keep raw results: server, file. Raw results are discarded by default",
+			},
+			&cli.StringFlag{
+				Name:  "results-encoding",
+				Usage: "Encoding of the raw results: gob, csv, json",
+				Value: messages.ResultsEncodingGob,
+			},
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "Format of the final report: text, json",
+				Value: "text",
+			},
+			&cli.BoolFlag{
+				Name:  "quiet",
+				Usage: "Do not print progress while the load test runs",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			req, err := newStartLoadTestRequest(c)
+			if err != nil {
+				return err
+			}
+
+			output := c.String("output")
+			if output != "text" && output != "json" {
+				return fmt.Errorf("unknown output format %q", output)
+			}
+
+			cl, err := newClient(c)
+			if err != nil {
+				return err
+			}
+
+			run, err := cl.StartLoadTest(req)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(os.Stderr, "Started load test %s\n", run.ID)
+			for _, ack := range run.Acks {
+				switch {
+				case ack.Error != "":
+					fmt.Fprintf(os.Stderr, "Worker %s: %s, %s\n", ack.Name, ack.Status, ack.Error)
+				case ack.Status != messages.AckStatusAccepted:
+					fmt.Fprintf(os.Stderr, "Worker %s: %s\n", ack.Name, ack.Status)
+				}
+			}
+
+			// Stop the load test when interrupted, and wait for its final result.
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+			defer signal.Stop(signals)
+
+			go func() {
+				<-signals
+				fmt.Fprintln(os.Stderr, "Stopping load test")
+				cl.StopLoadTest()
+			}()
+
+			var progress func(messages.LoadTestMetrics)
+			if !c.Bool("quiet") {
+				var lastRequests uint64
+				progress = func(m messages.LoadTestMetrics) {
+					if m.Requests == lastRequests {
+						return
+					}
+
+					lastRequests = m.Requests
+					printProgress(os.Stderr, &m)
+				}
+			}
+
+			run, err = cl.WaitForRun(context.Background(), run.ID, progress)
+			if err != nil {
+				return err
+			}
+
+			if output == "json" {
+				run.TimeSeries = nil
+				out, _ := json.MarshalIndent(run, "", "  ")
+				fmt.Fprintln(os.Stdout, string(out))
+			} else {
+				printRunReport(os.Stdout, run)
+			}
+
+			if run.Verdict != nil && !run.Verdict.Passed {
+				return cli.Exit("Thresholds failed", 1)
+			}
+
+			return nil
+		},
+	}
+}
+
+func newStartLoadTestRequest(c *cli.Context) (*messages.StartLoadTestRequest, error) {
+	req := &messages.StartLoadTestRequest{
+		Method:          c.String("method"),
+		URL:             c.String("url"),
+		Header:          strings.Join(c.StringSlice("header"), "\n"),
+		Body:            c.String("body"),
+		Duration:        c.Uint64("duration"),
+		Rate:            c.Uint64("rate"),
+		RateMode:        c.String("rate-mode"),
+		Template:        c.Bool("template"),
+		Thresholds:      c.StringSlice("threshold"),
+		AbortOnBreach:   c.Bool("abort-on-breach"),
+		ResultsOutput:   c.String("results-output"),
+		ResultsEncoding: c.String("results-encoding"),
+	}
+
+	if path := c.String("targets-file"); path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read targets file: %w", err)
+		}
+
+		req.TargetsFile = string(content)
+		req.TargetsFormat = c.String("targets-format")
+	} else if req.URL == "" {
+		return nil, fmt.Errorf("either --url or --targets-file is required")
+	}
+
+	if path := c.String("data-feed"); path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read data feed: %w", err)
+		}
+
+		req.DataFeed = string(content)
+		if strings.HasSuffix(strings.ToLower(path), ".json") {
+			req.DataFeedFormat = messages.DataFeedFormatJSON
+		}
+	}
+
+	return req, nil
+}
+
+func printProgress(w io.Writer, m *messages.LoadTestMetrics) {
+	fmt.Fprintf(w, "[%6s] requests: %d, rate: %.2f/s, success: %.2f%%, p99: %s\n",
+		m.Duration.Truncate(time.Second), m.Requests, m.Rate, m.Success*100, m.Latencies.P99)
+}
+
+func printRunReport(w io.Writer, run *messages.LoadTestRun) {
+	fmt.Fprintf(w, "Run %s (%s), %d worker(s)\n", run.ID, run.State, run.Metrics.NumOfWorkers)
+	report.Write(w, report.TypeText, &run.Metrics, run.StartedAt)
+
+	if run.Verdict == nil {
+		return
+	}
+
+	fmt.Fprintln(w, "Thresholds:")
+	for _, t := range run.Verdict.Thresholds {
+		status := "PASS"
+		if !t.Passed {
+			status = "FAIL"
+		}
+
+		fmt.Fprintf(w, "  %s  %s (actual: %s)\n", status, t.Threshold, t.Actual)
+	}
+
+	if run.Verdict.Aborted {
+		fmt.Fprintf(w, "Aborted: threshold %q breached\n", run.Verdict.AbortedBy)
+	}
+
+	if run.Verdict.Passed {
+		fmt.Fprintln(w, "Verdict: PASSED")
+	} else {
+		fmt.Fprintln(w, "Verdict: FAILED")
+	}
+}
@@ -0,0 +1,97 @@
+// Package audit records who started and stopped load tests, and other operations affecting the cluster.
+package audit
+
+import (
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+)
+
+// ActionStartLoadTest is recorded when a load test is started.
+const ActionStartLoadTest = "load_test.start"
+
+// ActionStopLoadTest is recorded when a load test is stopped, on request or automatically.
+const ActionStopLoadTest = "load_test.stop"
+
+// ActionRemoveWorker is recorded when workers are disconnected by an admin.
+const ActionRemoveWorker = "worker.remove"
+
+// UserSystem is the user of the operations the server performs on its own, e.g. aborting a load test
+// whose threshold was breached, or called in-process rather than through the API.
+const UserSystem = "system"
+
+// UserAnonymous is the user of the API calls when the API is open.
+const UserAnonymous = "anonymous"
+
+// Event is an entry of the audit log.
+type Event struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	// User is the name of the API user who performed the action, UserAnonymous or UserSystem.
+	User string `json:"user"`
+	Role string `json:"role,omitempty"`
+	// RemoteAddr is the address of the API caller.
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	// ForwardedFor is the X-Forwarded-For header of the API call, when the server is behind a proxy.
+	ForwardedFor string `json:"forwarded_for,omitempty"`
+	RunID        string `json:"run_id,omitempty"`
+	// Request is the full load test request, for started load tests.
+	Request *messages.StartLoadTestRequest `json:"request,omitempty"`
+	// Workers are the names of the affected workers.
+	Workers []string `json:"workers,omitempty"`
+	// Reason explains automatic actions, e.g. the breached threshold of an aborted load test.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Query selects events of the audit log. Empty fields match all events.
+type Query struct {
+	Action string
+	User   string
+	RunID  string
+	Since  time.Time
+	Until  time.Time
+	// Limit keeps the most recent events only, when positive.
+	Limit int
+}
+
+// Log is an append-only audit log.
+type Log interface {
+	// Append records an event.
+	Append(e *Event) error
+	// Query returns the events matching a query, in chronological order.
+	Query(q Query) ([]Event, error)
+}
+
+// Matches reports whether an event is selected by the query, regardless of its limit.
+func (q Query) Matches(e *Event) bool {
+	if q.Action != "" && e.Action != q.Action {
+		return false
+	}
+
+	if q.User != "" && e.User != q.User {
+		return false
+	}
+
+	if q.RunID != "" && e.RunID != q.RunID {
+		return false
+	}
+
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+
+	if !q.Until.IsZero() && !e.Time.Before(q.Until) {
+		return false
+	}
+
+	return true
+}
+
+// limit keeps the last events allowed by the query.
+func (q Query) limit(events []Event) []Event {
+	if q.Limit > 0 && len(events) > q.Limit {
+		return events[len(events)-q.Limit:]
+	}
+
+	return events
+}
@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileLog is a Log that appends events to a file, as JSON lines.
+type FileLog struct {
+	path string
+	file *os.File
+	lock sync.RWMutex
+}
+
+// NewFileLog opens the audit log file at path, creating it and its directory if needed.
+// Events are appended to the existing ones.
+func NewFileLog(path string) (*FileLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create audit log directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open audit log: %w", err)
+	}
+
+	return &FileLog{path: path, file: file}, nil
+}
+
+// Append records an event. It returns once the event is written to disk.
+func (f *FileLog) Append(e *Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if _, err := f.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("Failed to write audit log: %w", err)
+	}
+
+	return f.file.Sync()
+}
+
+// Query returns the events matching a query, in chronological order.
+func (f *FileLog) Query(q Query) ([]Event, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read audit log: %w", err)
+	}
+	defer file.Close()
+
+	events := make([]Event, 0)
+	reader := bufio.NewReader(file)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var e Event
+			// A line left incomplete by a crash is skipped rather than failing all queries.
+			if json.Unmarshal(line, &e) == nil && q.Matches(&e) {
+				events = append(events, e)
+			}
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Failed to read audit log: %w", err)
+		}
+	}
+
+	return q.limit(events), nil
+}
+
+// Close closes the audit log file.
+func (f *FileLog) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.file.Close()
+}
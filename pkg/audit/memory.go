@@ -0,0 +1,39 @@
+package audit
+
+import "sync"
+
+// MemoryLog is a Log that keeps events in memory. Events are lost when the server stops.
+type MemoryLog struct {
+	events []Event
+	lock   sync.RWMutex
+}
+
+// NewMemoryLog creates an empty in-memory audit log.
+func NewMemoryLog() *MemoryLog {
+	return &MemoryLog{}
+}
+
+// Append records an event.
+func (m *MemoryLog) Append(e *Event) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.events = append(m.events, *e)
+
+	return nil
+}
+
+// Query returns the events matching a query, in chronological order.
+func (m *MemoryLog) Query(q Query) ([]Event, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	events := make([]Event, 0)
+	for i := range m.events {
+		if q.Matches(&m.events[i]) {
+			events = append(events, m.events[i])
+		}
+	}
+
+	return q.limit(events), nil
+}
@@ -0,0 +1,338 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/audit"
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/gorilla/websocket"
+)
+
+// Client is a client of the HTTP API of a terjang server.
+type Client struct {
+	addr       string
+	httpClient *http.Client
+	dialer     *websocket.Dialer
+	token      string
+	tls        bool
+}
+
+// NewClient creates a client of the server listening on addr (host:port).
+func NewClient(addr string) *Client {
+	return &Client{
+		addr:       addr,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		dialer:     websocket.DefaultDialer,
+	}
+}
+
+// SetToken sets the API token sent to the server, for servers that require one.
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+// SetTLSConfig makes the client connect to the server with HTTPS and secure websockets (wss) and a TLS
+// configuration, e.g. from tlsconfig.Client.
+func (c *Client) SetTLSConfig(config *tls.Config) {
+	c.tls = true
+	c.httpClient.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: config}
+
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = config
+	c.dialer = &dialer
+}
+
+func (c *Client) url(path string) string {
+	if c.tls {
+		return "https://" + c.addr + path
+	}
+
+	return "http://" + c.addr + path
+}
+
+// header returns the headers sent with every request.
+func (c *Client) header() http.Header {
+	header := http.Header{}
+	if c.token != "" {
+		header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	return header
+}
+
+// StartLoadTest asks the server to start a load test and returns the new run.
+func (c *Client) StartLoadTest(req *messages.StartLoadTestRequest) (*messages.LoadTestRun, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var run messages.LoadTestRun
+	if err := c.do(http.MethodPost, "/api/v1/load_test", bytes.NewReader(body), &run); err != nil {
+		return nil, fmt.Errorf("Failed to start load test: %w", err)
+	}
+
+	return &run, nil
+}
+
+// StopLoadTest asks the server to stop the running load test, and returns how each worker answered.
+func (c *Client) StopLoadTest() ([]messages.WorkerAck, error) {
+	var result messages.StopLoadTestResult
+	if err := c.do(http.MethodDelete, "/api/v1/load_test", nil, &result); err != nil {
+		return nil, fmt.Errorf("Failed to stop load test: %w", err)
+	}
+
+	return result.Acks, nil
+}
+
+// RemoveWorker asks the server to disconnect the workers with a name.
+func (c *Client) RemoveWorker(name string) error {
+	if err := c.do(http.MethodDelete, "/api/v1/workers/"+url.PathEscape(name), nil, nil); err != nil {
+		return fmt.Errorf("Failed to remove worker: %w", err)
+	}
+
+	return nil
+}
+
+// GetAuditLog returns the events of the server's audit log matching a query, in chronological order.
+func (c *Client) GetAuditLog(q audit.Query) ([]audit.Event, error) {
+	query := url.Values{}
+	for name, value := range map[string]string{"action": q.Action, "user": q.User, "run_id": q.RunID} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if !q.Since.IsZero() {
+		query.Set("since", q.Since.Format(time.RFC3339))
+	}
+	if !q.Until.IsZero() {
+		query.Set("until", q.Until.Format(time.RFC3339))
+	}
+	if q.Limit > 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+
+	var events []audit.Event
+	if err := c.do(http.MethodGet, "/api/v1/audit?"+query.Encode(), nil, &events); err != nil {
+		return nil, fmt.Errorf("Failed to get audit log: %w", err)
+	}
+
+	return events, nil
+}
+
+// GetRun returns a load test run.
+func (c *Client) GetRun(id string) (*messages.LoadTestRun, error) {
+	var run messages.LoadTestRun
+	if err := c.do(http.MethodGet, "/api/v1/load_tests/"+url.PathEscape(id), nil, &run); err != nil {
+		return nil, fmt.Errorf("Failed to get load test run: %w", err)
+	}
+
+	return &run, nil
+}
+
+// GetReport returns a load test run rendered as one of vegeta's reports: text, json, hdrplot or hist[buckets].
+// The report covers all workers, or a single one when worker is not empty.
+func (c *Client) GetReport(id string, typ string, worker string) ([]byte, error) {
+	query := url.Values{}
+	if typ != "" {
+		query.Set("type", typ)
+	}
+	if worker != "" {
+		query.Set("worker", worker)
+	}
+
+	body, err := c.send(http.MethodGet, "/api/v1/load_tests/"+url.PathEscape(id)+"/report?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get load test report: %w", err)
+	}
+
+	return body, nil
+}
+
+// DownloadResults writes the raw results of a load test run to w, in one of vegeta's encodings:
+// gob, csv or json. The results cover all workers, or a single one when worker is not empty.
+func (c *Client) DownloadResults(id string, encoding string, worker string, w io.Writer) error {
+	query := url.Values{}
+	if encoding != "" {
+		query.Set("encoding", encoding)
+	}
+	if worker != "" {
+		query.Set("worker", worker)
+	}
+
+	return c.download("/api/v1/load_tests/"+url.PathEscape(id)+"/results?"+query.Encode(), "results", w)
+}
+
+// DownloadPlot writes the standalone HTML latency plot of a load test run to w.
+// source selects the data plotted: results, timeseries or empty to let the server pick.
+func (c *Client) DownloadPlot(id string, source string, w io.Writer) error {
+	query := url.Values{}
+	if source != "" {
+		query.Set("source", source)
+	}
+
+	return c.download("/api/v1/load_tests/"+url.PathEscape(id)+"/plot?"+query.Encode(), "plot", w)
+}
+
+// download streams the response body of a GET request to w.
+func (c *Client) download(path string, what string, w io.Writer) error {
+	req, err := http.NewRequest(http.MethodGet, c.url(path), nil)
+	if err != nil {
+		return fmt.Errorf("Failed to download %s: %w", what, err)
+	}
+
+	req.Header = c.header()
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to download %s: %w", what, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Failed to download %s: server responded with %s: %s", what, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("Failed to download %s: %w", what, err)
+	}
+
+	return nil
+}
+
+func (c *Client) do(method string, path string, body io.Reader, result interface{}) error {
+	respBody, err := c.send(method, path, body)
+	if err != nil {
+		return err
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(respBody, result)
+}
+
+// send sends a request to the server and returns the body of a successful response.
+func (c *Client) send(method string, path string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequest(method, c.url(path), body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = c.header()
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("server responded with %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	return respBody, nil
+}
+
+// Follow subscribes to the server's notifications and calls handle for each of them,
+// until the context is done or the connection is closed.
+func (c *Client) Follow(ctx context.Context, handle func(messages.Envelope)) error {
+	u := url.URL{Scheme: "ws", Host: c.addr, Path: "/notifications"}
+	if c.tls {
+		u.Scheme = "wss"
+	}
+
+	conn, _, err := c.dialer.DialContext(ctx, u.String(), c.header())
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to notifications: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			return fmt.Errorf("Notifications connection closed: %w", err)
+		}
+
+		var envelope messages.Envelope
+		if err := json.Unmarshal(message, &envelope); err != nil {
+			continue
+		}
+
+		handle(envelope)
+	}
+}
+
+// WaitForRun follows the progress of a load test run until it ends, and returns the final run.
+// progress, if not nil, is called with the aggregated metrics every time the server publishes them.
+func (c *Client) WaitForRun(ctx context.Context, id string, progress func(messages.LoadTestMetrics)) (*messages.LoadTestRun, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var run *messages.LoadTestRun
+	var runErr error
+
+	err := c.Follow(ctx, func(envelope messages.Envelope) {
+		switch envelope.Kind {
+		case messages.KindLoadTestMetrics:
+			if progress == nil {
+				return
+			}
+
+			var metrics messages.LoadTestMetrics
+			if err := json.Unmarshal([]byte(envelope.Data), &metrics); err == nil {
+				progress(metrics)
+			}
+		case messages.KindServerInfo:
+			// The server publishes its info every second, check whether the run ended.
+			r, err := c.GetRun(id)
+			if err != nil {
+				runErr = err
+				cancel()
+				return
+			}
+
+			if r.EndedAt != nil {
+				run = r
+				cancel()
+			}
+		}
+	})
+
+	if run != nil {
+		return run, nil
+	}
+
+	if runErr != nil {
+		return nil, runErr
+	}
+
+	return nil, err
+}
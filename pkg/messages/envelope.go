@@ -0,0 +1,148 @@
+package messages
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// EnvelopeEncodingJSONString is the original envelope encoding, an Envelope whose data is a JSON document
+// encoded as a JSON string. Peers older than the envelope encoding negotiation only understand this one.
+const EnvelopeEncodingJSONString = "json-string"
+
+// EnvelopeEncodingJSON is a RawEnvelope, whose data is embedded as JSON rather than encoded twice.
+const EnvelopeEncodingJSON = "json"
+
+// EnvelopeEncodings returns the envelope encodings this version writes, in order of preference.
+// Both are always read, whatever the negotiated encoding.
+func EnvelopeEncodings() []string {
+	return []string{EnvelopeEncodingJSON, EnvelopeEncodingJSONString}
+}
+
+// RawEnvelope is a messaging wrapper whose data is embedded as JSON.
+type RawEnvelope struct {
+	Kind string          `json:"kind"`
+	Data json.RawMessage `json:"data,omitempty"`
+	ID   string          `json:"id,omitempty"`
+}
+
+// nolint: gochecknoglobals
+var (
+	payloadTypes = map[string]func() interface{}{
+		KindStartLoadTestRequest:     func() interface{} { return &StartLoadTestRequest{} },
+		KindRebalanceLoadTestRequest: func() interface{} { return &RebalanceLoadTestRequest{} },
+		KindWorkerLoadTestMetrics:    func() interface{} { return &WorkerLoadTestMetrics{} },
+		KindWorkerResults:            func() interface{} { return &WorkerResults{} },
+		KindWorkerInfo:               func() interface{} { return &WorkerInfo{} },
+		KindServerInfo:               func() interface{} { return &ServerInfo{} },
+		KindLoadTestMetrics:          func() interface{} { return &LoadTestMetrics{} },
+		KindLoadTestTimeSeries:       func() interface{} { return &[]MetricsInterval{} },
+		KindHello:                    func() interface{} { return &Hello{} },
+		KindWelcome:                  func() interface{} { return &Welcome{} },
+		KindAck:                      func() interface{} { return &Ack{} },
+	}
+	payloadTypesLock sync.RWMutex
+)
+
+// RegisterKind registers the type of the data of a kind of message. newPayload returns a pointer to a new value
+// of the type, which Decode fills. Kinds without data, like KindStopLoadTestRequest, need no registration.
+func RegisterKind(kind string, newPayload func() interface{}) {
+	payloadTypesLock.Lock()
+	defer payloadTypesLock.Unlock()
+
+	payloadTypes[kind] = newPayload
+}
+
+// Encode wraps the data of a message in an envelope with an envelope encoding. The data may be nil.
+func Encode(encoding string, kind string, payload interface{}) ([]byte, error) {
+	return EncodeCommand(encoding, "", kind, payload)
+}
+
+// EncodeCommand is like Encode, with an ID the receiver acknowledges the message with.
+func EncodeCommand(encoding string, id string, kind string, payload interface{}) ([]byte, error) {
+	var data []byte
+	if payload != nil {
+		var err error
+		if data, err = json.Marshal(payload); err != nil {
+			return nil, fmt.Errorf("Failed to encode %s: %w", kind, err)
+		}
+	}
+
+	if encoding == EnvelopeEncodingJSON {
+		return json.Marshal(RawEnvelope{Kind: kind, Data: data, ID: id})
+	}
+
+	return json.Marshal(Envelope{Kind: kind, Data: string(data), ID: id})
+}
+
+// Decode unwraps a message in either envelope encoding. The data is decoded into a new value of the type
+// registered for the kind, returned as a pointer. It is nil for kinds without data or without a registered type.
+func Decode(message []byte) (string, interface{}, error) {
+	var envelope RawEnvelope
+	if err := json.Unmarshal(message, &envelope); err != nil {
+		return "", nil, fmt.Errorf("Failed to decode envelope: %w", err)
+	}
+
+	data := []byte(envelope.Data)
+
+	// The original encoding holds the data as a JSON string.
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return envelope.Kind, nil, fmt.Errorf("Failed to decode envelope: %w", err)
+		}
+
+		data = []byte(s)
+	}
+
+	payloadTypesLock.RLock()
+	newPayload, ok := payloadTypes[envelope.Kind]
+	payloadTypesLock.RUnlock()
+
+	if !ok || len(data) == 0 || string(data) == "null" {
+		return envelope.Kind, nil, nil
+	}
+
+	payload := newPayload()
+	if err := json.Unmarshal(data, payload); err != nil {
+		return envelope.Kind, nil, fmt.Errorf("Failed to decode %s: %w", envelope.Kind, err)
+	}
+
+	return envelope.Kind, payload, nil
+}
+
+// KindOf returns the kind of a message in either envelope encoding, without decoding its data.
+func KindOf(message []byte) string {
+	kind, _ := header(message)
+	return kind
+}
+
+// IDOf returns the ID of a message in either envelope encoding, empty for messages that are not commands.
+func IDOf(message []byte) string {
+	_, id := header(message)
+	return id
+}
+
+func header(message []byte) (string, string) {
+	var envelope struct {
+		Kind string `json:"kind"`
+		ID   string `json:"id"`
+	}
+	json.Unmarshal(message, &envelope)
+
+	return envelope.Kind, envelope.ID
+}
+
+// NegotiateEnvelopeEncoding returns the first of the offered envelope encodings this version writes,
+// or EnvelopeEncodingJSONString when there is none.
+func NegotiateEnvelopeEncoding(offered []string) string {
+	for _, offer := range offered {
+		for _, supported := range EnvelopeEncodings() {
+			if offer == supported {
+				return offer
+			}
+		}
+	}
+
+	return EnvelopeEncodingJSONString
+}
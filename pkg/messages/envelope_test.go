@@ -0,0 +1,63 @@
+package messages
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		kind    string
+		payload interface{}
+		wantErr string
+	}{
+		{"string data", `{"kind":"Ack","data":"{\"id\":\"1\",\"error\":\"busy\"}"}`, KindAck, &Ack{ID: "1", Error: "busy"}, ""},
+		{"raw data", `{"kind":"Ack","data":{"id":"1","error":"busy"}}`, KindAck, &Ack{ID: "1", Error: "busy"}, ""},
+		{"empty string data", `{"kind":"Ack","data":""}`, KindAck, nil, ""},
+		{"null data", `{"kind":"Ack","data":null}`, KindAck, nil, ""},
+		{"no data", `{"kind":"StopLoadTestRequest"}`, KindStopLoadTestRequest, nil, ""},
+		{"unregistered kind", `{"kind":"unknown","data":{"a":1}}`, "unknown", nil, ""},
+		{"invalid envelope", `{"kind":`, "", nil, "Failed to decode envelope"},
+		{"invalid string data", `{"kind":"Ack","data":"{\"id\":1}"}`, KindAck, nil, "Failed to decode Ack"},
+		{"invalid raw data", `{"kind":"Ack","data":{"id":1}}`, KindAck, nil, "Failed to decode Ack"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, payload, err := Decode([]byte(tt.message))
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, tt.kind, kind)
+			assert.Equal(t, tt.payload, payload)
+		})
+	}
+}
+
+func TestNegotiateEnvelopeEncoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		offered []string
+		want    string
+	}{
+		{"nothing offered", nil, EnvelopeEncodingJSONString},
+		{"only unknown", []string{"msgpack"}, EnvelopeEncodingJSONString},
+		{"json", []string{EnvelopeEncodingJSON}, EnvelopeEncodingJSON},
+		{"json-string", []string{EnvelopeEncodingJSONString}, EnvelopeEncodingJSONString},
+		{"first supported offer", []string{"msgpack", EnvelopeEncodingJSONString, EnvelopeEncodingJSON}, EnvelopeEncodingJSONString},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, NegotiateEnvelopeEncoding(tt.offered))
+		})
+	}
+}
@@ -3,13 +3,17 @@ package messages
 import (
 	"time"
 
+	"github.com/influxdata/tdigest"
 	vegeta "github.com/tsenart/vegeta/v12/lib"
 )
 
 // Envelope is a messaging wrapper for the communication between the server, workers, and UI.
+// Its data is a JSON document encoded as a string, see RawEnvelope for workers negotiating EnvelopeEncodingJSON.
+// Commands to workers carry an ID, which workers acknowledge with a KindAck message.
 type Envelope struct {
 	Kind string `json:"kind"`
 	Data string `json:"data"`
+	ID   string `json:"id,omitempty"`
 }
 
 // KindStartLoadTestRequest is a kind that indicates a request to start a load test.
@@ -18,9 +22,33 @@ const KindStartLoadTestRequest = "StartLoadTestRequest"
 // KindStopLoadTestRequest is a kind that indicates a request to stop a load test.
 const KindStopLoadTestRequest = "StopLoadTestRequest"
 
+// KindRebalanceLoadTestRequest is a kind that indicates a request to change a worker's share of the total rate.
+const KindRebalanceLoadTestRequest = "RebalanceLoadTestRequest"
+
+// KindRemoveWorkerRequest is a kind that indicates a request to a worker to leave the cluster without reconnecting.
+const KindRemoveWorkerRequest = "RemoveWorkerRequest"
+
+// KindHello is a kind that indicates the first message of a worker, describing its protocol version and features.
+const KindHello = "Hello"
+
+// KindWelcome is a kind that indicates the server's reply to a worker's hello, accepting or rejecting the worker.
+const KindWelcome = "Welcome"
+
+// KindPing is a kind that indicates a heartbeat request, the receiver replies with a KindPong message.
+const KindPing = "Ping"
+
+// KindPong is a kind that indicates a reply to a heartbeat request.
+const KindPong = "Pong"
+
+// KindAck is a kind that indicates a worker's reply to a command with an ID, accepting or rejecting it.
+const KindAck = "Ack"
+
 // KindWorkerLoadTestMetrics is a kind that indicates the envelope contains load test metrics from worker.
 const KindWorkerLoadTestMetrics = "WorkerLoadTestMetrics"
 
+// KindWorkerResults is a kind that indicates the envelope contains raw load test results from worker.
+const KindWorkerResults = "WorkerResults"
+
 // KindServerInfo is a kind that indicates the envelope contains server info.
 const KindServerInfo = "ServerInfo"
 
@@ -30,17 +58,154 @@ const KindWorkerInfo = "WorkerInfo"
 // KindWorkersInfo is a kind that indicates the envelope contains workers info.
 const KindWorkersInfo = "WorkersInfo"
 
+// KindLoadTestMetrics is a kind that indicates the envelope contains load test metrics aggregated from all workers.
+const KindLoadTestMetrics = "LoadTestMetrics"
+
+// KindLoadTestTimeSeries is a kind that indicates the envelope contains time series points updated since the previous notification.
+const KindLoadTestTimeSeries = "LoadTestTimeSeries"
+
 // StartLoadTestRequest is a struct type containing the detail of a load test request.
 // It is sent from server to workers. Upon receiving this, workers should start
 // running the load test.
 type StartLoadTestRequest struct {
+	// RunID is assigned by the server to identify the load test run.
+	RunID    string `json:"run_id,omitempty"`
 	Method   string `json:"method"`
 	URL      string `json:"url"`
 	Duration uint64 `json:"duration,string"`
-	// Rate per worker
+	// Rate per worker, or for the whole cluster if RateMode is RateModeTotal
 	Rate   uint64 `json:"rate,string"`
 	Header string `json:"header"`
 	Body   string `json:"body"`
+
+	// RateMode is either RateModePerWorker (default) or RateModeTotal.
+	RateMode string `json:"rate_mode,omitempty"`
+	// Share is the fraction of the total load profile a worker runs. It is assigned
+	// by the server to each worker when RateMode is RateModeTotal.
+	Share float64 `json:"share,omitempty"`
+	// WorkerID identifies the worker within the run: its name, followed by "#<n>" when other workers of the run
+	// have the same name. It is assigned by the server to each worker, which announces it when resuming the run.
+	WorkerID string `json:"worker_id,omitempty"`
+
+	// Pacer is the load profile of the test: PacerConstant (default), PacerLinear, PacerSine or PacerStages.
+	Pacer string `json:"pacer,omitempty"`
+	// Slope is the rate increase per second of the linear pacer. The linear pacer starts at Rate.
+	Slope float64 `json:"slope,omitempty,string"`
+	// SinePeriod is the period of the sine pacer in seconds. The sine wave oscillates around Rate.
+	SinePeriod uint64 `json:"sine_period,omitempty,string"`
+	// SineAmplitude is the amplitude of the sine pacer in requests per second. It must be lower than Rate.
+	SineAmplitude uint64 `json:"sine_amplitude,omitempty,string"`
+	// SineStartAt is where the sine wave starts: "mean-up" (default), "peak", "mean-down" or "trough".
+	SineStartAt string `json:"sine_start_at,omitempty"`
+	// Stages are executed in order by the stages pacer, starting at Rate. Duration is ignored,
+	// the test runs for the sum of the stages' durations.
+	Stages []LoadTestStage `json:"stages,omitempty"`
+
+	// Targets are hit instead of Method, URL, Header and Body when not empty. They are hit
+	// in turn, or proportionally to their weights if any target has a weight.
+	Targets []LoadTestTarget `json:"targets,omitempty"`
+	// TargetsFile is the content of a vegeta targets file. When not empty, its targets are hit in turn
+	// instead of Targets. Bodies referenced with @path are read from the bodies directory of the workers, which
+	// reject such targets files when they have none.
+	TargetsFile string `json:"targets_file,omitempty"`
+	// TargetsFormat is the format of TargetsFile: TargetsFormatHTTP (default) or TargetsFormatJSON.
+	TargetsFormat string `json:"targets_format,omitempty"`
+
+	// Template enables Go text/template placeholders in the targets' URL, header values and body,
+	// evaluated for every request. Templates can use .Seq (the worker's request sequence number),
+	// .Worker, .RunID, .Timestamp and .Data (the current DataFeed record), and the functions
+	// uuid and randInt, e.g. {{uuid}} or {{randInt 1 100}}.
+	Template bool `json:"template,omitempty"`
+	// DataFeed is the content of a data file whose records are used in turn by the requests' templates.
+	DataFeed string `json:"data_feed,omitempty"`
+	// DataFeedFormat is the format of DataFeed: DataFeedFormatCSV (default) or DataFeedFormatJSON.
+	DataFeedFormat string `json:"data_feed_format,omitempty"`
+
+	// Thresholds are pass/fail criteria evaluated on the aggregated metrics, e.g. "p99 < 300ms",
+	// "success > 99.5%" or "no 5xx".
+	Thresholds []string `json:"thresholds,omitempty"`
+	// AbortOnBreach stops the load test as soon as a threshold is breached.
+	AbortOnBreach bool `json:"abort_on_breach,omitempty"`
+
+	// ResultsOutput is where workers keep the raw result of every request: ResultsOutputServer or
+	// ResultsOutputFile. Raw results are discarded by default.
+	ResultsOutput string `json:"results_output,omitempty"`
+	// ResultsEncoding is the vegeta encoding of the raw results written to files: ResultsEncodingGob (default),
+	// ResultsEncodingCSV or ResultsEncodingJSON. Results streamed to the server are JSON encoded, and can be
+	// downloaded in any encoding.
+	ResultsEncoding string `json:"results_encoding,omitempty"`
+}
+
+// ResultsOutputServer streams raw results to the server, which stores them with the run.
+const ResultsOutputServer = "server"
+
+// ResultsOutputFile writes raw results to a file in the worker's results directory.
+const ResultsOutputFile = "file"
+
+// ResultsEncodingGob is vegeta's gob encoding of results.
+const ResultsEncodingGob = "gob"
+
+// ResultsEncodingCSV is vegeta's CSV encoding of results.
+const ResultsEncodingCSV = "csv"
+
+// ResultsEncodingJSON is vegeta's JSON encoding of results.
+const ResultsEncodingJSON = "json"
+
+// DataFeedFormatCSV is a CSV data feed. The first row holds the names of the columns.
+const DataFeedFormatCSV = "csv"
+
+// DataFeedFormatJSON is a JSON data feed holding an array of objects.
+const DataFeedFormatJSON = "json"
+
+// TargetsFormatHTTP is vegeta's HTTP targets file format.
+const TargetsFormatHTTP = "http"
+
+// TargetsFormatJSON is vegeta's JSON targets file format, one JSON target per line.
+const TargetsFormatJSON = "json"
+
+// LoadTestTarget is a request to send during a load test.
+type LoadTestTarget struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Header string `json:"header"`
+	Body   string `json:"body"`
+	// Weight is the relative frequency the target is hit with. Targets without weight count as 1.
+	Weight uint64 `json:"weight,omitempty,string"`
+}
+
+// RateModePerWorker makes each worker run the load profile as requested.
+const RateModePerWorker = "per_worker"
+
+// RateModeTotal divides the load profile across the workers, according to their weight.
+const RateModeTotal = "total"
+
+// RebalanceLoadTestRequest is sent from server to a worker when the cluster changes during
+// a load test in RateModeTotal. The worker continues the load test with its new share.
+type RebalanceLoadTestRequest struct {
+	RunID string  `json:"run_id"`
+	Share float64 `json:"share"`
+}
+
+// PacerConstant sends requests at a constant rate.
+const PacerConstant = "constant"
+
+// PacerLinear increases the rate linearly over time.
+const PacerLinear = "linear"
+
+// PacerSine varies the rate along a sine wave.
+const PacerSine = "sine"
+
+// PacerStages ramps the rate through a list of stages.
+const PacerStages = "stages"
+
+// LoadTestStage is a stage of a load profile. During a stage, the rate changes linearly
+// from the previous stage's rate to the stage's rate. A stage with the same rate as the
+// previous one holds the rate, a stage with zero duration steps to its rate immediately.
+type LoadTestStage struct {
+	// Duration of the stage in seconds.
+	Duration uint64 `json:"duration,string"`
+	// Rate is the rate in requests per second reached at the end of the stage.
+	Rate uint64 `json:"rate,string"`
 }
 
 // WorkerLoadTestMetrics is a struct type containing load test metrics from a worker.
@@ -68,6 +233,128 @@ type WorkerLoadTestMetrics struct {
 	StatusCodes map[string]int `json:"status_codes"`
 	// Errors is a set of unique errors returned by the targets during the attack.
 	Errors []string `json:"errors"`
+	// LatencySketch is a mergeable summary of the request latency distribution.
+	LatencySketch *LatencySketch `json:"latency_sketch,omitempty"`
+	// Intervals holds per-second metrics of the requests completed since the previous report.
+	Intervals []MetricsInterval `json:"intervals,omitempty"`
+}
+
+// MetricsInterval is a struct type containing load test metrics of the requests sent within one second.
+// Intervals with the same timestamp, e.g. from different workers or reports, are merged by the server.
+type MetricsInterval struct {
+	// Timestamp is the start of the interval.
+	Timestamp time.Time `json:"timestamp"`
+	// Requests is the number of requests sent in the interval.
+	Requests uint64 `json:"requests"`
+	// Successes is the number of non-error responses.
+	Successes uint64 `json:"successes"`
+	// Latencies holds computed request latency metrics of the interval.
+	Latencies vegeta.LatencyMetrics `json:"latencies"`
+	// BytesIn is the number of incoming bytes.
+	BytesIn uint64 `json:"bytes_in"`
+	// BytesOut is the number of outgoing bytes.
+	BytesOut uint64 `json:"bytes_out"`
+	// StatusCodes is a histogram of the responses' status codes.
+	StatusCodes map[string]int `json:"status_codes"`
+	// LatencySketch is a mergeable summary of the interval's latency distribution.
+	LatencySketch *LatencySketch `json:"latency_sketch,omitempty"`
+}
+
+// LoadTestMetrics is a struct type containing load test metrics aggregated from all workers.
+// It is computed by the server and sent to the UI.
+type LoadTestMetrics struct {
+	// NumOfWorkers is the number of workers whose metrics are included.
+	NumOfWorkers int `json:"num_of_workers"`
+	// Duration is the duration of the longest running attack.
+	Duration time.Duration `json:"duration"`
+	// Wait is the longest extra time waiting for responses from targets.
+	Wait time.Duration `json:"wait"`
+	// Requests is the total number of requests executed by all workers.
+	Requests uint64 `json:"requests"`
+	// Rate is the combined rate of sent requests per second.
+	Rate float64 `json:"rate"`
+	// Throughput is the combined rate of successful requests per second.
+	Throughput float64 `json:"throughput"`
+	// Success is the percentage of non-error responses across all workers.
+	Success float64 `json:"success"`
+	// Latencies holds cluster-wide request latency metrics.
+	Latencies vegeta.LatencyMetrics `json:"latencies"`
+	// BytesIn holds combined incoming byte metrics.
+	BytesIn vegeta.ByteMetrics `json:"bytes_in"`
+	// BytesOut holds combined outgoing byte metrics.
+	BytesOut vegeta.ByteMetrics `json:"bytes_out"`
+	// StatusCodes is a merged histogram of the responses' status codes.
+	StatusCodes map[string]int `json:"status_codes"`
+	// Errors is the union of unique errors returned by the targets.
+	Errors []string `json:"errors"`
+	// Quantiles holds additionally requested latency quantiles, keyed by quantile (e.g. "0.999").
+	Quantiles map[string]time.Duration `json:"quantiles,omitempty"`
+	// LatencySketch is the merged summary of the cluster-wide request latency distribution.
+	LatencySketch *LatencySketch `json:"latency_sketch,omitempty"`
+}
+
+// LatencySketch is a compact t-digest summary of a latency distribution.
+// Unlike precomputed percentiles, sketches from multiple workers can be merged
+// to compute accurate cluster-wide quantiles.
+type LatencySketch struct {
+	// Compression is the t-digest compression the sketch was built with.
+	Compression float64 `json:"compression"`
+	// Centroids holds the digest centroids as pairs of mean latency in nanoseconds and weight.
+	Centroids [][2]float64 `json:"centroids"`
+}
+
+// LatencyCompression is the t-digest compression of latency sketches.
+// It matches the compression vegeta uses for its own latency metrics.
+const LatencyCompression = 100
+
+// NewLatencySketch creates a latency sketch from a t-digest.
+func NewLatencySketch(digest *tdigest.TDigest) *LatencySketch {
+	centroids := digest.Centroids()
+
+	sketch := &LatencySketch{
+		Compression: digest.Compression,
+		Centroids:   make([][2]float64, 0, len(centroids)),
+	}
+
+	for _, c := range centroids {
+		sketch.Centroids = append(sketch.Centroids, [2]float64{c.Mean, c.Weight})
+	}
+
+	return sketch
+}
+
+// AddTo merges the sketch into a t-digest.
+func (s *LatencySketch) AddTo(digest *tdigest.TDigest) {
+	for _, c := range s.Centroids {
+		digest.Add(c[0], c[1])
+	}
+}
+
+// Digest returns a t-digest reconstructed from the sketch.
+func (s *LatencySketch) Digest() *tdigest.TDigest {
+	digest := tdigest.NewWithCompression(s.Compression)
+	s.AddTo(digest)
+
+	return digest
+}
+
+// Quantile returns the nth quantile of the latency distribution.
+func (s *LatencySketch) Quantile(nth float64) time.Duration {
+	if len(s.Centroids) == 0 {
+		return 0
+	}
+
+	return time.Duration(s.Digest().Quantile(nth))
+}
+
+// WorkerResults is a messaging type containing a batch of raw results from a worker.
+type WorkerResults struct {
+	RunID string `json:"run_id"`
+	// Seq numbers the chunks of a run from 1, so the server notices lost chunks. It is 0 for older workers.
+	Seq uint64 `json:"seq,omitempty"`
+	// Data is the next chunk of the worker's results of the run, JSON encoded one result per line.
+	// Each chunk decodes on its own, and the chunks of a run form a single stream once concatenated.
+	Data []byte `json:"data"`
 }
 
 // WorkerState indicates worker state
@@ -88,6 +375,100 @@ const WorkerStateStopped = WorkerState(3)
 // WorkerInfo is a messaging type containing worker information.
 type WorkerInfo struct {
 	State WorkerState `json:"state"`
+	// RunID is the ID of the last load test run the worker took part in. A worker reconnecting while running
+	// a load test announces it, so the server can re-attach the worker to the current run.
+	RunID string `json:"run_id,omitempty"`
+	// WorkerID is the worker's ID within the run, see StartLoadTestRequest. It is empty for older workers.
+	WorkerID string `json:"worker_id,omitempty"`
+}
+
+// ProtocolVersion is the version of the protocol between the server and workers. It is incremented on changes
+// that older peers do not understand.
+const ProtocolVersion = 2
+
+// AckProtocolVersion is the first protocol version whose workers acknowledge commands.
+const AckProtocolVersion = 2
+
+// MinProtocolVersion is the oldest protocol version a peer accepts to talk with.
+const MinProtocolVersion = 1
+
+// Features are the load test features a worker supports.
+type Features struct {
+	// Pacers are the supported load profiles, e.g. PacerConstant.
+	Pacers []string `json:"pacers"`
+	// Encodings are the supported raw results encodings, e.g. ResultsEncodingGob.
+	Encodings []string `json:"encodings"`
+	// Targets tells whether the worker hits several targets, from Targets or TargetsFile.
+	Targets bool `json:"targets,omitempty"`
+	// Templates tells whether the worker evaluates templates and data feeds.
+	Templates bool `json:"templates,omitempty"`
+}
+
+// Hello is the first message a worker sends once connected.
+type Hello struct {
+	ProtocolVersion int `json:"protocol_version"`
+	// Version is the worker's build version.
+	Version  string   `json:"version"`
+	Features Features `json:"features"`
+	// EnvelopeEncodings are the envelope encodings the worker writes, in order of preference.
+	EnvelopeEncodings []string `json:"envelope_encodings,omitempty"`
+}
+
+// Welcome is the server's reply to a worker's hello.
+type Welcome struct {
+	ProtocolVersion int `json:"protocol_version"`
+	// Version is the server's build version.
+	Version string `json:"version"`
+	// Error is set when the server rejects the worker, which should then leave without reconnecting.
+	Error string `json:"error,omitempty"`
+	// EnvelopeEncoding is the envelope encoding both sides write after the welcome, among the worker's.
+	// Servers older than the negotiation leave it empty, meaning EnvelopeEncodingJSONString.
+	EnvelopeEncoding string `json:"envelope_encoding,omitempty"`
+	// Heartbeat is how the server pings the worker. Servers older than it leave it nil.
+	Heartbeat *Heartbeat `json:"heartbeat,omitempty"`
+}
+
+// Heartbeat is how often the server pings its workers, and how long they may stay silent before being
+// considered unresponsive. A zero interval means the server does not ping.
+type Heartbeat struct {
+	Interval time.Duration `json:"interval"`
+	Timeout  time.Duration `json:"timeout"`
+}
+
+// Ack is a worker's reply to a command with an ID. An ack with an error is a rejection of the command,
+// e.g. a load test request with an invalid URL.
+type Ack struct {
+	ID    string `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+// AckStatusAccepted indicates that a worker accepted a command.
+const AckStatusAccepted = "accepted"
+
+// AckStatusRejected indicates that a worker rejected a command.
+const AckStatusRejected = "rejected"
+
+// AckStatusTimedOut indicates that a worker did not acknowledge a command in time.
+const AckStatusTimedOut = "timed_out"
+
+// AckStatusFailed indicates that a command could not be sent to a worker.
+const AckStatusFailed = "failed"
+
+// AckStatusUnacknowledged indicates that a command was sent to a worker older than acknowledgements,
+// so whether it was accepted is unknown.
+const AckStatusUnacknowledged = "unacknowledged"
+
+// WorkerAck is how a worker answered a command.
+type WorkerAck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// StopLoadTestResult is the response to a request to stop the load test.
+type StopLoadTestResult struct {
+	// Acks holds how each worker answered the request to stop, sorted by name.
+	Acks []WorkerAck `json:"acks"`
 }
 
 // ServerStateNotStarted indicates that the server sees that its workers are not started.
@@ -107,3 +488,52 @@ type ServerInfo struct {
 	NumOfWorkers int    `json:"num_of_workers"`
 	State        string `json:"state"`
 }
+
+// LoadTestRun is a record of a load test run kept in the server's history.
+type LoadTestRun struct {
+	ID        string               `json:"id"`
+	Request   StartLoadTestRequest `json:"request"`
+	State     string               `json:"state"`
+	StartedAt time.Time            `json:"started_at"`
+	EndedAt   *time.Time           `json:"ended_at,omitempty"`
+	// Workers holds the final metrics of each worker that took part in the run.
+	Workers []WorkerLoadTestResult `json:"workers,omitempty"`
+	// Metrics holds the metrics aggregated from all workers.
+	Metrics LoadTestMetrics `json:"metrics"`
+	// TimeSeries holds the per-second metrics aggregated from all workers.
+	TimeSeries []MetricsInterval `json:"time_series,omitempty"`
+	// Verdict holds the outcome of the request's thresholds, nil when the request has none.
+	Verdict *Verdict `json:"verdict,omitempty"`
+	// Acks holds how each worker answered the request to start the run, sorted by name.
+	Acks []WorkerAck `json:"acks,omitempty"`
+}
+
+// Verdict is a struct type containing the outcome of the thresholds of a load test run.
+// While the run is in progress, it reflects the latest metrics.
+type Verdict struct {
+	Passed bool `json:"passed"`
+	// Aborted is set when the run was stopped because a threshold was breached.
+	Aborted bool `json:"aborted,omitempty"`
+	// AbortedBy is the threshold whose breach stopped the run.
+	AbortedBy  string            `json:"aborted_by,omitempty"`
+	Thresholds []ThresholdResult `json:"thresholds"`
+}
+
+// ThresholdResult is a struct type containing the outcome of a single threshold.
+type ThresholdResult struct {
+	Threshold string `json:"threshold"`
+	Passed    bool   `json:"passed"`
+	// Actual is the measured value the threshold was compared to.
+	Actual string `json:"actual"`
+	// Pending is set while the run is in progress for thresholds on totals (requests, rate and throughput).
+	// They are only checked once the run ended, and do not fail the verdict before.
+	Pending bool `json:"pending,omitempty"`
+}
+
+// WorkerLoadTestResult is a struct type containing the load test metrics of a single worker in a run.
+type WorkerLoadTestResult struct {
+	Name string `json:"name"`
+	// ID is the worker's ID within the run, see StartLoadTestRequest. It is empty in runs saved by older versions.
+	ID      string                `json:"id,omitempty"`
+	Metrics WorkerLoadTestMetrics `json:"metrics"`
+}
@@ -0,0 +1,277 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// TypeText is vegeta's text report.
+const TypeText = "text"
+
+// TypeJSON is vegeta's JSON report.
+const TypeJSON = "json"
+
+// TypeHDRPlot is vegeta's latency percentiles report, plottable by
+// http://hdrhistogram.github.io/HdrHistogram/plotFiles.html.
+const TypeHDRPlot = "hdrplot"
+
+// TypeHist is vegeta's latency histogram report. It is followed by the buckets, e.g. hist[0,10ms,50ms,100ms].
+const TypeHist = "hist"
+
+// NewReporter creates a reporter that renders load test metrics in the format of the given vegeta report type.
+// startedAt is the start time of the load test, vegeta's JSON report includes the time range of the results.
+//
+// Terjang does not keep individual results, latency distributions are estimated from the metrics' latency sketch.
+func NewReporter(typ string, m *messages.LoadTestMetrics, startedAt time.Time) (vegeta.Reporter, error) {
+	switch {
+	case typ == "" || typ == TypeText:
+		return vegeta.NewTextReporter(vegetaMetrics(m, startedAt)), nil
+	case typ == TypeJSON:
+		return vegeta.NewJSONReporter(vegetaMetrics(m, startedAt)), nil
+	case typ == TypeHDRPlot:
+		return newHDRHistogramPlotReporter(m), nil
+	case strings.HasPrefix(typ, TypeHist):
+		var buckets vegeta.Buckets
+		if err := buckets.UnmarshalText([]byte(typ[len(TypeHist):])); err != nil {
+			return nil, fmt.Errorf("invalid histogram buckets: %w", err)
+		}
+
+		return vegeta.NewHistogramReporter(histogram(m, buckets)), nil
+	}
+
+	return nil, fmt.Errorf("unknown report type %q", typ)
+}
+
+// ContentType returns the MIME type of a report type.
+func ContentType(typ string) string {
+	if typ == TypeJSON {
+		return "application/json"
+	}
+
+	return "text/plain; charset=utf-8"
+}
+
+// Write renders load test metrics to w. See NewReporter.
+func Write(w io.Writer, typ string, m *messages.LoadTestMetrics, startedAt time.Time) error {
+	reporter, err := NewReporter(typ, m, startedAt)
+	if err != nil {
+		return err
+	}
+
+	return reporter.Report(w)
+}
+
+func vegetaMetrics(m *messages.LoadTestMetrics, startedAt time.Time) *vegeta.Metrics {
+	errors := m.Errors
+	if errors == nil {
+		errors = []string{}
+	}
+
+	statusCodes := m.StatusCodes
+	if statusCodes == nil {
+		statusCodes = map[string]int{}
+	}
+
+	return &vegeta.Metrics{
+		Latencies:   m.Latencies,
+		BytesIn:     m.BytesIn,
+		BytesOut:    m.BytesOut,
+		Earliest:    startedAt,
+		Latest:      startedAt.Add(m.Duration),
+		End:         startedAt.Add(m.Duration + m.Wait),
+		Duration:    m.Duration,
+		Wait:        m.Wait,
+		Requests:    m.Requests,
+		Rate:        m.Rate,
+		Throughput:  m.Throughput,
+		Success:     m.Success,
+		StatusCodes: statusCodes,
+		Errors:      errors,
+	}
+}
+
+// histogram estimates how many requests fall in each latency bucket. As in vegeta, the last bucket
+// also counts the requests faster than the first bucket.
+func histogram(m *messages.LoadTestMetrics, buckets vegeta.Buckets) *vegeta.Histogram {
+	h := &vegeta.Histogram{
+		Buckets: buckets,
+		Counts:  make([]uint64, len(buckets)),
+		Total:   m.Requests,
+	}
+
+	if m.LatencySketch == nil || m.Requests == 0 {
+		return h
+	}
+
+	digest := m.LatencySketch.Digest()
+	total := float64(m.Requests)
+
+	below := func(d time.Duration) uint64 {
+		return uint64(math.Round(digest.CDF(float64(d)) * total))
+	}
+
+	assigned := uint64(0)
+	for i := 0; i < len(buckets)-1; i++ {
+		lo, hi := below(buckets[i]), below(buckets[i+1])
+		if hi > lo {
+			h.Counts[i] = hi - lo
+			assigned += hi - lo
+		}
+	}
+
+	if assigned < m.Requests {
+		h.Counts[len(buckets)-1] = m.Requests - assigned
+	}
+
+	return h
+}
+
+// newHDRHistogramPlotReporter is vegeta's HDR histogram plot reporter, using the metrics' latency sketch.
+func newHDRHistogramPlotReporter(m *messages.LoadTestMetrics) vegeta.Reporter {
+	return func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.StripEscape)
+		_, err := fmt.Fprintf(tw, "Value(ms)\tPercentile\tTotalCount\t1/(1-Percentile)\n")
+		if err != nil {
+			return err
+		}
+
+		var quantile func(float64) time.Duration
+		if m.LatencySketch != nil {
+			digest := m.LatencySketch.Digest()
+			quantile = func(q float64) time.Duration { return time.Duration(digest.Quantile(q)) }
+		} else {
+			quantile = func(float64) time.Duration { return 0 }
+		}
+
+		total := float64(m.Requests)
+		for _, q := range hdrQuantiles {
+			value := milliseconds(quantile(q))
+			oneBy := oneByQuantile(q)
+			count := int64((q * total) + 0.5) // Count at quantile
+			_, err = fmt.Fprintf(tw, "%f\t%f\t%d\t%f\n", value, q, count, oneBy)
+			if err != nil {
+				return err
+			}
+		}
+
+		return tw.Flush()
+	}
+}
+
+// milliseconds converts the given duration to a number of fractional milliseconds.
+func milliseconds(d time.Duration) float64 {
+	msec, nsec := d/time.Millisecond, d%time.Millisecond
+	return float64(msec) + float64(nsec)/1e6
+}
+
+func oneByQuantile(q float64) float64 {
+	if q < 1.0 {
+		return 1 / (1 - q)
+	}
+	return float64(10000000)
+}
+
+// hdrQuantiles are the quantiles of vegeta's HDR histogram plot report.
+var hdrQuantiles = []float64{
+	0.00,
+	0.100,
+	0.200,
+	0.300,
+	0.400,
+	0.500,
+	0.550,
+	0.600,
+	0.650,
+	0.700,
+	0.750,
+	0.775,
+	0.800,
+	0.825,
+	0.850,
+	0.875,
+	0.8875,
+	0.900,
+	0.9125,
+	0.925,
+	0.9375,
+	0.94375,
+	0.950,
+	0.95625,
+	0.9625,
+	0.96875,
+	0.971875,
+	0.975,
+	0.978125,
+	0.98125,
+	0.984375,
+	0.985938,
+	0.9875,
+	0.989062,
+	0.990625,
+	0.992188,
+	0.992969,
+	0.99375,
+	0.994531,
+	0.995313,
+	0.996094,
+	0.996484,
+	0.996875,
+	0.997266,
+	0.997656,
+	0.998047,
+	0.998242,
+	0.998437,
+	0.998633,
+	0.998828,
+	0.999023,
+	0.999121,
+	0.999219,
+	0.999316,
+	0.999414,
+	0.999512,
+	0.999561,
+	0.999609,
+	0.999658,
+	0.999707,
+	0.999756,
+	0.99978,
+	0.999805,
+	0.999829,
+	0.999854,
+	0.999878,
+	0.99989,
+	0.999902,
+	0.999915,
+	0.999927,
+	0.999939,
+	0.999945,
+	0.999951,
+	0.999957,
+	0.999963,
+	0.999969,
+	0.999973,
+	0.999976,
+	0.999979,
+	0.999982,
+	0.999985,
+	0.999986,
+	0.999988,
+	0.999989,
+	0.999991,
+	0.999992,
+	0.999993,
+	0.999994,
+	0.999995,
+	0.999996,
+	0.999997,
+	0.999998,
+	0.999999,
+	1.0,
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/transport"
+)
+
+// defaultAckTimeout is how long the server waits for workers to accept or reject a load test by default.
+const defaultAckTimeout = 5 * time.Second
+
+// SetAckTimeout sets how long the server waits for workers to accept or reject a load test request.
+// Workers that did not answer in time are reported as timed out, and keep taking part in the run.
+func (s *Server) SetAckTimeout(d time.Duration) {
+	s.ackTimeout = d
+}
+
+// pendingAck is a command sent to a worker, awaiting the worker's acknowledgement.
+type pendingAck struct {
+	worker *worker
+	id     string
+	// ack receives the worker's acknowledgement. It is nil for workers older than acknowledgements.
+	ack chan *messages.Ack
+	// err is set when the command could not be sent.
+	err error
+}
+
+// newMessageID returns a new ID for a command to a worker.
+func (w *WorkerService) newMessageID() string {
+	return strconv.FormatUint(atomic.AddUint64(&w.lastMessageID, 1), 10)
+}
+
+// command sends a command to the worker with its envelope encoding. Workers that acknowledge commands are sent
+// the command with an ID, to acknowledge it with.
+func (wk *worker) command(id string, kind string, payload interface{}) *pendingAck {
+	p := &pendingAck{worker: wk}
+
+	if wk.ProtocolVersion >= messages.AckProtocolVersion {
+		p.id = id
+		p.ack = make(chan *messages.Ack, 1)
+
+		wk.acksLock.Lock()
+		if wk.acks == nil {
+			wk.acks = make(map[string]chan *messages.Ack)
+		}
+		wk.acks[id] = p.ack
+		wk.acksLock.Unlock()
+	}
+
+	message, err := messages.EncodeCommand(wk.envelopeEncoding, p.id, kind, payload)
+	if err == nil {
+		err = wk.send(message)
+	}
+
+	if err != nil {
+		logger.Errorw("Failed to send message to worker", "name", wk.Name, "kind", kind, "error", err)
+
+		p.err = err
+		wk.forget(p.id)
+	}
+
+	return p
+}
+
+// forget stops awaiting the acknowledgement of a command.
+func (wk *worker) forget(id string) {
+	wk.acksLock.Lock()
+	defer wk.acksLock.Unlock()
+
+	delete(wk.acks, id)
+}
+
+// acknowledge passes a worker's acknowledgement to the command awaiting it. Acknowledgements arriving
+// after the command timed out are dropped.
+func (w *WorkerService) acknowledge(conn transport.Conn, message []byte) {
+	_, payload, err := messages.Decode(message)
+	ack, ok := payload.(*messages.Ack)
+	if err != nil || !ok {
+		logger.Warnw("Failed to decode acknowledgement", "message", string(message))
+		return
+	}
+
+	w.workersLock.RLock()
+	wk, ok := w.workers[conn]
+	w.workersLock.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	wk.acksLock.Lock()
+	ch, ok := wk.acks[ack.ID]
+	delete(wk.acks, ack.ID)
+	wk.acksLock.Unlock()
+
+	if ok {
+		ch <- ack
+	}
+}
+
+// awaitAcks waits up to timeout for the workers to acknowledge their commands, and returns how each worker
+// answered, in the order of the commands.
+func awaitAcks(pending []*pendingAck, timeout time.Duration) []messages.WorkerAck {
+	expired := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() { close(expired) })
+	defer timer.Stop()
+
+	acks := make([]messages.WorkerAck, 0, len(pending))
+	for _, p := range pending {
+		ack := messages.WorkerAck{Name: p.worker.Name}
+
+		switch {
+		case p.err != nil:
+			ack.Status = messages.AckStatusFailed
+			ack.Error = p.err.Error()
+		case p.ack == nil:
+			ack.Status = messages.AckStatusUnacknowledged
+		default:
+			select {
+			case reply := <-p.ack:
+				ack.Status, ack.Error = ackStatus(reply)
+			case <-expired:
+				// The acknowledgement may have arrived along with the timeout.
+				select {
+				case reply := <-p.ack:
+					ack.Status, ack.Error = ackStatus(reply)
+				default:
+					ack.Status = messages.AckStatusTimedOut
+					p.worker.forget(p.id)
+				}
+			}
+		}
+
+		acks = append(acks, ack)
+	}
+
+	return acks
+}
+
+func ackStatus(reply *messages.Ack) (string, string) {
+	if reply.Error != "" {
+		return messages.AckStatusRejected, reply.Error
+	}
+
+	return messages.AckStatusAccepted, ""
+}
+
+// leaveRun detaches the workers that rejected their command, or could not be sent it, from the run.
+// It returns the number of workers detached.
+func (w *WorkerService) leaveRun(pending []*pendingAck, acks []messages.WorkerAck) int {
+	w.workersLock.Lock()
+	defer w.workersLock.Unlock()
+
+	left := 0
+	for i, p := range pending {
+		if acks[i].Status == messages.AckStatusRejected || acks[i].Status == messages.AckStatusFailed {
+			p.worker.runID = ""
+			left++
+		}
+	}
+
+	return left
+}
+
+// sortAcks sorts acknowledgements by worker name.
+func sortAcks(acks []messages.WorkerAck) {
+	sort.Slice(acks, func(i, j int) bool {
+		return acks[i].Name < acks[j].Name
+	})
+}
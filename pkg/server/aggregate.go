@@ -0,0 +1,126 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/influxdata/tdigest"
+)
+
+// AggregateMetrics merges the load test metrics reported by multiple workers
+// into a single cluster-wide result. Additional latency quantiles (e.g. 0.999)
+// can be requested and are returned in the Quantiles field.
+func AggregateMetrics(workerMetrics []messages.WorkerLoadTestMetrics, quantiles ...float64) messages.LoadTestMetrics {
+	result := messages.LoadTestMetrics{
+		NumOfWorkers: len(workerMetrics),
+		StatusCodes:  make(map[string]int),
+		Errors:       make([]string, 0),
+	}
+
+	var successes float64
+	errors := make(map[string]struct{})
+	digest := tdigest.NewWithCompression(messages.LatencyCompression)
+
+	for _, m := range workerMetrics {
+		result.Requests += m.Requests
+		result.Rate += m.Rate
+		result.Throughput += m.Throughput
+		successes += m.Success * float64(m.Requests)
+
+		if m.Duration > result.Duration {
+			result.Duration = m.Duration
+		}
+
+		if m.Wait > result.Wait {
+			result.Wait = m.Wait
+		}
+
+		result.BytesIn.Total += m.BytesIn.Total
+		result.BytesOut.Total += m.BytesOut.Total
+
+		for code, count := range m.StatusCodes {
+			result.StatusCodes[code] += count
+		}
+
+		for _, e := range m.Errors {
+			if _, ok := errors[e]; !ok {
+				errors[e] = struct{}{}
+				result.Errors = append(result.Errors, e)
+			}
+		}
+
+		if m.Requests == 0 {
+			continue
+		}
+
+		result.Latencies.Total += m.Latencies.Total
+
+		if m.Latencies.Max > result.Latencies.Max {
+			result.Latencies.Max = m.Latencies.Max
+		}
+
+		if m.Latencies.Min > 0 && (result.Latencies.Min == 0 || m.Latencies.Min < result.Latencies.Min) {
+			result.Latencies.Min = m.Latencies.Min
+		}
+
+		if m.LatencySketch != nil {
+			m.LatencySketch.AddTo(digest)
+		} else {
+			addLatencySummary(digest, m)
+		}
+	}
+
+	if result.Requests == 0 {
+		return result
+	}
+
+	requests := float64(result.Requests)
+	result.Success = successes / requests
+	result.BytesIn.Mean = float64(result.BytesIn.Total) / requests
+	result.BytesOut.Mean = float64(result.BytesOut.Total) / requests
+	result.Latencies.Mean = time.Duration(float64(result.Latencies.Total) / requests)
+	result.Latencies.P50 = time.Duration(digest.Quantile(0.50))
+	result.Latencies.P90 = time.Duration(digest.Quantile(0.90))
+	result.Latencies.P95 = time.Duration(digest.Quantile(0.95))
+	result.Latencies.P99 = time.Duration(digest.Quantile(0.99))
+	result.LatencySketch = messages.NewLatencySketch(digest)
+
+	if len(quantiles) > 0 {
+		result.Quantiles = make(map[string]time.Duration, len(quantiles))
+		for _, q := range quantiles {
+			result.Quantiles[strconv.FormatFloat(q, 'f', -1, 64)] = time.Duration(digest.Quantile(q))
+		}
+	}
+
+	sort.Strings(result.Errors)
+
+	return result
+}
+
+// addLatencySummary approximates the latency distribution of a worker from its
+// reported quantiles and adds it to the digest, weighted by the worker's number of requests.
+// It is only used for workers that do not report a latency sketch.
+func addLatencySummary(digest *tdigest.TDigest, m messages.WorkerLoadTestMetrics) {
+	points := []struct {
+		quantile float64
+		latency  time.Duration
+	}{
+		{0, m.Latencies.Min},
+		{0.50, m.Latencies.P50},
+		{0.90, m.Latencies.P90},
+		{0.95, m.Latencies.P95},
+		{0.99, m.Latencies.P99},
+		{1, m.Latencies.Max},
+	}
+
+	requests := float64(m.Requests)
+
+	for i := 1; i < len(points); i++ {
+		weight := (points[i].quantile - points[i-1].quantile) * requests
+		mean := float64(points[i-1].latency+points[i].latency) / 2
+
+		digest.Add(mean, weight)
+	}
+}
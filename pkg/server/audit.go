@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/audit"
+	"github.com/julienschmidt/httprouter"
+)
+
+// SetAuditLog registers the log recording who started and stopped load tests. By default, it is kept in memory.
+func (s *Server) SetAuditLog(l audit.Log) {
+	s.auditLog = l
+}
+
+// apiEvent creates an audit event of an API call, identifying its user and address.
+func apiEvent(req *http.Request) *audit.Event {
+	event := &audit.Event{
+		User:         audit.UserAnonymous,
+		RemoteAddr:   req.RemoteAddr,
+		ForwardedFor: req.Header.Get("X-Forwarded-For"),
+	}
+
+	if user := userFromContext(req.Context()); user != nil {
+		event.User = user.Name
+		event.Role = user.Role.String()
+	}
+
+	return event
+}
+
+// recordEvent appends an event to the audit log. Failures are logged, the operation already happened.
+func (s *Server) recordEvent(event *audit.Event) {
+	event.Time = time.Now()
+
+	if err := s.auditLog.Append(event); err != nil {
+		logger.Errorw("Failed to record audit event", "action", event.Action, "user", event.User, "error", err)
+	}
+}
+
+// currentRunID returns the ID of the current or last run, or an empty string.
+func (s *Server) currentRunID() string {
+	s.runLock.Lock()
+	defer s.runLock.Unlock()
+
+	if s.currentRun == nil {
+		return ""
+	}
+
+	return s.currentRun.ID
+}
+
+// HandleAuditLog responds with the events of the audit log, in chronological order. Events can be selected
+// with the action, user and run_id query parameters, a time range with since and until (RFC 3339),
+// and limit keeps the most recent ones.
+func (s *Server) HandleAuditLog(responseWriter http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	query := req.URL.Query()
+
+	q := audit.Query{
+		Action: query.Get("action"),
+		User:   query.Get("user"),
+		RunID:  query.Get("run_id"),
+	}
+
+	var err error
+	for name, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if v := query.Get(name); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(responseWriter, "invalid "+name+": must be an RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	if v := query.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 0 {
+			http.Error(responseWriter, "invalid limit: must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, err := s.auditLog.Query(q)
+	if err != nil {
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	eventsMsg, _ := json.Marshal(events)
+
+	header := responseWriter.Header()
+	header.Set("Content-Type", "application/json")
+
+	responseWriter.WriteHeader(200)
+	responseWriter.Write(eventsMsg)
+}
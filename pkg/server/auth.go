@@ -0,0 +1,173 @@
+package server
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Browsers can set no header on websockets but Sec-WebSocket-Protocol, so they send their token as a subprotocol
+// made of tokenProtocolPrefix and the base64url encoded token, along with notificationsProtocol that the server selects.
+const (
+	notificationsProtocol = "terjang"
+	tokenProtocolPrefix   = "base64url.bearer.terjang."
+)
+
+// SetClusterSecret sets the secret workers must present to join the cluster.
+// Workers send it as a bearer token. By default, any worker can join.
+func (s *Server) SetClusterSecret(secret string) {
+	s.clusterSecret = secret
+}
+
+// AddAPIToken registers a token granting the admin role on the HTTP API, the notifications and the metrics.
+// See AddUser for tokens with other roles.
+func (s *Server) AddAPIToken(token string) {
+	s.AddUser(User{Name: "api-token", Token: token, Role: RoleAdmin})
+}
+
+// SetAllowedOrigins sets the origins browsers may call the API and subscribe to the notifications from,
+// besides the server's own origin, e.g. http://localhost:8080. "*" allows any origin.
+func (s *Server) SetAllowedOrigins(origins []string) {
+	s.allowedOrigins = origins
+}
+
+// SetTLSConfig makes the server serve HTTPS and secure websockets (wss) with a TLS configuration,
+// e.g. from tlsconfig.Server. When the configuration has client CAs, workers must present a client
+// certificate signed by one of them to join (mutual TLS). API clients and browsers do not need one.
+func (s *Server) SetTLSConfig(config *tls.Config) {
+	s.tlsConfig = config
+}
+
+// requireClusterSecret wraps the worker join handler to reject workers without the cluster secret.
+func (s *Server) requireClusterSecret(h httprouter.Handle) httprouter.Handle {
+	return func(responseWriter http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		if s.clusterSecret != "" && !tokenMatches(bearerToken(req), []string{s.clusterSecret}) {
+			logger.Warnw("Rejected worker with an invalid cluster secret", "address", req.RemoteAddr)
+			unauthorized(responseWriter)
+			return
+		}
+
+		h(responseWriter, req, params)
+	}
+}
+
+// requireWorkerCertificate wraps the worker join handler to reject workers without a verified client certificate,
+// when the TLS configuration has client CAs.
+func (s *Server) requireWorkerCertificate(h httprouter.Handle) httprouter.Handle {
+	return func(responseWriter http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		if s.tlsConfig != nil && s.tlsConfig.ClientCAs != nil && (req.TLS == nil || len(req.TLS.VerifiedChains) == 0) {
+			logger.Warnw("Rejected worker without a valid client certificate", "address", req.RemoteAddr)
+			http.Error(responseWriter, "a client certificate is required to join", http.StatusForbidden)
+			return
+		}
+
+		h(responseWriter, req, params)
+	}
+}
+
+func unauthorized(responseWriter http.ResponseWriter) {
+	responseWriter.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(responseWriter, "missing or invalid token", http.StatusUnauthorized)
+}
+
+// bearerToken returns the token of the Authorization header of a request.
+func bearerToken(req *http.Request) string {
+	const prefix = "Bearer "
+
+	auth := req.Header.Get("Authorization")
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return ""
+	}
+
+	return strings.TrimSpace(auth[len(prefix):])
+}
+
+// upgradeToken returns the bearer token of a request, or, when it is a websocket upgrade, the token of its
+// subprotocols or its access_token query parameter.
+func upgradeToken(req *http.Request) string {
+	if token := bearerToken(req); token != "" {
+		return token
+	}
+
+	if !websocket.IsWebSocketUpgrade(req) {
+		return ""
+	}
+
+	if token := protocolToken(req); token != "" {
+		return token
+	}
+
+	return req.URL.Query().Get("access_token")
+}
+
+// protocolToken returns the token sent in the Sec-WebSocket-Protocol header of a request.
+func protocolToken(req *http.Request) string {
+	for _, protocol := range websocket.Subprotocols(req) {
+		if !strings.HasPrefix(protocol, tokenProtocolPrefix) {
+			continue
+		}
+
+		if token, err := base64.RawURLEncoding.DecodeString(protocol[len(tokenProtocolPrefix):]); err == nil {
+			return string(token)
+		}
+	}
+
+	return ""
+}
+
+// tokenMatches compares a token to the accepted ones in constant time.
+func tokenMatches(token string, accepted []string) bool {
+	if token == "" {
+		return false
+	}
+
+	match := 0
+	for _, t := range accepted {
+		match |= subtle.ConstantTimeCompare([]byte(token), []byte(t))
+	}
+
+	return match == 1
+}
+
+// checkOrigin accepts websocket connections from non-browser clients, the server's own origin
+// and the allowed origins.
+func (s *Server) checkOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	return s.originAllowed(origin, req.Host)
+}
+
+func (s *Server) originAllowed(origin string, host string) bool {
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, host) {
+		return true
+	}
+
+	for _, allowed := range s.allowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setCORSHeaders allows the origin of a cross-origin request to read the response when it is allowed.
+func (s *Server) setCORSHeaders(responseWriter http.ResponseWriter, req *http.Request) {
+	origin := req.Header.Get("Origin")
+	if origin == "" || !s.originAllowed(origin, req.Host) {
+		return
+	}
+
+	header := responseWriter.Header()
+	header.Set("Access-Control-Allow-Origin", origin)
+	header.Add("Vary", "Origin")
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/transport"
+)
+
+// SetVersion sets the build version the server reports to workers. The default is "dev".
+func (s *Server) SetVersion(version string) {
+	s.version = version
+}
+
+// parseHello returns the hello of a worker's first message, or false when the worker did not start with one,
+// as workers older than the handshake do.
+func parseHello(message []byte) (*messages.Hello, bool) {
+	if messages.KindOf(message) != messages.KindHello {
+		return nil, false
+	}
+
+	_, payload, err := messages.Decode(message)
+	hello, ok := payload.(*messages.Hello)
+
+	return hello, err == nil && ok
+}
+
+// welcomeWorker replies to a worker's hello, with the envelope encoding to use from then on. It returns false
+// when the worker is rejected for speaking a protocol version older than the server accepts. Workers with a newer
+// protocol version are welcome, it is up to them to talk with the server's version or to leave.
+func (s *Server) welcomeWorker(conn transport.Conn, name string, hello *messages.Hello) bool {
+	welcome := messages.Welcome{
+		ProtocolVersion: messages.ProtocolVersion,
+		Version:         s.version,
+		Heartbeat:       &messages.Heartbeat{Interval: s.heartbeatInterval, Timeout: s.heartbeatTimeout},
+	}
+
+	if hello.ProtocolVersion < messages.MinProtocolVersion {
+		welcome.Error = fmt.Sprintf("protocol version %d is not supported, the server requires version %d or later",
+			hello.ProtocolVersion, messages.MinProtocolVersion)
+
+		logger.Warnw("Rejected worker with an incompatible protocol version", "name", name,
+			"version", hello.Version, "protocol_version", hello.ProtocolVersion)
+	} else {
+		welcome.EnvelopeEncoding = messages.NegotiateEnvelopeEncoding(hello.EnvelopeEncodings)
+		s.workerService.setHello(conn, hello)
+
+		logger.Infow("Worker said hello", "name", name, "version", hello.Version,
+			"protocol_version", hello.ProtocolVersion, "features", hello.Features,
+			"envelope_encoding", welcome.EnvelopeEncoding)
+	}
+
+	// The welcome itself is in the original envelope encoding, which every worker reads.
+	s.workerService.sendToWorker(conn, messages.KindWelcome, &welcome)
+
+	if welcome.EnvelopeEncoding != "" {
+		s.workerService.setEnvelopeEncoding(conn, welcome.EnvelopeEncoding)
+	}
+
+	return welcome.Error == ""
+}
+
+// baselineFeatures are the features of workers older than the handshake, which do not describe theirs:
+// a single target at a constant rate, without raw results.
+var baselineFeatures = messages.Features{Pacers: []string{messages.PacerConstant}}
+
+// supports tells whether a worker supports the features a load test request uses. Workers that did not describe
+// their features only support the baseline ones.
+func (wk *worker) supports(req *messages.StartLoadTestRequest) bool {
+	features := &baselineFeatures
+	if wk.Features != nil {
+		features = wk.Features
+	}
+
+	pacer := req.Pacer
+	if pacer == "" {
+		pacer = messages.PacerConstant
+	}
+
+	if !contains(features.Pacers, pacer) {
+		return false
+	}
+
+	if (len(req.Targets) > 0 || req.TargetsFile != "") && !features.Targets {
+		return false
+	}
+
+	if (req.Template || req.DataFeed != "") && !features.Templates {
+		return false
+	}
+
+	if req.ResultsOutput == "" {
+		return true
+	}
+
+	// Results streamed to the server are JSON encoded.
+	encoding := req.ResultsEncoding
+	if req.ResultsOutput == messages.ResultsOutputServer {
+		encoding = messages.ResultsEncodingJSON
+	} else if encoding == "" {
+		encoding = messages.ResultsEncodingGob
+	}
+
+	return contains(features.Encodings, encoding)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultHeartbeatInterval   = 5 * time.Second
+	defaultHeartbeatTimeout    = 15 * time.Second
+	defaultEvictionGracePeriod = 30 * time.Second
+)
+
+// SetHeartbeat sets how often workers and notification subscribers are pinged, and how long they may stay silent
+// before being considered unresponsive. The defaults are 5 and 15 seconds. A zero interval disables heartbeats.
+func (s *Server) SetHeartbeat(interval time.Duration, timeout time.Duration) {
+	s.heartbeatInterval = interval
+	s.heartbeatTimeout = timeout
+}
+
+// SetEvictionGracePeriod sets how long a worker stays unresponsive before its connection is closed and it is removed,
+// as if it had disconnected. The default is 30 seconds.
+func (s *Server) SetEvictionGracePeriod(d time.Duration) {
+	s.evictionGracePeriod = d
+}
+
+// runHeartbeatLoop pings the workers and evicts those that stopped answering.
+func (s *Server) runHeartbeatLoop() {
+	if s.heartbeatInterval <= 0 {
+		return
+	}
+
+	for {
+		s.workerService.broadcast(messages.KindPing, nil)
+
+		unresponsive, responsive, evicted := s.workerService.checkHeartbeats(s.heartbeatTimeout, s.evictionGracePeriod)
+		for _, name := range unresponsive {
+			logger.Warnw("Worker is unresponsive", "name", name, "timeout", s.heartbeatTimeout)
+		}
+
+		for _, name := range responsive {
+			logger.Infow("Worker is responsive again", "name", name)
+		}
+
+		for _, name := range evicted {
+			logger.Warnw("Evicted unresponsive worker", "name", name)
+		}
+
+		time.Sleep(s.heartbeatInterval)
+	}
+}
+
+// keepSubscriberAlive pings a notification subscriber until done is closed, and makes its reads fail when
+// it stays silent for longer than the heartbeat timeout. Browsers and websocket clients answer pings on their own.
+func (s *Server) keepSubscriberAlive(conn *websocket.Conn, done <-chan struct{}) {
+	if s.heartbeatInterval <= 0 {
+		return
+	}
+
+	conn.SetReadDeadline(time.Now().Add(s.heartbeatTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(s.heartbeatTimeout))
+	})
+
+	go func() {
+		ticker := time.NewTicker(s.heartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(s.heartbeatTimeout)); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
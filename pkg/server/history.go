@@ -0,0 +1,423 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/audit"
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/report"
+	"github.com/andylibrian/terjang/pkg/store"
+	"github.com/julienschmidt/httprouter"
+)
+
+// finishRunDelay is how long the server waits after workers finished before it persists the run.
+// Workers report metrics every second, so this lets the final metrics arrive.
+const finishRunDelay = 1500 * time.Millisecond
+
+// newRunID generates a load test run ID. IDs are prefixed with the start time so they sort chronologically.
+func newRunID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// beginRun records the start of a new load test run and makes it the current run. It returns a copy of the run.
+func (s *Server) beginRun(r messages.StartLoadTestRequest) *messages.LoadTestRun {
+	run := &messages.LoadTestRun{
+		ID:        r.RunID,
+		Request:   r,
+		State:     loadTestStateToString(messages.ServerStateRunning),
+		StartedAt: time.Now(),
+	}
+
+	thresholds, err := parseThresholds(r.Thresholds)
+	if err != nil {
+		logger.Errorw("Ignoring invalid thresholds", "id", run.ID, "error", err)
+	}
+
+	s.runLock.Lock()
+	defer s.runLock.Unlock()
+
+	s.currentRun = run
+	s.thresholds = thresholds
+	s.resultsSeq = make(map[string]uint64)
+
+	if err := s.store.SaveRun(run); err != nil {
+		logger.Errorw("Failed to save load test run", "id", run.ID, "error", err)
+	}
+
+	// The current run keeps being updated, the caller gets a copy.
+	begun := *run
+
+	return &begun
+}
+
+// setRunAcks records how the workers answered the request to start a run, if it is still the current run.
+func (s *Server) setRunAcks(id string, acks []messages.WorkerAck) {
+	s.runLock.Lock()
+	defer s.runLock.Unlock()
+
+	if s.currentRun == nil || s.currentRun.ID != id {
+		return
+	}
+
+	s.currentRun.Acks = acks
+
+	if err := s.store.SaveRun(s.currentRun); err != nil {
+		logger.Errorw("Failed to save load test run", "id", id, "error", err)
+	}
+}
+
+// updateCurrentRun merges the latest metrics of the connected workers into the current run
+// and evaluates its thresholds. The load test is stopped when a threshold is breached and
+// the request asks to abort on breach.
+func (s *Server) updateCurrentRun() {
+	s.runLock.Lock()
+
+	run := s.currentRun
+	if run == nil || run.EndedAt != nil {
+		s.runLock.Unlock()
+		return
+	}
+
+	s.mergeWorkerResults(run)
+
+	abortedBy := ""
+	// Thresholds are meaningless before the first results arrive.
+	if run.Metrics.Requests > 0 {
+		s.updateVerdict(run, false)
+
+		if run.Request.AbortOnBreach && run.Verdict != nil && !run.Verdict.Passed && !run.Verdict.Aborted {
+			run.Verdict.Aborted = true
+			for _, t := range run.Verdict.Thresholds {
+				if !t.Passed && !t.Pending {
+					run.Verdict.AbortedBy = t.Threshold
+					break
+				}
+			}
+
+			abortedBy = run.Verdict.AbortedBy
+		}
+	}
+
+	s.runLock.Unlock()
+
+	if abortedBy != "" {
+		logger.Infow("Aborting load test, threshold breached", "id", run.ID, "threshold", abortedBy)
+		// The metrics loop does not wait for the workers to acknowledge.
+		go s.stopLoadTest(&audit.Event{User: audit.UserSystem, Reason: "threshold breached: " + abortedBy})
+	}
+}
+
+// updateVerdict evaluates the thresholds of the current run on its latest metrics, final once the run ended.
+// A run aborted because of a breach keeps failing even if the metrics recover.
+func (s *Server) updateVerdict(run *messages.LoadTestRun, final bool) {
+	verdict := evaluateThresholds(s.thresholds, &run.Metrics, final)
+	if verdict == nil {
+		return
+	}
+
+	if run.Verdict != nil && run.Verdict.Aborted {
+		verdict.Passed = false
+		verdict.Aborted = true
+		verdict.AbortedBy = run.Verdict.AbortedBy
+	}
+
+	run.Verdict = verdict
+}
+
+// scheduleFinishCurrentRun finishes the current run after the workers had the chance to report their final metrics.
+func (s *Server) scheduleFinishCurrentRun(state int) {
+	s.runLock.Lock()
+	defer s.runLock.Unlock()
+
+	if s.currentRun == nil || s.currentRun.EndedAt != nil {
+		return
+	}
+
+	id := s.currentRun.ID
+	time.AfterFunc(finishRunDelay, func() {
+		s.finishRun(id, state)
+	})
+}
+
+// finishCurrentRun marks the current run as ended with the given state and persists its final metrics.
+func (s *Server) finishCurrentRun(state int) {
+	s.runLock.Lock()
+	id := ""
+	if s.currentRun != nil {
+		id = s.currentRun.ID
+	}
+	s.runLock.Unlock()
+
+	s.finishRun(id, state)
+}
+
+// finishRun ends a run if it is still the current run, and pushes its last points to the sinks.
+func (s *Server) finishRun(id string, state int) {
+	if s.endRun(id, state) {
+		s.pushMetrics()
+	}
+}
+
+// endRun marks a run as ended with the given state and persists its final metrics. It returns false when the run
+// is not the current run, or ended already.
+func (s *Server) endRun(id string, state int) bool {
+	s.runLock.Lock()
+	defer s.runLock.Unlock()
+
+	run := s.currentRun
+	if run == nil || run.ID != id || run.EndedAt != nil {
+		return false
+	}
+
+	s.mergeWorkerResults(run)
+	s.updateVerdict(run, true)
+
+	endedAt := time.Now()
+	run.EndedAt = &endedAt
+	run.State = loadTestStateToString(state)
+
+	if err := s.store.SaveRun(run); err != nil {
+		logger.Errorw("Failed to save load test run", "id", run.ID, "error", err)
+		return true
+	}
+
+	if run.Verdict != nil {
+		logger.Infow("Saved load test run", "id", run.ID, "state", run.State, "passed", run.Verdict.Passed)
+		return true
+	}
+
+	logger.Infow("Saved load test run", "id", run.ID, "state", run.State)
+
+	return true
+}
+
+// mergeWorkerResults updates the per-worker results of a run, matched by the workers' ID within the run as names
+// may repeat. Workers that disconnected during the run keep their last reported metrics.
+func (s *Server) mergeWorkerResults(run *messages.LoadTestRun) {
+	for _, result := range s.workerService.WorkersResults() {
+		if result.Metrics.Requests == 0 {
+			continue
+		}
+
+		found := false
+		for i := range run.Workers {
+			if run.Workers[i].ID == result.ID {
+				run.Workers[i] = result
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			run.Workers = append(run.Workers, result)
+		}
+	}
+
+	workerMetrics := make([]messages.WorkerLoadTestMetrics, 0, len(run.Workers))
+	for _, result := range run.Workers {
+		workerMetrics = append(workerMetrics, result.Metrics)
+	}
+
+	run.Metrics = AggregateMetrics(workerMetrics)
+	run.TimeSeries = s.workerService.GetTimeSeries().Points()
+	run.State = loadTestStateToString(s.getLoadTestState())
+}
+
+// GetRun returns a load test run from the history. The current run is returned with its latest metrics.
+func (s *Server) GetRun(id string) (*messages.LoadTestRun, error) {
+	if run := s.snapshotCurrentRun(); run != nil && run.ID == id {
+		return run, nil
+	}
+
+	return s.store.GetRun(id)
+}
+
+// ListRuns returns the load test history, most recent first.
+func (s *Server) ListRuns() ([]*messages.LoadTestRun, error) {
+	runs, err := s.store.ListRuns()
+	if err != nil {
+		return nil, err
+	}
+
+	current := s.snapshotCurrentRun()
+
+	for i, run := range runs {
+		if current != nil && run.ID == current.ID {
+			runs[i] = current
+		}
+	}
+
+	return runs, nil
+}
+
+func (s *Server) snapshotCurrentRun() *messages.LoadTestRun {
+	s.runLock.Lock()
+	defer s.runLock.Unlock()
+
+	if s.currentRun == nil {
+		return nil
+	}
+
+	// Deep copy, the current run keeps being updated while the snapshot is used.
+	data, _ := json.Marshal(s.currentRun)
+
+	var run messages.LoadTestRun
+	json.Unmarshal(data, &run)
+
+	return &run
+}
+
+// HandleLoadTests responds with the load test history. Per-worker results and time series are omitted,
+// they are available through HandleLoadTest and HandleLoadTestTimeSeries.
+func (s *Server) HandleLoadTests(responseWriter http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	runs, err := s.ListRuns()
+	if err != nil {
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, run := range runs {
+		run.Workers = nil
+		run.TimeSeries = nil
+		run.Metrics.LatencySketch = nil
+	}
+
+	runsMsg, _ := json.Marshal(runs)
+
+	header := responseWriter.Header()
+	header.Set("Content-Type", "application/json")
+
+	responseWriter.WriteHeader(200)
+	responseWriter.Write([]byte(runsMsg))
+}
+
+// HandleLoadTest responds with a single load test run, including per-worker results.
+func (s *Server) HandleLoadTest(responseWriter http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	run, err := s.GetRun(params.ByName("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(responseWriter, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	runMsg, _ := json.Marshal(run)
+
+	header := responseWriter.Header()
+	header.Set("Content-Type", "application/json")
+
+	responseWriter.WriteHeader(200)
+	responseWriter.Write([]byte(runMsg))
+}
+
+// HandleLoadTestTimeSeries responds with the per-second metrics of a load test run.
+// Points before a unix timestamp can be skipped with the since query parameter, e.g. ?since=1633046400.
+func (s *Server) HandleLoadTestTimeSeries(responseWriter http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	var since int64
+	if v := req.URL.Query().Get("since"); v != "" {
+		var err error
+		if since, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(responseWriter, "invalid since: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	run, err := s.GetRun(params.ByName("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(responseWriter, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	points := make([]messages.MetricsInterval, 0, len(run.TimeSeries))
+	for _, p := range run.TimeSeries {
+		if p.Timestamp.Unix() >= since {
+			points = append(points, p)
+		}
+	}
+
+	pointsMsg, _ := json.Marshal(points)
+
+	header := responseWriter.Header()
+	header.Set("Content-Type", "application/json")
+
+	responseWriter.WriteHeader(200)
+	responseWriter.Write([]byte(pointsMsg))
+}
+
+// findRunWorkers returns the results of the worker of a run with an ID. Runs saved by older versions have no worker IDs,
+// their workers are matched by name, which may repeat.
+func findRunWorkers(run *messages.LoadTestRun, id string) []messages.WorkerLoadTestResult {
+	var matches []messages.WorkerLoadTestResult
+	for _, w := range run.Workers {
+		if w.ID == id || (w.ID == "" && w.Name == id) {
+			matches = append(matches, w)
+		}
+	}
+
+	return matches
+}
+
+// HandleLoadTestReport responds with a load test run rendered as one of vegeta's reports,
+// selected with the type query parameter: text (default), json, hdrplot or hist[buckets], e.g. ?type=hist[0,10ms,50ms].
+// The report covers all workers, or a single one with the worker query parameter, set to the worker's ID within
+// the run: its name, followed by "#<n>" for workers with the same name.
+func (s *Server) HandleLoadTestReport(responseWriter http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	run, err := s.GetRun(params.ByName("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(responseWriter, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	metrics := run.Metrics
+
+	if id := req.URL.Query().Get("worker"); id != "" {
+		matches := findRunWorkers(run, id)
+
+		switch len(matches) {
+		case 0:
+			http.Error(responseWriter, "worker not found: "+id, http.StatusNotFound)
+			return
+		case 1:
+			metrics = AggregateMetrics([]messages.WorkerLoadTestMetrics{matches[0].Metrics})
+		default:
+			http.Error(responseWriter, "several workers are named "+id, http.StatusBadRequest)
+			return
+		}
+	}
+
+	typ := req.URL.Query().Get("type")
+
+	reporter, err := report.NewReporter(typ, &metrics, run.StartedAt)
+	if err != nil {
+		http.Error(responseWriter, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	header := responseWriter.Header()
+	header.Set("Content-Type", report.ContentType(typ))
+
+	responseWriter.WriteHeader(200)
+	reporter.Report(responseWriter)
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/store"
+	"github.com/julienschmidt/httprouter"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+	"github.com/tsenart/vegeta/v12/lib/plot"
+)
+
+// plotDownsampleThreshold is the maximum number of points per plotted series, as in vegeta plot.
+const plotDownsampleThreshold = 4000
+
+// plotSourceResults plots the raw results of a run.
+const plotSourceResults = "results"
+
+// plotSourceTimeSeries plots the per-second latency percentiles of a run.
+const plotSourceTimeSeries = "timeseries"
+
+// HandleLoadTestPlot responds with a self-contained HTML plot of the latencies of a run over time, like vegeta plot.
+// The plot is built from the raw results of the run when workers reported them, and from its time series otherwise.
+// The source query parameter forces either: results or timeseries.
+func (s *Server) HandleLoadTestPlot(responseWriter http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	run, err := s.GetRun(params.ByName("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(responseWriter, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	workers, err := s.store.ResultsWorkers(run.ID)
+	if err != nil {
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	source := req.URL.Query().Get("source")
+	switch source {
+	case "":
+		source = plotSourceTimeSeries
+		if len(workers) > 0 {
+			source = plotSourceResults
+		}
+	case plotSourceResults:
+		if len(workers) == 0 {
+			http.Error(responseWriter, "the load test has no raw results", http.StatusNotFound)
+			return
+		}
+	case plotSourceTimeSeries:
+	default:
+		http.Error(responseWriter, "unknown plot source: "+source, http.StatusBadRequest)
+		return
+	}
+
+	var p *plot.Plot
+	if source == plotSourceResults {
+		p, err = s.plotResults(run)
+	} else {
+		p, err = plotTimeSeries(run)
+	}
+
+	if err != nil {
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if _, err := p.WriteTo(&buf); err != nil {
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	header := responseWriter.Header()
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	header.Set("Content-Disposition", `attachment; filename="terjang-`+run.ID+`.html"`)
+
+	responseWriter.WriteHeader(200)
+	responseWriter.Write(buf.Bytes())
+}
+
+func plotTitle(run *messages.LoadTestRun) string {
+	return "Terjang load test " + run.ID
+}
+
+// plotResults plots the raw results of a run, with a series per worker.
+func (s *Server) plotResults(run *messages.LoadTestRun) (*plot.Plot, error) {
+	p := plot.New(plot.Title(plotTitle(run)), plot.Downsample(plotDownsampleThreshold))
+
+	err := s.decodeResults(run.ID, "", func(worker string, res *vegeta.Result) error {
+		// Sequence numbers are per worker, so each worker is plotted as a separate attack.
+		res.Attack = worker
+		return p.Add(res)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p.Close()
+
+	return p, nil
+}
+
+// plotTimeSeries plots the latency percentiles of each second of a run.
+func plotTimeSeries(run *messages.LoadTestRun) (*plot.Plot, error) {
+	var label string
+	p := plot.New(
+		plot.Title(plotTitle(run)),
+		plot.Downsample(plotDownsampleThreshold),
+		plot.Label(func(*vegeta.Result) string { return label }),
+	)
+
+	seq := uint64(0)
+
+	for _, point := range run.TimeSeries {
+		if point.Requests == 0 {
+			continue
+		}
+
+		percentiles := []struct {
+			label   string
+			latency time.Duration
+		}{
+			{"p50", point.Latencies.P50},
+			{"p90", point.Latencies.P90},
+			{"p95", point.Latencies.P95},
+			{"p99", point.Latencies.P99},
+			{"max", point.Latencies.Max},
+		}
+
+		for _, percentile := range percentiles {
+			label = percentile.label
+
+			err := p.Add(&vegeta.Result{
+				Attack:    "latency",
+				Seq:       seq,
+				Timestamp: point.Timestamp,
+				Latency:   percentile.latency,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			seq++
+		}
+	}
+
+	p.Close()
+
+	return p, nil
+}
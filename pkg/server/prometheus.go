@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/julienschmidt/httprouter"
+)
+
+// prometheusContentType is the content type of the Prometheus text exposition format.
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// prometheusQuantiles are the latency quantiles exposed in the latency summaries.
+var prometheusQuantiles = []struct {
+	label string
+	value func(m *messages.LoadTestMetrics) time.Duration
+}{
+	{"0.5", func(m *messages.LoadTestMetrics) time.Duration { return m.Latencies.P50 }},
+	{"0.9", func(m *messages.LoadTestMetrics) time.Duration { return m.Latencies.P90 }},
+	{"0.95", func(m *messages.LoadTestMetrics) time.Duration { return m.Latencies.P95 }},
+	{"0.99", func(m *messages.LoadTestMetrics) time.Duration { return m.Latencies.P99 }},
+	{"1", func(m *messages.LoadTestMetrics) time.Duration { return m.Latencies.Max }},
+}
+
+// serverStates lists the load test states of the server, in the order they are exposed.
+var serverStates = []int{
+	messages.ServerStateNotStarted,
+	messages.ServerStateRunning,
+	messages.ServerStateDone,
+	messages.ServerStateStopped,
+}
+
+// workerStates lists the states of a worker, in the order they are exposed.
+var workerStates = []messages.WorkerState{
+	messages.WorkerStateNotStarted,
+	messages.WorkerStateRunning,
+	messages.WorkerStateDone,
+	messages.WorkerStateStopped,
+}
+
+// HandleMetrics responds with the state of the server and the metrics of the current load test
+// in the Prometheus text exposition format, cluster-wide and per worker.
+func (s *Server) HandleMetrics(responseWriter http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var p prometheusWriter
+
+	workers := s.workerService.workersStatus()
+	labels := workerLabels(workers)
+
+	p.family("terjang_workers", "gauge", "Number of connected workers.",
+		prometheusSample{value: float64(len(workers))})
+
+	loadTestState := s.getLoadTestState()
+	states := make([]prometheusSample, 0, len(serverStates))
+	for _, state := range serverStates {
+		states = append(states, prometheusSample{
+			labels: []string{"state", loadTestStateToString(state)},
+			value:  boolToFloat(state == loadTestState),
+		})
+	}
+	p.family("terjang_load_test_state", "gauge", "Current load test state of the server, 1 for the current state.", states...)
+
+	if runID := s.currentRunID(); runID != "" {
+		p.family("terjang_load_test_info", "gauge", "ID of the current or last load test run.",
+			prometheusSample{labels: []string{"run_id", runID}, value: 1})
+	}
+
+	workerStateSamples := make([]prometheusSample, 0, len(workers)*len(workerStates))
+	for i, wk := range workers {
+		for _, state := range workerStates {
+			workerStateSamples = append(workerStateSamples, prometheusSample{
+				labels: []string{"worker", labels[i], "state", workerStateToString(state)},
+				value:  boolToFloat(state == wk.state),
+			})
+		}
+	}
+	p.family("terjang_worker_state", "gauge", "Current state of each worker, 1 for the current state.", workerStateSamples...)
+
+	metrics := s.workerService.AggregatedMetrics()
+	p.loadTestFamilies("terjang_", "the load test", []prometheusScope{{metrics: &metrics}})
+
+	scopes := make([]prometheusScope, 0, len(workers))
+	for i, wk := range workers {
+		m := AggregateMetrics([]messages.WorkerLoadTestMetrics{wk.metrics})
+		scopes = append(scopes, prometheusScope{labels: []string{"worker", labels[i]}, metrics: &m})
+	}
+	p.loadTestFamilies("terjang_worker_", "each worker in the load test", scopes)
+
+	header := responseWriter.Header()
+	header.Set("Content-Type", prometheusContentType)
+
+	responseWriter.WriteHeader(200)
+	responseWriter.Write(p.buf.Bytes())
+}
+
+// workerLabels returns the worker label of each worker, sorted as by workersStatus. Prometheus rejects a whole scrape
+// with duplicate series, so workers sharing a name are told apart by the order they joined: the first one keeps
+// the name and the next ones are labelled "<name>#2", "<name>#3", ...
+func workerLabels(workers []workerStatus) []string {
+	labels := make([]string, len(workers))
+
+	n := 0
+	for i, wk := range workers {
+		if i > 0 && wk.name == workers[i-1].name {
+			n++
+			labels[i] = wk.name + "#" + strconv.Itoa(n+1)
+			continue
+		}
+
+		n = 0
+		labels[i] = wk.name
+	}
+
+	return labels
+}
+
+// prometheusScope is a set of load test metrics exposed with the same labels.
+type prometheusScope struct {
+	labels  []string
+	metrics *messages.LoadTestMetrics
+}
+
+// prometheusSample is a sample of a metric family. Summaries have samples suffixed with _sum and _count.
+type prometheusSample struct {
+	suffix string
+	labels []string
+	value  float64
+}
+
+// prometheusWriter writes metric families in the Prometheus text exposition format.
+type prometheusWriter struct {
+	buf bytes.Buffer
+}
+
+// loadTestFamilies writes the load test metrics of each scope. The metrics are reset when a load test starts.
+func (p *prometheusWriter) loadTestFamilies(prefix string, subject string, scopes []prometheusScope) {
+	var requests, rate, throughput, success, bytesIn, bytesOut, latencies, codes []prometheusSample
+
+	for _, scope := range scopes {
+		m := scope.metrics
+
+		requests = append(requests, prometheusSample{labels: scope.labels, value: float64(m.Requests)})
+		rate = append(rate, prometheusSample{labels: scope.labels, value: m.Rate})
+		throughput = append(throughput, prometheusSample{labels: scope.labels, value: m.Throughput})
+		success = append(success, prometheusSample{labels: scope.labels, value: m.Success})
+		bytesIn = append(bytesIn, prometheusSample{labels: scope.labels, value: float64(m.BytesIn.Total)})
+		bytesOut = append(bytesOut, prometheusSample{labels: scope.labels, value: float64(m.BytesOut.Total)})
+
+		for _, q := range prometheusQuantiles {
+			latencies = append(latencies, prometheusSample{
+				labels: append(append([]string{}, scope.labels...), "quantile", q.label),
+				value:  q.value(m).Seconds(),
+			})
+		}
+		latencies = append(latencies,
+			prometheusSample{suffix: "_sum", labels: scope.labels, value: m.Latencies.Total.Seconds()},
+			prometheusSample{suffix: "_count", labels: scope.labels, value: float64(m.Requests)},
+		)
+
+		statusCodes := make([]string, 0, len(m.StatusCodes))
+		for code := range m.StatusCodes {
+			statusCodes = append(statusCodes, code)
+		}
+		sort.Strings(statusCodes)
+
+		for _, code := range statusCodes {
+			codes = append(codes, prometheusSample{
+				labels: append(append([]string{}, scope.labels...), "code", code),
+				value:  float64(m.StatusCodes[code]),
+			})
+		}
+	}
+
+	p.family(prefix+"requests_total", "counter", "Number of requests sent by "+subject+".", requests...)
+	p.family(prefix+"rate", "gauge", "Rate of requests sent per second by "+subject+".", rate...)
+	p.family(prefix+"throughput", "gauge", "Rate of successful requests per second of "+subject+".", throughput...)
+	p.family(prefix+"success_ratio", "gauge", "Ratio of successful responses of "+subject+".", success...)
+	p.family(prefix+"latency_seconds", "summary", "Request latencies of "+subject+".", latencies...)
+	p.family(prefix+"responses_total", "counter", "Number of responses by status code of "+subject+", 0 for errors.", codes...)
+	p.family(prefix+"bytes_in_total", "counter", "Number of bytes received by "+subject+".", bytesIn...)
+	p.family(prefix+"bytes_out_total", "counter", "Number of bytes sent by "+subject+".", bytesOut...)
+}
+
+// family writes a metric family with its help and type. Families without samples are left out.
+func (p *prometheusWriter) family(name string, typ string, help string, samples ...prometheusSample) {
+	if len(samples) == 0 {
+		return
+	}
+
+	p.buf.WriteString("# HELP " + name + " " + help + "\n")
+	p.buf.WriteString("# TYPE " + name + " " + typ + "\n")
+
+	for _, sample := range samples {
+		p.buf.WriteString(name + sample.suffix)
+
+		if len(sample.labels) > 0 {
+			p.buf.WriteByte('{')
+			for i := 0; i+1 < len(sample.labels); i += 2 {
+				if i > 0 {
+					p.buf.WriteByte(',')
+				}
+				p.buf.WriteString(sample.labels[i] + `="` + escapeLabelValue(sample.labels[i+1]) + `"`)
+			}
+			p.buf.WriteByte('}')
+		}
+
+		p.buf.WriteString(" " + strconv.FormatFloat(sample.value, 'g', -1, 64) + "\n")
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
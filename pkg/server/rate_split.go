@@ -0,0 +1,78 @@
+package server
+
+import (
+	"sort"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+)
+
+// computeShares returns the fraction of the total load profile each worker runs, given their weights.
+//
+// For a constant rate the total is split into whole requests per second: each worker gets the
+// integer part of its weighted share and the remainder goes to the workers with the largest
+// fractional parts, so the per-worker rates always add up to the requested rate. Other load
+// profiles vary their rate over time, so they are split proportionally to the weights.
+func computeShares(req *messages.StartLoadTestRequest, weights []int) []float64 {
+	shares := make([]float64, len(weights))
+
+	totalWeight := 0
+	for _, w := range weights {
+		totalWeight += w
+	}
+
+	if totalWeight == 0 {
+		return shares
+	}
+
+	if (req.Pacer != "" && req.Pacer != messages.PacerConstant) || req.Rate == 0 {
+		for i, w := range weights {
+			shares[i] = float64(w) / float64(totalWeight)
+		}
+
+		return shares
+	}
+
+	for i, rate := range splitRate(req.Rate, weights) {
+		shares[i] = float64(rate) / float64(req.Rate)
+	}
+
+	return shares
+}
+
+// splitRate divides a total rate across workers proportionally to their weights using the largest remainder method.
+func splitRate(total uint64, weights []int) []uint64 {
+	rates := make([]uint64, len(weights))
+
+	totalWeight := uint64(0)
+	for _, w := range weights {
+		totalWeight += uint64(w)
+	}
+
+	if totalWeight == 0 {
+		return rates
+	}
+
+	remainders := make([]uint64, len(weights))
+	assigned := uint64(0)
+
+	for i, w := range weights {
+		rates[i] = total * uint64(w) / totalWeight
+		remainders[i] = total * uint64(w) % totalWeight
+		assigned += rates[i]
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+
+	for i := uint64(0); i < total-assigned; i++ {
+		rates[order[i]]++
+	}
+
+	return rates
+}
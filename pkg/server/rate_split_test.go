@@ -0,0 +1,53 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		total   uint64
+		weights []int
+		want    []uint64
+	}{
+		{"even", 10, []int{1, 1}, []uint64{5, 5}},
+		{"remainder to the first of equal remainders", 10, []int{1, 1, 1}, []uint64{4, 3, 3}},
+		{"remainder to the largest fraction", 10, []int{1, 2, 4}, []uint64{1, 3, 6}},
+		{"less than one request each", 2, []int{1, 1, 1}, []uint64{1, 1, 0}},
+		{"zero rate", 0, []int{1, 1}, []uint64{0, 0}},
+		{"zero weight", 10, []int{0, 1, 1}, []uint64{0, 5, 5}},
+		{"zero weights", 10, []int{0, 0}, []uint64{0, 0}},
+		{"no worker", 10, nil, []uint64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, splitRate(tt.total, tt.weights))
+		})
+	}
+}
+
+func TestComputeShares(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     messages.StartLoadTestRequest
+		weights []int
+		want    []float64
+	}{
+		{"constant rate in whole requests", messages.StartLoadTestRequest{Rate: 10}, []int{1, 1, 1}, []float64{0.4, 0.3, 0.3}},
+		{"weighted", messages.StartLoadTestRequest{Rate: 10}, []int{1, 4}, []float64{0.2, 0.8}},
+		{"other pacers proportionally", messages.StartLoadTestRequest{Rate: 10, Pacer: messages.PacerLinear}, []int{1, 1, 2}, []float64{0.25, 0.25, 0.5}},
+		{"zero rate proportionally", messages.StartLoadTestRequest{}, []int{1, 3}, []float64{0.25, 0.75}},
+		{"zero weights", messages.StartLoadTestRequest{Rate: 10}, []int{0, 0}, []float64{0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDeltaSlice(t, tt.want, computeShares(&tt.req, tt.weights), 1e-9)
+		})
+	}
+}
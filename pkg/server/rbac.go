@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Role grants access to API operations. Each role includes the operations of the lower ones.
+type Role int
+
+// RoleViewer can subscribe to notifications and read the server state, metrics and load test history.
+const RoleViewer = Role(1)
+
+// RoleOperator can also start and stop load tests.
+const RoleOperator = Role(2)
+
+// RoleAdmin can also manage workers.
+const RoleAdmin = Role(3)
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	}
+
+	return ""
+}
+
+// MarshalText encodes a role as its name.
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText decodes a role from its name: viewer, operator or admin.
+func (r *Role) UnmarshalText(text []byte) error {
+	for _, role := range []Role{RoleViewer, RoleOperator, RoleAdmin} {
+		if role.String() == string(text) {
+			*r = role
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown role %q: must be viewer, operator or admin", text)
+}
+
+// User is a user of the API, identified by a token.
+type User struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	Role  Role   `json:"role"`
+}
+
+// usersFile is the format of a users file.
+type usersFile struct {
+	Users []User `json:"users"`
+}
+
+// LoadUsers reads users from a JSON file, e.g.
+//
+//	{"users": [{"name": "alice", "token": "...", "role": "admin"}, {"name": "grafana", "token": "...", "role": "viewer"}]}
+func LoadUsers(file string) ([]User, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read users file: %w", err)
+	}
+
+	var f usersFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("Failed to parse users file: %w", err)
+	}
+
+	for i, u := range f.Users {
+		if u.Token == "" || u.Role == 0 {
+			return nil, fmt.Errorf("user %d (%q) of the users file needs a token and a role", i+1, u.Name)
+		}
+	}
+
+	return f.Users, nil
+}
+
+// AddUser registers a user of the HTTP API, the notifications and the metrics.
+// Users send their token as a bearer token. When subscribing to the notifications, browser websockets, which can not
+// set headers, send it in the Sec-WebSocket-Protocol header, and the access_token query parameter is accepted for
+// other websocket clients that can not. Query parameters end up in logs, so no other route accepts them.
+// When no user is registered, the API is open to anyone.
+func (s *Server) AddUser(u User) {
+	s.users = append(s.users, u)
+}
+
+type userContextKey struct{}
+
+// userFromContext returns the authenticated user of a request, or nil when the API is open.
+func userFromContext(ctx context.Context) *User {
+	u, _ := ctx.Value(userContextKey{}).(*User)
+	return u
+}
+
+// authorize wraps an API handler to reject requests without the token of a user with at least the given role,
+// and to allow the configured origins to read the response. The user is available to the handler with userFromContext.
+func (s *Server) authorize(role Role, h httprouter.Handle) httprouter.Handle {
+	return s.authorizeWith(bearerToken, role, h)
+}
+
+// authorizeUpgrade is authorize for websocket upgrades, which may also send their token as a subprotocol
+// or an access_token query parameter.
+func (s *Server) authorizeUpgrade(role Role, h httprouter.Handle) httprouter.Handle {
+	return s.authorizeWith(upgradeToken, role, h)
+}
+
+func (s *Server) authorizeWith(token func(*http.Request) string, role Role, h httprouter.Handle) httprouter.Handle {
+	return func(responseWriter http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		s.setCORSHeaders(responseWriter, req)
+
+		if len(s.users) == 0 {
+			h(responseWriter, req, params)
+			return
+		}
+
+		user := s.userForToken(token(req))
+		if user == nil {
+			unauthorized(responseWriter)
+			return
+		}
+
+		if user.Role < role {
+			http.Error(responseWriter, fmt.Sprintf("the %s role is required", role), http.StatusForbidden)
+			return
+		}
+
+		h(responseWriter, req.WithContext(context.WithValue(req.Context(), userContextKey{}, user)), params)
+	}
+}
+
+// userForToken returns the user with a token, or nil. All tokens are compared in constant time.
+func (s *Server) userForToken(token string) *User {
+	if token == "" {
+		return nil
+	}
+
+	var found *User
+	for i := range s.users {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.users[i].Token)) == 1 && found == nil {
+			found = &s.users[i]
+		}
+	}
+
+	return found
+}
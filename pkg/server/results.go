@@ -0,0 +1,145 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/store"
+	"github.com/julienschmidt/httprouter"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// saveResults stores a batch of raw results reported by a worker, under its ID within the run. Batches of runs
+// other than the current one are late or bogus and are dropped, and so are batches received twice.
+func (s *Server) saveResults(workerID string, results *messages.WorkerResults) {
+	s.runLock.Lock()
+	current := s.currentRun != nil && s.currentRun.ID == results.RunID
+	expected := s.resultsSeq[workerID] + 1
+	if current && results.Seq >= expected {
+		s.resultsSeq[workerID] = results.Seq
+	}
+	s.runLock.Unlock()
+
+	if !current {
+		logger.Warnw("Dropping raw results of a run that is not the current one", "id", results.RunID, "worker", workerID)
+		return
+	}
+
+	// Older workers do not number their batches.
+	if results.Seq != 0 && results.Seq < expected {
+		logger.Warnw("Dropping raw results received twice", "id", results.RunID, "worker", workerID, "seq", results.Seq)
+		return
+	}
+
+	if results.Seq > expected {
+		logger.Warnw("Raw results of a worker were lost", "id", results.RunID, "worker", workerID,
+			"batches", results.Seq-expected)
+	}
+
+	if err := s.store.AppendResults(results.RunID, workerID, results.Data); err != nil {
+		logger.Errorw("Failed to save raw results", "id", results.RunID, "worker", workerID, "error", err)
+	}
+}
+
+// decodeResults decodes the raw results of a run, from all workers or from a single one when worker is not empty.
+// Results are decoded worker by worker, in the order each worker reported them.
+func (s *Server) decodeResults(id string, worker string, handle func(worker string, res *vegeta.Result) error) error {
+	workers := []string{worker}
+	if worker == "" {
+		var err error
+		if workers, err = s.store.ResultsWorkers(id); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range workers {
+		if err := s.decodeWorkerResults(id, name, handle); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) decodeWorkerResults(id string, worker string, handle func(worker string, res *vegeta.Result) error) error {
+	rc, err := s.store.OpenResults(id, worker)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	dec := vegeta.DecoderFor(rc)
+	if dec == nil {
+		return fmt.Errorf("unknown encoding of the raw results of worker %q", worker)
+	}
+
+	for {
+		var res vegeta.Result
+		if err := dec.Decode(&res); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Failed to decode raw results of worker %q: %w", worker, err)
+		}
+
+		if err := handle(worker, &res); err != nil {
+			return err
+		}
+	}
+}
+
+// HandleLoadTestResults responds with the raw results workers reported for a run, so they can be analyzed
+// with vegeta, e.g. vegeta plot or vegeta report. The encoding query parameter selects the vegeta encoding:
+// gob (default), csv or json. The results of a single worker can be selected with the worker query parameter,
+// set to the worker's ID within the run: its name, followed by "#<n>" for workers with the same name.
+func (s *Server) HandleLoadTestResults(responseWriter http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	id := params.ByName("id")
+
+	if _, err := s.GetRun(id); errors.Is(err, store.ErrNotFound) {
+		http.Error(responseWriter, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var encoder vegeta.Encoder
+	header := responseWriter.Header()
+
+	switch encoding := req.URL.Query().Get("encoding"); encoding {
+	case "", messages.ResultsEncodingGob:
+		encoder = vegeta.NewEncoder(responseWriter)
+		header.Set("Content-Type", "application/octet-stream")
+	case messages.ResultsEncodingCSV:
+		encoder = vegeta.NewCSVEncoder(responseWriter)
+		header.Set("Content-Type", "text/csv")
+	case messages.ResultsEncodingJSON:
+		encoder = vegeta.NewJSONEncoder(responseWriter)
+		header.Set("Content-Type", "application/json")
+	default:
+		http.Error(responseWriter, "unknown encoding: "+encoding, http.StatusBadRequest)
+		return
+	}
+
+	worker := req.URL.Query().Get("worker")
+	if worker != "" {
+		rc, err := s.store.OpenResults(id, worker)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(responseWriter, "no results for worker: "+worker, http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rc.Close()
+	}
+
+	responseWriter.WriteHeader(200)
+
+	// The status is sent already, a decoding error can only be logged.
+	if err := s.decodeResults(id, worker, func(_ string, res *vegeta.Result) error { return encoder.Encode(res) }); err != nil {
+		logger.Errorw("Failed to send raw results", "id", id, "error", err)
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/fs"
@@ -9,10 +10,17 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/andylibrian/terjang/pkg/audit"
 	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/sink"
+	"github.com/andylibrian/terjang/pkg/store"
+	"github.com/andylibrian/terjang/pkg/transport"
 	"github.com/gorilla/websocket"
 	"github.com/julienschmidt/httprouter"
 	"go.uber.org/zap"
@@ -45,16 +53,56 @@ type Server struct {
 	notificationService *NotificationService
 	httpServer          *http.Server
 	loadTestState       int
+	stateLock           sync.Mutex
+	store               store.Store
+	currentRun          *messages.LoadTestRun
+	thresholds          []threshold
+	// resultsSeq is the sequence number of the last batch of raw results of each worker in the current run,
+	// by the worker's ID within the run.
+	resultsSeq          map[string]uint64
+	runLock             sync.Mutex
+	auditLog            audit.Log
+	sinks               []sink.Sink
+	sinkLock            sync.Mutex
+	sinkQueue           chan sinkBatch
+	clusterSecret       string
+	users               []User
+	allowedOrigins      []string
+	tlsConfig           *tls.Config
+	resumeGracePeriod   time.Duration
+	heartbeatInterval   time.Duration
+	heartbeatTimeout    time.Duration
+	evictionGracePeriod time.Duration
+	ackTimeout          time.Duration
+	version             string
 }
 
+// defaultResumeGracePeriod is how long a load test keeps running without workers by default.
+const defaultResumeGracePeriod = 10 * time.Second
+
 // NewServer creates a new instance of server.
 func NewServer() *Server {
-	return &Server{
-		upgrader:            websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }},
+	s := &Server{
 		workerService:       NewWorkerService(),
 		notificationService: NewNotificationService(),
+		httpServer:          &http.Server{},
 		loadTestState:       messages.ServerStateNotStarted,
+		store:               store.NewMemoryStore(),
+		auditLog:            audit.NewMemoryLog(),
+		resumeGracePeriod:   defaultResumeGracePeriod,
+		heartbeatInterval:   defaultHeartbeatInterval,
+		heartbeatTimeout:    defaultHeartbeatTimeout,
+		evictionGracePeriod: defaultEvictionGracePeriod,
+		ackTimeout:          defaultAckTimeout,
+		sinkQueue:           make(chan sinkBatch, sinkQueueSize),
+		version:             "dev",
 	}
+
+	s.upgrader = websocket.Upgrader{CheckOrigin: s.checkOrigin, Subprotocols: []string{notificationsProtocol}}
+	s.workerService.resultsHandler = s.saveResults
+	s.workerService.resumeHandler = s.resumeWorker
+
+	return s
 }
 
 // GetWorkerService returns the worker service.
@@ -62,6 +110,19 @@ func (s *Server) GetWorkerService() *WorkerService {
 	return s.workerService
 }
 
+// SetStore registers the store used to persist load test history. By default, history is kept in memory.
+func (s *Server) SetStore(st store.Store) {
+	s.store = st
+}
+
+// SetResumeGracePeriod sets how long a load test keeps running after its last worker lost its connection,
+// so that the workers can reconnect and resume it. The default is 10 seconds. Workers removed on purpose
+// stop the load test right away. In RateModeTotal, the share of a worker that lost its connection is kept for it
+// during the grace period, as it may still be running it.
+func (s *Server) SetResumeGracePeriod(d time.Duration) {
+	s.resumeGracePeriod = d
+}
+
 // Run listens on the specified port and serve requests.
 func (s *Server) Run(addr string) error {
 	router, err := s.setupRouter()
@@ -71,9 +132,14 @@ func (s *Server) Run(addr string) error {
 	}
 
 	go s.runNotificationLoop()
+	go s.runSinkLoop()
+	go s.runSinkWriter()
+	go s.runHeartbeatLoop()
 	go s.watchWorkerStateChange()
 
-	s.httpServer = &http.Server{Addr: addr, Handler: router}
+	s.httpServer.Addr = addr
+	s.httpServer.Handler = router
+	s.httpServer.TLSConfig = s.tlsConfig
 
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
@@ -85,9 +151,15 @@ func (s *Server) Run(addr string) error {
 		s.httpServer.Shutdown(ctx)
 	}()
 
-	logger.Infow("Server is listening on", "address", addr)
+	logger.Infow("Server is listening on", "address", addr, "tls", s.tlsConfig != nil)
+
+	if s.tlsConfig != nil {
+		err = s.httpServer.ListenAndServeTLS("", "")
+	} else {
+		err = s.httpServer.ListenAndServe()
+	}
 
-	if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("Server failed to listen and serve: %w", err)
 	}
 
@@ -96,9 +168,7 @@ func (s *Server) Run(addr string) error {
 
 // Close is a method that close an httpServer and has a receiver type of *Server and returns a type error, a built-in interface
 func (s *Server) Close() error {
-	if s.httpServer == nil {
-		return nil
-	}
+	s.closeSinks()
 
 	return s.httpServer.Close()
 }
@@ -115,23 +185,35 @@ func (s *Server) setupRouter() (*httprouter.Router, error) {
 	router.Handler("GET", "/js/*filepath", http.FileServer(http.FS(sub)))
 	router.Handler("GET", "/css/*filepath", http.FileServer(http.FS(sub)))
 
-	router.GET("/cluster/join", s.acceptWorkerConn)
-	router.GET("/notifications", s.acceptNotificationConn)
-	router.POST("/api/v1/load_test", s.handleStartLoadTest)
-	router.DELETE("/api/v1/load_test", s.handleStopLoadTest)
+	router.GET("/cluster/join", s.requireWorkerCertificate(s.requireClusterSecret(s.acceptWorkerConn)))
+	router.GET("/notifications", s.authorizeUpgrade(RoleViewer, s.acceptNotificationConn))
+	router.POST("/api/v1/load_test", s.authorize(RoleOperator, s.handleStartLoadTest))
+	router.DELETE("/api/v1/load_test", s.authorize(RoleOperator, s.handleStopLoadTest))
 
 	router.GET("/healthz", s.handleHealthz)
-
-	router.GET("/api/v1/server_info", s.HandleServerInfo)
-	router.GET("/api/v1/workers_info", s.HandleWorkersInfo)
+	router.GET("/metrics", s.authorize(RoleViewer, s.HandleMetrics))
+
+	router.GET("/api/v1/server_info", s.authorize(RoleViewer, s.HandleServerInfo))
+	router.GET("/api/v1/workers_info", s.authorize(RoleViewer, s.HandleWorkersInfo))
+	router.DELETE("/api/v1/workers/:name", s.authorize(RoleAdmin, s.HandleRemoveWorker))
+	router.GET("/api/v1/audit", s.authorize(RoleAdmin, s.HandleAuditLog))
+	router.GET("/api/v1/load_test/metrics", s.authorize(RoleViewer, s.HandleLoadTestMetrics))
+	router.GET("/api/v1/load_tests", s.authorize(RoleViewer, s.HandleLoadTests))
+	router.GET("/api/v1/load_tests/:id", s.authorize(RoleViewer, s.HandleLoadTest))
+	router.GET("/api/v1/load_tests/:id/timeseries", s.authorize(RoleViewer, s.HandleLoadTestTimeSeries))
+	router.GET("/api/v1/load_tests/:id/report", s.authorize(RoleViewer, s.HandleLoadTestReport))
+	router.GET("/api/v1/load_tests/:id/results", s.authorize(RoleViewer, s.HandleLoadTestResults))
+	router.GET("/api/v1/load_tests/:id/plot", s.authorize(RoleViewer, s.HandleLoadTestPlot))
 
 	// CORS
 	router.GlobalOPTIONS = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Header.Get("Access-Control-Request-Method") != "" {
+			s.setCORSHeaders(w, r)
+
 			header := w.Header()
 			header.Set("Access-Control-Allow-Methods", header.Get("Allow"))
-			header.Set("Access-Control-Allow-Origin", "*")
-			header.Set("Access-Control-Allow-Headers", "*")
+			// Authorization is not covered by a wildcard, it has to be listed.
+			header.Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
 		}
 
 		w.WriteHeader(204)
@@ -159,6 +241,15 @@ func (s *Server) acceptWorkerConn(responseWriter http.ResponseWriter, req *http.
 		name = names[0]
 	}
 
+	weight := 1
+	if w := req.URL.Query().Get("weight"); w != "" {
+		var err error
+		if weight, err = strconv.Atoi(w); err != nil || weight < 1 {
+			http.Error(responseWriter, "weight must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
 	conn, err := s.upgrader.Upgrade(responseWriter, req, nil)
 	if err != nil {
 		logger.Warnw("Failed to upgrade websocket connection", "error", err)
@@ -166,28 +257,124 @@ func (s *Server) acceptWorkerConn(responseWriter http.ResponseWriter, req *http.
 		return
 	}
 
-	s.workerService.AddWorker(conn, name)
+	s.ServeWorker(transport.NewWebsocketConn(conn), name, weight)
+}
+
+// ServeWorker registers a worker connected through conn and handles its messages until the connection is closed.
+// It is used for workers joining over websocket as well as in-process workers connected with transport.Pipe.
+func (s *Server) ServeWorker(conn transport.Conn, name string, weight int) {
+	s.workerService.AddWorker(conn, name, weight)
 
-	logger.Infow("Worker connected", "name", name)
+	logger.Infow("Worker connected", "name", name, "weight", weight)
 
-	defer s.stopLoadTestIfNoWorkerRemaining()
-	defer logger.Infow("Worker removed", "name", name)
-	defer s.workerService.RemoveWorker(conn)
-	defer conn.Close()
+	defer func() {
+		conn.Close()
+
+		// A worker that lost its connection may still be running its share of the load test, so its share is
+		// kept until it resumes the run or the grace period expires. Then the remaining workers take it over.
+		removed, reserved := s.workerService.removeWorker(conn, s.resumeGracePeriod > 0)
+		logger.Infow("Worker removed", "name", name)
+
+		if reserved == nil {
+			s.rebalanceLoadTest()
+		}
 
+		// The remaining workers may all have finished the run.
+		s.workerService.stateUpdatedCh <- struct{}{}
+
+		if removed || s.resumeGracePeriod <= 0 {
+			s.stopLoadTestIfNoWorkerRemaining()
+			return
+		}
+
+		time.AfterFunc(s.resumeGracePeriod, func() {
+			if reserved != nil && s.workerService.releaseShare(reserved) {
+				s.rebalanceLoadTest()
+			}
+
+			s.stopLoadTestIfNoWorkerRemaining()
+		})
+	}()
+
+	handshake := true
 	for {
-		_, message, err := conn.ReadMessage()
+		message, err := conn.ReadMessage()
 		if err != nil {
 			break
 		}
 
+		s.workerService.touch(conn)
+
+		// Heartbeat replies only count as a sign of life.
+		switch messages.KindOf(message) {
+		case messages.KindPong:
+			continue
+		case messages.KindAck:
+			s.workerService.acknowledge(conn, message)
+			continue
+		}
+
+		// Workers start with a hello, except those older than the handshake.
+		if handshake {
+			handshake = false
+
+			if hello, ok := parseHello(message); ok {
+				if !s.welcomeWorker(conn, name, hello) {
+					break
+				}
+
+				continue
+			}
+
+			logger.Warnw("Worker joined without a hello, assuming an older protocol", "name", name)
+		}
+
 		s.workerService.GetMessageHandler().HandleMessage(conn, message)
 	}
 }
 
+// resumeWorker re-attaches a worker announcing a run, e.g. after reconnecting, to the current run when it is
+// still running. A worker still running a load test that is not the current run anymore is asked to stop.
+func (s *Server) resumeWorker(conn transport.Conn, info *messages.WorkerInfo) {
+	s.runLock.Lock()
+	current := s.currentRun != nil && s.currentRun.ID == info.RunID && s.currentRun.EndedAt == nil
+	s.runLock.Unlock()
+
+	if current {
+		name, ok := s.workerService.attachRun(conn, info.RunID)
+		if !ok {
+			return
+		}
+
+		logger.Infow("Worker resumed load test", "name", name, "id", info.RunID)
+
+		if info.State == messages.WorkerStateRunning {
+			s.rebalanceLoadTest()
+		}
+
+		return
+	}
+
+	if info.State == messages.WorkerStateRunning {
+		logger.Infow("Stopping load test of a worker that is not part of the current run", "id", info.RunID)
+
+		s.workerService.sendToWorker(conn, messages.KindStopLoadTestRequest, nil)
+	}
+}
+
 func (s *Server) stopLoadTestIfNoWorkerRemaining() {
-	if len(s.workerService.workers) == 0 {
-		s.loadTestState = messages.ServerStateStopped
+	if s.workerService.count() == 0 {
+		if run := s.snapshotCurrentRun(); run != nil && run.EndedAt == nil {
+			s.recordEvent(&audit.Event{
+				Action: audit.ActionStopLoadTest,
+				User:   audit.UserSystem,
+				RunID:  run.ID,
+				Reason: "no workers remaining",
+			})
+		}
+
+		s.setLoadTestState(messages.ServerStateStopped)
+		s.finishCurrentRun(messages.ServerStateStopped)
 	}
 }
 
@@ -206,6 +393,10 @@ func (s *Server) acceptNotificationConn(responseWriter http.ResponseWriter, req
 	defer s.notificationService.RemoveSubscriber(conn)
 	defer conn.Close()
 
+	done := make(chan struct{})
+	defer close(done)
+	s.keepSubscriberAlive(conn, done)
+
 	for {
 		_, _, err := conn.ReadMessage()
 		if err != nil {
@@ -217,7 +408,7 @@ func (s *Server) acceptNotificationConn(responseWriter http.ResponseWriter, req
 func (s *Server) runNotificationLoop() {
 	for {
 		// Server Info
-		serverInfo := messages.ServerInfo{NumOfWorkers: len(s.workerService.workers), State: loadTestStateToString(s.loadTestState)}
+		serverInfo := messages.ServerInfo{NumOfWorkers: s.workerService.count(), State: loadTestStateToString(s.getLoadTestState())}
 		serverInfoMsg, _ := json.Marshal(serverInfo)
 
 		envelope := messages.Envelope{Kind: messages.KindServerInfo, Data: string(serverInfoMsg)}
@@ -225,71 +416,253 @@ func (s *Server) runNotificationLoop() {
 		s.notificationService.BroadcastMessageToSubscribers([]byte(envelopeMsg))
 
 		// Workers Info
-		var wks []*worker
-		for _, v := range s.workerService.workers {
-			wks = append(wks, v)
-		}
-		workersInfoMsg, _ := json.Marshal(wks)
+		workersInfoMsg, _ := s.workerService.marshalWorkers()
 
 		envelope = messages.Envelope{Kind: messages.KindWorkersInfo, Data: string(workersInfoMsg)}
 		envelopeMsg, _ = json.Marshal(envelope)
 
 		s.notificationService.BroadcastMessageToSubscribers([]byte(envelopeMsg))
 
+		// Aggregated metrics
+		loadTestMetricsMsg, _ := json.Marshal(s.workerService.AggregatedMetrics())
+
+		envelope = messages.Envelope{Kind: messages.KindLoadTestMetrics, Data: string(loadTestMetricsMsg)}
+		envelopeMsg, _ = json.Marshal(envelope)
+
+		s.notificationService.BroadcastMessageToSubscribers([]byte(envelopeMsg))
+
+		// Time series points updated since the previous notification
+		if points := s.workerService.GetTimeSeries().TakeUpdated(); len(points) > 0 {
+			timeSeriesMsg, _ := json.Marshal(points)
+
+			envelope = messages.Envelope{Kind: messages.KindLoadTestTimeSeries, Data: string(timeSeriesMsg)}
+			envelopeMsg, _ = json.Marshal(envelope)
+
+			s.notificationService.BroadcastMessageToSubscribers([]byte(envelopeMsg))
+		}
+
+		s.updateCurrentRun()
+
 		time.Sleep(1 * time.Second)
 	}
 }
 
 // StartLoadTest sends a request to workers to start a load test.
-func (s *Server) StartLoadTest(r *messages.StartLoadTestRequest) {
-	req, _ := json.Marshal(r)
-	envelope, _ := json.Marshal(messages.Envelope{Kind: messages.KindStartLoadTestRequest, Data: string(req)})
+// The load test is recorded as a new run in the history, and in the audit log as started by the system.
+func (s *Server) StartLoadTest(r *messages.StartLoadTestRequest) *messages.LoadTestRun {
+	return s.startLoadTest(r, &audit.Event{User: audit.UserSystem})
+}
+
+// startLoadTest starts a load test on behalf of the user of an audit event.
+func (s *Server) startLoadTest(r *messages.StartLoadTestRequest, event *audit.Event) *messages.LoadTestRun {
+	runRequest := *r
+	runRequest.RunID = newRunID()
+
+	// A load test still running is stopped first, so that its workers do not keep attacking along with the new one.
+	if s.getLoadTestState() == messages.ServerStateRunning {
+		s.stopLoadTest(&audit.Event{User: event.User, Reason: "superseded by a new load test"})
+	}
+
+	s.finishCurrentRun(messages.ServerStateStopped)
+	// Metrics from the previous run must not leak into the new one.
+	// Its last points are pushed before they are cleared.
+	s.pushMetrics()
+	s.GetWorkerService().ResetMetrics()
+	run := s.beginRun(runRequest)
+
+	for _, name := range s.GetWorkerService().AssignRun(run.ID, &runRequest) {
+		logger.Warnw("Worker does not support the load test, leaving it out", "name", name, "id", run.ID)
+	}
+
+	s.setLoadTestState(messages.ServerStateRunning)
+
+	var pending []*pendingAck
+	if runRequest.RateMode == messages.RateModeTotal {
+		pending = s.GetWorkerService().sendShares(run.ID, &runRequest, messages.KindStartLoadTestRequest, func(workerID string, share float64) interface{} {
+			workerRequest := runRequest
+			workerRequest.WorkerID = workerID
+			workerRequest.Share = share
+
+			return &workerRequest
+		})
+	} else {
+		pending = s.GetWorkerService().sendToRun(run.ID, messages.KindStartLoadTestRequest, func(workerID string) interface{} {
+			workerRequest := runRequest
+			workerRequest.WorkerID = workerID
+
+			return &workerRequest
+		})
+	}
+
+	logger.Infow("Started load test", "id", run.ID, "request", r, "user", event.User)
+
+	accepted := s.awaitStart(run.ID, pending)
+
+	event.Action = audit.ActionStartLoadTest
+	event.RunID = run.ID
+	event.Request = &runRequest
+	event.Workers = s.GetWorkerService().runWorkers(run.ID)
+	s.recordEvent(event)
+
+	if !accepted {
+		logger.Warnw("No worker accepted the load test, stopping it", "id", run.ID)
+
+		s.recordEvent(&audit.Event{
+			Action: audit.ActionStopLoadTest,
+			User:   audit.UserSystem,
+			RunID:  run.ID,
+			Reason: "no worker accepted the load test",
+		})
+
+		s.setLoadTestState(messages.ServerStateStopped)
+		s.finishCurrentRun(messages.ServerStateStopped)
+	}
+
+	// The run as it is now, with the acknowledgements of the workers.
+	if started, err := s.GetRun(run.ID); err == nil {
+		return started
+	}
+
+	return run
+}
+
+// awaitStart waits for the workers to accept or reject a run, and records their answers with the run.
+// Workers that rejected the run, e.g. for an invalid URL, leave it and the others take over their share.
+// It returns false when every worker rejected the run.
+func (s *Server) awaitStart(runID string, pending []*pendingAck) bool {
+	acks := awaitAcks(pending, s.ackTimeout)
+
+	for _, ack := range acks {
+		switch ack.Status {
+		case messages.AckStatusRejected, messages.AckStatusFailed:
+			logger.Warnw("Worker did not start load test", "name", ack.Name, "id", runID,
+				"status", ack.Status, "error", ack.Error)
+		case messages.AckStatusTimedOut:
+			logger.Warnw("Worker did not acknowledge load test in time", "name", ack.Name, "id", runID,
+				"timeout", s.ackTimeout.Seconds())
+		}
+	}
+
+	left := s.GetWorkerService().leaveRun(pending, acks)
 
-	s.loadTestState = messages.ServerStateRunning
-	s.GetWorkerService().BroadcastMessageToWorkers(envelope)
+	sortAcks(acks)
+	s.setRunAcks(runID, acks)
 
-	logger.Infow("Started load test", "request", r)
+	if left > 0 && left == len(pending) {
+		return false
+	}
+
+	if left > 0 {
+		s.rebalanceLoadTest()
+	}
+
+	return true
 }
 
-// StopLoadTest sends a request to workers to stop a load test.
-func (s *Server) StopLoadTest() {
-	envelope, _ := json.Marshal(messages.Envelope{Kind: messages.KindStopLoadTestRequest})
-	s.GetWorkerService().BroadcastMessageToWorkers(envelope)
+// rebalanceLoadTest divides the total rate of the current run across the workers still running it.
+// It is called when a worker disconnects, so the total load stays as requested.
+func (s *Server) rebalanceLoadTest() {
+	run := s.snapshotCurrentRun()
+	if run == nil || run.EndedAt != nil || run.Request.RateMode != messages.RateModeTotal {
+		return
+	}
 
-	logger.Infow("Stopped load test")
+	pending := s.GetWorkerService().sendShares(run.ID, &run.Request, messages.KindRebalanceLoadTestRequest, func(_ string, share float64) interface{} {
+		return &messages.RebalanceLoadTestRequest{RunID: run.ID, Share: share}
+	})
+
+	logger.Infow("Rebalanced load test", "id", run.ID)
+
+	// Rebalancing happens on disconnects, which must not wait for the other workers.
+	go s.awaitRebalance(run.ID, pending)
 }
 
-func (s *Server) watchWorkerStateChange() {
-	for {
-		<-s.workerService.stateUpdatedCh
-		s.loadTestState = s.summarizeWorkerStates()
+// awaitRebalance waits for the workers to acknowledge their new share of a run, and logs those that did not.
+func (s *Server) awaitRebalance(runID string, pending []*pendingAck) {
+	for _, ack := range awaitAcks(pending, s.ackTimeout) {
+		switch ack.Status {
+		case messages.AckStatusRejected, messages.AckStatusFailed, messages.AckStatusTimedOut:
+			logger.Warnw("Worker did not rebalance load test", "name", ack.Name, "id", runID,
+				"status", ack.Status, "error", ack.Error)
+		}
 	}
 }
 
-func (s *Server) summarizeWorkerStates() int {
-	serverState := s.loadTestState
+// StopLoadTest sends a request to workers to stop a load test, and returns how each worker answered.
+// It is recorded in the audit log as stopped by the system.
+func (s *Server) StopLoadTest() []messages.WorkerAck {
+	return s.stopLoadTest(&audit.Event{User: audit.UserSystem})
+}
+
+// stopLoadTest stops the load test on behalf of the user of an audit event. It returns how each worker
+// answered, sorted by name.
+func (s *Server) stopLoadTest(event *audit.Event) []messages.WorkerAck {
+	pending := s.GetWorkerService().sendToAll(messages.KindStopLoadTestRequest, nil)
 
-	states := make(map[messages.WorkerState]int)
+	logger.Infow("Stopped load test", "user", event.User)
 
-	for _, worker := range s.workerService.workers {
-		if _, ok := states[worker.state]; ok {
-			states[worker.state]++
-		} else {
-			states[worker.state] = 1
+	event.Action = audit.ActionStopLoadTest
+	event.RunID = s.currentRunID()
+	event.Workers = s.GetWorkerService().runWorkers(event.RunID)
+	s.recordEvent(event)
+
+	acks := awaitAcks(pending, s.ackTimeout)
+	for _, ack := range acks {
+		switch ack.Status {
+		case messages.AckStatusRejected, messages.AckStatusFailed, messages.AckStatusTimedOut:
+			logger.Warnw("Worker did not stop load test", "name", ack.Name, "id", event.RunID,
+				"status", ack.Status, "error", ack.Error)
 		}
 	}
 
-	if val, ok := states[messages.WorkerStateDone]; ok && val == len(s.workerService.workers) {
+	sortAcks(acks)
+
+	return acks
+}
+
+func (s *Server) watchWorkerStateChange() {
+	for {
+		<-s.workerService.stateUpdatedCh
+		state := s.summarizeWorkerStates()
+		s.setLoadTestState(state)
+
+		if state == messages.ServerStateDone || state == messages.ServerStateStopped {
+			s.scheduleFinishCurrentRun(state)
+		}
+	}
+}
+
+// summarizeWorkerStates returns the state of the load test, as seen from the workers taking part in the current run.
+// Workers that joined later or rejected the run do not count.
+func (s *Server) summarizeWorkerStates() int {
+	serverState := s.getLoadTestState()
+	states, participants := s.workerService.runStates(s.currentRunID())
+
+	if val, ok := states[messages.WorkerStateDone]; ok && val == participants {
 		serverState = messages.ServerStateDone
 	}
 
-	if val, ok := states[messages.WorkerStateStopped]; ok && val == len(s.workerService.workers) {
+	if val, ok := states[messages.WorkerStateStopped]; ok && val == participants {
 		serverState = messages.ServerStateStopped
 	}
 
 	return serverState
 }
 
+func (s *Server) getLoadTestState() int {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	return s.loadTestState
+}
+
+func (s *Server) setLoadTestState(state int) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	s.loadTestState = state
+}
+
 func loadTestStateToString(s int) string {
 	switch s {
 	case messages.ServerStateNotStarted:
@@ -313,22 +686,37 @@ func (s *Server) handleStartLoadTest(responseWriter http.ResponseWriter, req *ht
 		return
 	}
 
-	s.StartLoadTest(&startLoadTestRequest)
+	switch startLoadTestRequest.RateMode {
+	case "", messages.RateModePerWorker, messages.RateModeTotal:
+	default:
+		http.Error(responseWriter, "unknown rate mode: "+startLoadTestRequest.RateMode, http.StatusBadRequest)
+		return
+	}
+
+	if _, err := parseThresholds(startLoadTestRequest.Thresholds); err != nil {
+		http.Error(responseWriter, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	run := s.startLoadTest(&startLoadTestRequest, apiEvent(req))
+	runMsg, _ := json.Marshal(run)
 
 	header := responseWriter.Header()
-	header.Set("Access-Control-Allow-Origin", "*")
+	header.Set("Content-Type", "application/json")
 
 	responseWriter.WriteHeader(200)
-	responseWriter.Write([]byte("ok"))
+	responseWriter.Write([]byte(runMsg))
 }
 
 func (s *Server) handleStopLoadTest(responseWriter http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	s.StopLoadTest()
+	acks := s.stopLoadTest(apiEvent(req))
+	resultMsg, _ := json.Marshal(messages.StopLoadTestResult{Acks: acks})
 
 	header := responseWriter.Header()
-	header.Set("Access-Control-Allow-Origin", "*")
+	header.Set("Content-Type", "application/json")
 
-	responseWriter.WriteHeader(204)
+	responseWriter.WriteHeader(200)
+	responseWriter.Write([]byte(resultMsg))
 }
 
 func (s *Server) handleHealthz(responseWriter http.ResponseWriter, req *http.Request, _ httprouter.Params) {
@@ -336,7 +724,7 @@ func (s *Server) handleHealthz(responseWriter http.ResponseWriter, req *http.Req
 }
 
 func (s *Server) HandleServerInfo(responseWriter http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	serverInfo := messages.ServerInfo{NumOfWorkers: len(s.workerService.workers), State: loadTestStateToString(s.loadTestState)}
+	serverInfo := messages.ServerInfo{NumOfWorkers: s.workerService.count(), State: loadTestStateToString(s.getLoadTestState())}
 	serverInfoMsg, _ := json.Marshal(serverInfo)
 
 	header := responseWriter.Header()
@@ -347,11 +735,7 @@ func (s *Server) HandleServerInfo(responseWriter http.ResponseWriter, req *http.
 }
 func (s *Server) HandleWorkersInfo(responseWriter http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	// Workers Info
-	var wks []*worker
-	for _, v := range s.workerService.workers {
-		wks = append(wks, v)
-	}
-	workersInfoMsg, _ := json.Marshal(wks)
+	workersInfoMsg, _ := s.workerService.marshalWorkers()
 
 	header := responseWriter.Header()
 	header.Set("Content-Type", "application/json")
@@ -359,3 +743,62 @@ func (s *Server) HandleWorkersInfo(responseWriter http.ResponseWriter, req *http
 	responseWriter.WriteHeader(200)
 	responseWriter.Write([]byte(workersInfoMsg))
 }
+
+// HandleRemoveWorker disconnects the workers with the name in the path. A running load test is rebalanced
+// across the remaining workers, as when a worker leaves.
+func (s *Server) HandleRemoveWorker(responseWriter http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	name := params.ByName("name")
+
+	if !s.workerService.DisconnectWorker(name) {
+		http.Error(responseWriter, "no worker named "+name, http.StatusNotFound)
+		return
+	}
+
+	logger.Infow("Disconnected worker", "name", name)
+
+	event := apiEvent(req)
+	event.Action = audit.ActionRemoveWorker
+	event.Workers = []string{name}
+	s.recordEvent(event)
+
+	responseWriter.WriteHeader(204)
+}
+
+// HandleLoadTestMetrics responds with the load test metrics aggregated from all workers.
+// Additional latency quantiles can be requested with a comma separated quantiles query parameter,
+// e.g. ?quantiles=0.999,0.9999.
+func (s *Server) HandleLoadTestMetrics(responseWriter http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	quantiles, err := parseQuantiles(req.URL.Query().Get("quantiles"))
+	if err != nil {
+		http.Error(responseWriter, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	loadTestMetricsMsg, _ := json.Marshal(s.workerService.AggregatedMetrics(quantiles...))
+
+	header := responseWriter.Header()
+	header.Set("Content-Type", "application/json")
+
+	responseWriter.WriteHeader(200)
+	responseWriter.Write([]byte(loadTestMetricsMsg))
+}
+
+func parseQuantiles(s string) ([]float64, error) {
+	var quantiles []float64
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		q, err := strconv.ParseFloat(part, 64)
+		if err != nil || q < 0 || q > 1 {
+			return nil, fmt.Errorf("invalid quantile %q: must be between 0 and 1", part)
+		}
+
+		quantiles = append(quantiles, q)
+	}
+
+	return quantiles, nil
+}
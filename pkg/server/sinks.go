@@ -0,0 +1,85 @@
+package server
+
+import (
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/sink"
+)
+
+// sinkQueueSize is how many batches of points wait for slow sinks before new batches are dropped.
+const sinkQueueSize = 64
+
+// sinkBatch is a batch of points of a run to be written to the sinks.
+type sinkBatch struct {
+	runID  string
+	points []sink.Point
+}
+
+// AddSink registers a sink the per-second metrics of load tests are pushed to.
+// Points are pushed every second, as workers report them. Results belong to the second their request started,
+// so a point is pushed again when results of its second complete later.
+func (s *Server) AddSink(sk sink.Sink) {
+	s.sinkLock.Lock()
+	defer s.sinkLock.Unlock()
+
+	s.sinks = append(s.sinks, sk)
+}
+
+func (s *Server) runSinkLoop() {
+	for {
+		s.pushMetrics()
+
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// runSinkWriter writes the queued batches to the sinks, in order, so slow sinks delay neither the sink loop
+// nor the start of load tests.
+func (s *Server) runSinkWriter() {
+	for batch := range s.sinkQueue {
+		s.sinkLock.Lock()
+		sinks := append([]sink.Sink(nil), s.sinks...)
+		s.sinkLock.Unlock()
+
+		for _, sk := range sinks {
+			if err := sk.Write(batch.runID, batch.points); err != nil {
+				logger.Warnw("Failed to push metrics to sink", "id", batch.runID, "error", err)
+			}
+		}
+	}
+}
+
+// pushMetrics queues the points of the time series updated since they were last pushed for the sinks.
+func (s *Server) pushMetrics() {
+	s.sinkLock.Lock()
+	numOfSinks := len(s.sinks)
+	s.sinkLock.Unlock()
+
+	if numOfSinks == 0 {
+		return
+	}
+
+	points := s.workerService.GetTimeSeries().TakeUnpushed()
+	if len(points) == 0 {
+		return
+	}
+
+	runID := s.currentRunID()
+
+	select {
+	case s.sinkQueue <- sinkBatch{runID: runID, points: points}:
+	default:
+		logger.Warnw("Dropped metrics, sinks are too slow", "id", runID, "points", len(points))
+	}
+}
+
+func (s *Server) closeSinks() {
+	s.sinkLock.Lock()
+	defer s.sinkLock.Unlock()
+
+	for _, sk := range s.sinks {
+		sk.Close()
+	}
+
+	s.sinks = nil
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+)
+
+// threshold is a parsed pass/fail criterion of a load test, e.g. "p99 < 300ms".
+//
+// Supported metrics are latency quantiles (p50, p99, p99.9, ...), mean, min and max latency compared
+// to durations, success compared to a percentage or a ratio, status codes (e.g. 503) and status
+// classes (e.g. 5xx) compared to a number of responses or a percentage of requests, and requests,
+// rate and throughput compared to numbers. "no <metric>" is a shorthand for "<metric> == 0".
+type threshold struct {
+	expr     string
+	metric   string
+	quantile float64
+	op       string
+	value    float64
+	percent  bool
+}
+
+var thresholdRegexp = regexp.MustCompile(`^\s*([A-Za-z0-9_.]+)\s*(<=|>=|==|!=|<|>)\s*(\S+)\s*$`)
+var statusRegexp = regexp.MustCompile(`^[1-5]([0-9]{2}|xx)$`)
+
+// parseThresholds parses the thresholds of a load test request.
+func parseThresholds(exprs []string) ([]threshold, error) {
+	thresholds := make([]threshold, 0, len(exprs))
+
+	for _, expr := range exprs {
+		t, err := parseThreshold(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		thresholds = append(thresholds, t)
+	}
+
+	return thresholds, nil
+}
+
+func parseThreshold(expr string) (threshold, error) {
+	t := threshold{expr: expr}
+
+	var value string
+	if fields := strings.Fields(expr); len(fields) == 2 && fields[0] == "no" {
+		t.metric, t.op, value = strings.ToLower(fields[1]), "==", "0"
+	} else if match := thresholdRegexp.FindStringSubmatch(expr); match != nil {
+		t.metric, t.op, value = strings.ToLower(match[1]), match[2], match[3]
+	} else {
+		return t, fmt.Errorf("invalid threshold %q: expected \"<metric> <operator> <value>\" or \"no <metric>\"", expr)
+	}
+
+	var err error
+
+	switch {
+	case t.metric == "mean" || t.metric == "min" || t.metric == "max" || strings.HasPrefix(t.metric, "p"):
+		if strings.HasPrefix(t.metric, "p") {
+			t.quantile, err = strconv.ParseFloat(t.metric[1:], 64)
+			if err != nil || t.quantile <= 0 || t.quantile > 100 {
+				return t, fmt.Errorf("invalid threshold %q: unknown metric %q", expr, t.metric)
+			}
+			t.quantile /= 100
+			t.metric = "p"
+		}
+
+		var d time.Duration
+		if d, err = time.ParseDuration(value); err != nil {
+			return t, fmt.Errorf("invalid threshold %q: latency must be compared to a duration", expr)
+		}
+		t.value = float64(d)
+	case t.metric == "success":
+		if t.value, t.percent, err = parseThresholdValue(value); err != nil {
+			return t, fmt.Errorf("invalid threshold %q: %w", expr, err)
+		}
+
+		// "success > 99.5" would never pass, success is a ratio.
+		if t.value < 0 || t.value > 1 {
+			return t, fmt.Errorf("invalid threshold %q: success must be compared to a ratio between 0 and 1 or a percentage, e.g. 99.5%%", expr)
+		}
+	case statusRegexp.MatchString(t.metric):
+		if t.value, t.percent, err = parseThresholdValue(value); err != nil {
+			return t, fmt.Errorf("invalid threshold %q: %w", expr, err)
+		}
+	case t.metric == "requests" || t.metric == "rate" || t.metric == "throughput":
+		if t.value, err = strconv.ParseFloat(value, 64); err != nil {
+			return t, fmt.Errorf("invalid threshold %q: %s must be compared to a number", expr, t.metric)
+		}
+	default:
+		return t, fmt.Errorf("invalid threshold %q: unknown metric %q", expr, t.metric)
+	}
+
+	return t, nil
+}
+
+// parseThresholdValue parses a number, or a percentage ("99.5%") returned as a ratio.
+func parseThresholdValue(s string) (float64, bool, error) {
+	if strings.HasSuffix(s, "%") {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid percentage %q", s)
+		}
+
+		return v / 100, true, nil
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid number %q", s)
+	}
+
+	return v, false, nil
+}
+
+// onTotal tells whether the threshold is on a total of the run, which only makes sense once the run ended.
+// Such thresholds, e.g. "requests > 10000", fail during the first seconds of every run.
+func (t *threshold) onTotal() bool {
+	return t.metric == "requests" || t.metric == "rate" || t.metric == "throughput"
+}
+
+// evaluate compares the metric of the threshold to its value. It returns whether the threshold
+// passed and the measured value.
+func (t *threshold) evaluate(m *messages.LoadTestMetrics) (bool, string) {
+	var actual float64
+	var formatted string
+
+	switch {
+	case t.metric == "p" || t.metric == "mean" || t.metric == "min" || t.metric == "max":
+		d := t.latency(m)
+		actual, formatted = float64(d), d.String()
+	case t.metric == "success":
+		actual, formatted = m.Success, formatPercent(m.Success)
+	case t.metric == "requests":
+		actual, formatted = float64(m.Requests), strconv.FormatUint(m.Requests, 10)
+	case t.metric == "rate":
+		actual, formatted = m.Rate, strconv.FormatFloat(m.Rate, 'f', 2, 64)
+	case t.metric == "throughput":
+		actual, formatted = m.Throughput, strconv.FormatFloat(m.Throughput, 'f', 2, 64)
+	default:
+		count := 0
+		for code, n := range m.StatusCodes {
+			if code == t.metric || (strings.HasSuffix(t.metric, "xx") && len(code) == 3 && code[0] == t.metric[0]) {
+				count += n
+			}
+		}
+
+		actual, formatted = float64(count), strconv.Itoa(count)
+		if t.percent {
+			actual = 0
+			if m.Requests > 0 {
+				actual = float64(count) / float64(m.Requests)
+			}
+			formatted = formatPercent(actual)
+		}
+	}
+
+	return compare(actual, t.op, t.value), formatted
+}
+
+func (t *threshold) latency(m *messages.LoadTestMetrics) time.Duration {
+	switch t.metric {
+	case "mean":
+		return m.Latencies.Mean
+	case "min":
+		return m.Latencies.Min
+	case "max":
+		return m.Latencies.Max
+	}
+
+	if m.LatencySketch != nil {
+		return m.LatencySketch.Quantile(t.quantile)
+	}
+
+	switch t.quantile {
+	case 0.5:
+		return m.Latencies.P50
+	case 0.9:
+		return m.Latencies.P90
+	case 0.95:
+		return m.Latencies.P95
+	}
+
+	return m.Latencies.P99
+}
+
+func compare(actual float64, op string, value float64) bool {
+	switch op {
+	case "<":
+		return actual < value
+	case "<=":
+		return actual <= value
+	case ">":
+		return actual > value
+	case ">=":
+		return actual >= value
+	case "==":
+		return actual == value
+	case "!=":
+		return actual != value
+	}
+
+	return false
+}
+
+func formatPercent(ratio float64) string {
+	return strconv.FormatFloat(ratio*100, 'f', -1, 64) + "%"
+}
+
+// evaluateThresholds computes the verdict of a set of thresholds on the given metrics.
+// Until the run is final, thresholds on totals are pending and do not fail the verdict.
+// It returns nil when there are no thresholds.
+func evaluateThresholds(thresholds []threshold, m *messages.LoadTestMetrics, final bool) *messages.Verdict {
+	if len(thresholds) == 0 {
+		return nil
+	}
+
+	verdict := &messages.Verdict{
+		Passed:     true,
+		Thresholds: make([]messages.ThresholdResult, 0, len(thresholds)),
+	}
+
+	for i := range thresholds {
+		passed, actual := thresholds[i].evaluate(m)
+		pending := !final && thresholds[i].onTotal()
+
+		verdict.Thresholds = append(verdict.Thresholds, messages.ThresholdResult{
+			Threshold: thresholds[i].expr,
+			Passed:    passed,
+			Actual:    actual,
+			Pending:   pending,
+		})
+
+		if !passed && !pending {
+			verdict.Passed = false
+		}
+	}
+
+	return verdict
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    threshold
+		wantErr string
+	}{
+		{expr: "p99 < 300ms", want: threshold{metric: "p", quantile: 0.99, op: "<", value: float64(300 * time.Millisecond)}},
+		{expr: "p99.9<=1s", want: threshold{metric: "p", quantile: 0.999, op: "<=", value: float64(time.Second)}},
+		{expr: "mean < 50ms", want: threshold{metric: "mean", op: "<", value: float64(50 * time.Millisecond)}},
+		{expr: "success > 99.5%", want: threshold{metric: "success", op: ">", value: 0.995, percent: true}},
+		{expr: "success >= 0.995", want: threshold{metric: "success", op: ">=", value: 0.995}},
+		{expr: "success > 99.5", wantErr: "success must be compared to a ratio"},
+		{expr: "success > 150%", wantErr: "success must be compared to a ratio"},
+		{expr: "no 5xx", want: threshold{metric: "5xx", op: "==", value: 0}},
+		{expr: "503 < 1%", want: threshold{metric: "503", op: "<", value: 0.01, percent: true}},
+		{expr: "requests > 10000", want: threshold{metric: "requests", op: ">", value: 10000}},
+		{expr: "p99 <", wantErr: "expected"},
+		{expr: "p99 ~ 1s", wantErr: "expected"},
+		{expr: "p0 < 1s", wantErr: "unknown metric"},
+		{expr: "p101 < 1s", wantErr: "unknown metric"},
+		{expr: "latency < 1s", wantErr: "unknown metric"},
+		{expr: "p99 < 300", wantErr: "must be compared to a duration"},
+		{expr: "success > high", wantErr: "invalid number"},
+		{expr: "5xx < x%", wantErr: "invalid percentage"},
+		{expr: "rate > fast", wantErr: "must be compared to a number"},
+		{expr: "6xx < 1", wantErr: "unknown metric"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := parseThreshold(tt.expr)
+
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			tt.want.expr = tt.expr
+			assert.InDelta(t, tt.want.value, got.value, 1e-9)
+			assert.InDelta(t, tt.want.quantile, got.quantile, 1e-9)
+			got.value, got.quantile, tt.want.value, tt.want.quantile = 0, 0, 0, 0
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
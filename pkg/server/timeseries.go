@@ -0,0 +1,184 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/sink"
+	"github.com/influxdata/tdigest"
+)
+
+// TimeSeries merges the per-second metrics reported by workers into
+// a single cluster-wide time series.
+type TimeSeries struct {
+	buckets map[int64]*timeSeriesBucket
+	updated map[int64]struct{}
+	// unpushed holds what was added to each point since TakeUnpushed last returned it.
+	unpushed map[int64]*timeSeriesBucket
+	lock     sync.Mutex
+}
+
+type timeSeriesBucket struct {
+	interval messages.MetricsInterval
+	digest   *tdigest.TDigest
+}
+
+// NewTimeSeries creates an empty time series.
+func NewTimeSeries() *TimeSeries {
+	return &TimeSeries{
+		buckets:  make(map[int64]*timeSeriesBucket),
+		updated:  make(map[int64]struct{}),
+		unpushed: make(map[int64]*timeSeriesBucket),
+	}
+}
+
+// Add merges intervals into the time series.
+func (t *TimeSeries) Add(intervals []messages.MetricsInterval) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, in := range intervals {
+		key := in.Timestamp.Unix()
+
+		b, ok := t.buckets[key]
+		if !ok {
+			b = newTimeSeriesBucket(key)
+			t.buckets[key] = b
+		}
+
+		u, ok := t.unpushed[key]
+		if !ok {
+			u = newTimeSeriesBucket(key)
+			t.unpushed[key] = u
+		}
+
+		b.add(in)
+		u.add(in)
+		t.updated[key] = struct{}{}
+	}
+}
+
+// Points returns all points of the time series in chronological order.
+func (t *TimeSeries) Points() []messages.MetricsInterval {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	points := make([]messages.MetricsInterval, 0, len(t.buckets))
+	for _, b := range t.buckets {
+		points = append(points, b.point())
+	}
+
+	sortIntervals(points)
+
+	return points
+}
+
+// TakeUpdated returns the points updated since the previous call, in chronological order.
+func (t *TimeSeries) TakeUpdated() []messages.MetricsInterval {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	points := make([]messages.MetricsInterval, 0, len(t.updated))
+	for key := range t.updated {
+		points = append(points, t.buckets[key].point())
+	}
+
+	t.updated = make(map[int64]struct{})
+	sortIntervals(points)
+
+	return points
+}
+
+// TakeUnpushed returns the points updated since the previous call, with what was added to them since,
+// in chronological order.
+func (t *TimeSeries) TakeUnpushed() []sink.Point {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	points := make([]sink.Point, 0, len(t.unpushed))
+	for key, u := range t.unpushed {
+		points = append(points, sink.Point{Total: t.buckets[key].point(), Increment: u.point()})
+	}
+
+	t.unpushed = make(map[int64]*timeSeriesBucket)
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Total.Timestamp.Before(points[j].Total.Timestamp)
+	})
+
+	return points
+}
+
+// Reset removes all points.
+func (t *TimeSeries) Reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.buckets = make(map[int64]*timeSeriesBucket)
+	t.updated = make(map[int64]struct{})
+	t.unpushed = make(map[int64]*timeSeriesBucket)
+}
+
+func newTimeSeriesBucket(key int64) *timeSeriesBucket {
+	return &timeSeriesBucket{
+		interval: messages.MetricsInterval{
+			Timestamp:   time.Unix(key, 0).UTC(),
+			StatusCodes: make(map[string]int),
+		},
+		digest: tdigest.NewWithCompression(messages.LatencyCompression),
+	}
+}
+
+func (b *timeSeriesBucket) add(in messages.MetricsInterval) {
+	b.interval.Requests += in.Requests
+	b.interval.Successes += in.Successes
+	b.interval.BytesIn += in.BytesIn
+	b.interval.BytesOut += in.BytesOut
+	b.interval.Latencies.Total += in.Latencies.Total
+
+	if in.Latencies.Max > b.interval.Latencies.Max {
+		b.interval.Latencies.Max = in.Latencies.Max
+	}
+
+	if in.Latencies.Min > 0 && (b.interval.Latencies.Min == 0 || in.Latencies.Min < b.interval.Latencies.Min) {
+		b.interval.Latencies.Min = in.Latencies.Min
+	}
+
+	for code, count := range in.StatusCodes {
+		b.interval.StatusCodes[code] += count
+	}
+
+	if in.LatencySketch != nil {
+		in.LatencySketch.AddTo(b.digest)
+	}
+}
+
+// point returns the merged interval with its latency quantiles computed. The sketch is not included.
+func (b *timeSeriesBucket) point() messages.MetricsInterval {
+	p := b.interval
+
+	p.StatusCodes = make(map[string]int, len(b.interval.StatusCodes))
+	for code, count := range b.interval.StatusCodes {
+		p.StatusCodes[code] = count
+	}
+
+	if p.Requests > 0 {
+		p.Latencies.Mean = time.Duration(float64(p.Latencies.Total) / float64(p.Requests))
+	}
+
+	if b.digest.Count() > 0 {
+		p.Latencies.P50 = time.Duration(b.digest.Quantile(0.50))
+		p.Latencies.P90 = time.Duration(b.digest.Quantile(0.90))
+		p.Latencies.P95 = time.Duration(b.digest.Quantile(0.95))
+		p.Latencies.P99 = time.Duration(b.digest.Quantile(0.99))
+	}
+
+	return p
+}
+
+func sortIntervals(intervals []messages.MetricsInterval) {
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].Timestamp.Before(intervals[j].Timestamp)
+	})
+}
@@ -2,32 +2,113 @@ package server
 
 import (
 	"encoding/json"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/andylibrian/terjang/pkg/messages"
-	"github.com/gorilla/websocket"
+	"github.com/andylibrian/terjang/pkg/transport"
 )
 
+// worker is a registered worker. Its fields are guarded by the workers lock of the worker service, except for
+// lastSeen, accessed atomically, and the acks, guarded by their own lock.
 type worker struct {
-	Name     string `json:"name"`
-	conn     *websocket.Conn
-	Metrics  messages.WorkerLoadTestMetrics `json:"metrics"`
-	state    messages.WorkerState
-	StateStr string `json:"state"`
+	// lastSeen is the time the last message was received from the worker, in Unix nanoseconds.
+	// It is first in the struct to be 64-bit aligned for atomic access.
+	lastSeen int64
+	// seq numbers the workers in the order they joined, telling apart workers with the same name.
+	seq       uint64
+	Name      string `json:"name"`
+	Weight    int    `json:"weight"`
+	conn      transport.Conn
+	writeLock sync.Mutex
+	Metrics   messages.WorkerLoadTestMetrics `json:"metrics"`
+	state     messages.WorkerState
+	StateStr  string `json:"state"`
+	// runID is the ID of the last load test run the worker was asked to take part in.
+	runID string
+	// runWorkerID identifies the worker within the run, see messages.StartLoadTestRequest.
+	// Its raw results are kept under it, as workers may have the same name.
+	runWorkerID string
+	// removed is set when the worker is disconnected on purpose, so it is not expected to reconnect.
+	removed bool
+	// evicted is set when the worker's connection was closed for missing its heartbeats.
+	evicted bool
+	// envelopeEncoding is the envelope encoding negotiated with the worker, EnvelopeEncodingJSONString by default.
+	envelopeEncoding string
+	// Status is WorkerStatusConnected, or WorkerStatusUnresponsive when the worker missed its heartbeats.
+	Status string `json:"status"`
+	// ProtocolVersion, Version and Features are reported by the worker's hello. They are zero for workers
+	// older than the handshake.
+	ProtocolVersion int                `json:"protocol_version"`
+	Version         string             `json:"version,omitempty"`
+	Features        *messages.Features `json:"features,omitempty"`
+	// acks holds the commands awaiting the worker's acknowledgement, by message ID.
+	acks     map[string]chan *messages.Ack
+	acksLock sync.Mutex
+}
+
+// resultsKey returns the ID the worker's raw results are kept under: its ID within the run, or its name
+// until it has one.
+func (wk *worker) resultsKey() string {
+	if wk.runWorkerID != "" {
+		return wk.runWorkerID
+	}
+
+	return wk.Name
+}
+
+// WorkerStatusConnected indicates that a worker is connected and responsive.
+const WorkerStatusConnected = "Connected"
+
+// WorkerStatusUnresponsive indicates that a worker has not answered heartbeats within the heartbeat timeout.
+// It is evicted if it stays unresponsive for the eviction grace period.
+const WorkerStatusUnresponsive = "Unresponsive"
+
+func (wk *worker) send(message []byte) error {
+	wk.writeLock.Lock()
+	defer wk.writeLock.Unlock()
+
+	return wk.conn.WriteMessage(message)
+}
+
+// sendPayload sends a message with the worker's envelope encoding. The data may be nil.
+func (wk *worker) sendPayload(kind string, payload interface{}) error {
+	message, err := messages.Encode(wk.envelopeEncoding, kind, payload)
+	if err != nil {
+		return err
+	}
+
+	return wk.send(message)
 }
 
 // WorkerService maintains a collection of workers and
 // provide a function to broadcast messages to them.
 type WorkerService struct {
+	// lastMessageID is the ID of the last command sent to a worker.
+	// It is first in the struct to be 64-bit aligned for atomic access.
+	lastMessageID  uint64
 	messageHandler MessageHandler
-	workers        map[*websocket.Conn]*worker
+	workers        map[transport.Conn]*worker
 	workersLock    sync.RWMutex
+	// lastWorkerSeq is the sequence number of the last worker that joined, guarded by workersLock.
+	lastWorkerSeq  uint64
 	stateUpdatedCh chan struct{}
+	timeSeries     *TimeSeries
+	// resultsHandler receives the raw results batches reported by workers, along with their ID within the run.
+	resultsHandler func(workerID string, results *messages.WorkerResults)
+	// resumeHandler receives the worker info announcing a run, e.g. when a worker reconnects during a load test.
+	resumeHandler func(conn transport.Conn, info *messages.WorkerInfo)
+	// reserved are the workers that lost their connection during a run. They may still be running their share
+	// of it, which is kept for them until they resume the run or their share is released.
+	reserved map[*worker]struct{}
 }
 
 // MessageHandler is the interface to handle message from a worker.
 type MessageHandler interface {
-	HandleMessage(conn *websocket.Conn, message []byte)
+	HandleMessage(conn transport.Conn, message []byte)
 }
 
 type defaultMessageHandler struct {
@@ -37,8 +118,10 @@ type defaultMessageHandler struct {
 // NewWorkerService creates a new worker service.
 func NewWorkerService() *WorkerService {
 	w := &WorkerService{
-		workers:        make(map[*websocket.Conn]*worker),
+		workers:        make(map[transport.Conn]*worker),
+		reserved:       make(map[*worker]struct{}),
 		stateUpdatedCh: make(chan struct{}),
+		timeSeries:     NewTimeSeries(),
 	}
 
 	w.messageHandler = &defaultMessageHandler{workerService: w}
@@ -51,25 +134,121 @@ func (w *WorkerService) GetMessageHandler() MessageHandler {
 	return w.messageHandler
 }
 
+// GetTimeSeries returns the time series merged from the per-second metrics reported by workers.
+func (w *WorkerService) GetTimeSeries() *TimeSeries {
+	return w.timeSeries
+}
+
 // SetMessageHandler registers a message handler to be used by WorkerService.
 func (w *WorkerService) SetMessageHandler(h MessageHandler) {
 	w.messageHandler = h
 }
 
-// AddWorker registers a worker.
-func (w *WorkerService) AddWorker(conn *websocket.Conn, name string) {
+// AddWorker registers a worker. The weight determines the worker's share of the total rate in RateModeTotal.
+func (w *WorkerService) AddWorker(conn transport.Conn, name string, weight int) {
+	w.workersLock.Lock()
+	defer w.workersLock.Unlock()
+
+	w.lastWorkerSeq++
+	w.workers[conn] = &worker{
+		seq:              w.lastWorkerSeq,
+		conn:             conn,
+		Name:             name,
+		Weight:           weight,
+		lastSeen:         time.Now().UnixNano(),
+		Status:           WorkerStatusConnected,
+		envelopeEncoding: messages.EnvelopeEncodingJSONString,
+	}
+}
+
+// touch records that a message was received from the worker connected through conn.
+func (w *WorkerService) touch(conn transport.Conn) {
+	w.workersLock.RLock()
+	defer w.workersLock.RUnlock()
+
+	if wk, ok := w.workers[conn]; ok {
+		atomic.StoreInt64(&wk.lastSeen, time.Now().UnixNano())
+	}
+}
+
+// checkHeartbeats marks the workers silent for longer than timeout as unresponsive, and closes the connections
+// of those silent for longer than timeout plus evictAfter. It returns the names of the workers that became
+// unresponsive, responsive again and evicted.
+func (w *WorkerService) checkHeartbeats(timeout time.Duration, evictAfter time.Duration) (unresponsive []string, responsive []string, evicted []string) {
 	w.workersLock.Lock()
 	defer w.workersLock.Unlock()
 
-	w.workers[conn] = &worker{conn: conn, Name: name}
+	now := time.Now()
+	for conn, wk := range w.workers {
+		silence := now.Sub(time.Unix(0, atomic.LoadInt64(&wk.lastSeen)))
+
+		switch {
+		case wk.evicted:
+		case silence > timeout+evictAfter:
+			wk.evicted = true
+			conn.Close()
+			evicted = append(evicted, wk.Name)
+		case silence > timeout && wk.Status != WorkerStatusUnresponsive:
+			wk.Status = WorkerStatusUnresponsive
+			unresponsive = append(unresponsive, wk.Name)
+		case silence <= timeout && wk.Status == WorkerStatusUnresponsive:
+			wk.Status = WorkerStatusConnected
+			responsive = append(responsive, wk.Name)
+		}
+	}
+
+	return unresponsive, responsive, evicted
+}
+
+// RemoveWorker removes a worker from the collection. It returns true when the worker was disconnected
+// on purpose with DisconnectWorker, rather than having lost its connection.
+func (w *WorkerService) RemoveWorker(conn transport.Conn) bool {
+	removed, _ := w.removeWorker(conn, false)
+
+	return removed
 }
 
-// RemoveWorker removes a worker from the collection.
-func (w *WorkerService) RemoveWorker(conn *websocket.Conn) {
+// removeWorker removes a worker from the collection. When reserve is set and the worker lost its connection
+// during a run, its share of the run is reserved, and returned to be released later.
+func (w *WorkerService) removeWorker(conn transport.Conn, reserve bool) (bool, *worker) {
 	w.workersLock.Lock()
 	defer w.workersLock.Unlock()
 
+	wk, ok := w.workers[conn]
+	if !ok {
+		return false, nil
+	}
+
 	delete(w.workers, conn)
+
+	if !reserve || wk.removed || wk.runID == "" ||
+		wk.state == messages.WorkerStateDone || wk.state == messages.WorkerStateStopped {
+		return wk.removed, nil
+	}
+
+	w.reserved[wk] = struct{}{}
+
+	return false, wk
+}
+
+// releaseShare releases the share reserved for a worker that lost its connection. It returns false when the worker
+// already resumed the run.
+func (w *WorkerService) releaseShare(wk *worker) bool {
+	w.workersLock.Lock()
+	defer w.workersLock.Unlock()
+
+	_, ok := w.reserved[wk]
+	delete(w.reserved, wk)
+
+	return ok
+}
+
+// count returns the number of registered workers.
+func (w *WorkerService) count() int {
+	w.workersLock.RLock()
+	defer w.workersLock.RUnlock()
+
+	return len(w.workers)
 }
 
 // BroadcastMessageToWorkers sends a message to the registered workers.
@@ -77,33 +256,443 @@ func (w *WorkerService) BroadcastMessageToWorkers(message []byte) {
 	w.workersLock.RLock()
 	defer w.workersLock.RUnlock()
 
-	for conn := range w.workers {
-		// TODO: conn should be synced
-		conn.WriteMessage(websocket.TextMessage, message)
+	for _, wk := range w.workers {
+		if err := wk.send(message); err != nil {
+			logger.Errorw("Failed to send message to worker", "name", wk.Name, "error", err)
+		}
+	}
+}
+
+// broadcast sends a message to the registered workers, each with its envelope encoding. The data may be nil.
+func (w *WorkerService) broadcast(kind string, payload interface{}) {
+	w.workersLock.RLock()
+	defer w.workersLock.RUnlock()
+
+	encoded := make(map[string][]byte)
+	for _, wk := range w.workers {
+		message, ok := encoded[wk.envelopeEncoding]
+		if !ok {
+			var err error
+			if message, err = messages.Encode(wk.envelopeEncoding, kind, payload); err != nil {
+				logger.Errorw("Failed to encode message", "kind", kind, "error", err)
+				return
+			}
+
+			encoded[wk.envelopeEncoding] = message
+		}
+
+		if err := wk.send(message); err != nil {
+			logger.Errorw("Failed to send message to worker", "name", wk.Name, "kind", kind, "error", err)
+		}
+	}
+}
+
+// setHello records the versions and features reported by the worker connected through conn.
+func (w *WorkerService) setHello(conn transport.Conn, hello *messages.Hello) {
+	w.workersLock.Lock()
+	defer w.workersLock.Unlock()
+
+	if wk, ok := w.workers[conn]; ok {
+		features := hello.Features
+		wk.ProtocolVersion = hello.ProtocolVersion
+		wk.Version = hello.Version
+		wk.Features = &features
+	}
+}
+
+// setEnvelopeEncoding sets the envelope encoding of the messages sent to the worker connected through conn.
+func (w *WorkerService) setEnvelopeEncoding(conn transport.Conn, encoding string) {
+	w.workersLock.Lock()
+	defer w.workersLock.Unlock()
+
+	if wk, ok := w.workers[conn]; ok {
+		wk.envelopeEncoding = encoding
+	}
+}
+
+// sendToWorker sends a message to the worker connected through conn, with its envelope encoding.
+func (w *WorkerService) sendToWorker(conn transport.Conn, kind string, payload interface{}) {
+	w.workersLock.RLock()
+	defer w.workersLock.RUnlock()
+
+	if wk, ok := w.workers[conn]; ok {
+		if err := wk.sendPayload(kind, payload); err != nil {
+			logger.Errorw("Failed to send message to worker", "name", wk.Name, "kind", kind, "error", err)
+		}
+	}
+}
+
+// attachRun marks the worker connected through conn as taking part in a load test run. It returns the worker's name,
+// and false when the worker is unknown or takes part in the run already.
+func (w *WorkerService) attachRun(conn transport.Conn, runID string) (string, bool) {
+	w.workersLock.Lock()
+	defer w.workersLock.Unlock()
+
+	wk, ok := w.workers[conn]
+	if !ok || wk.runID == runID {
+		return "", false
+	}
+
+	wk.runID = runID
+
+	// The worker takes its reserved share back. Older workers do not know their ID, they are matched by name.
+	for reserved := range w.reserved {
+		if reserved.runID != runID {
+			continue
+		}
+
+		if reserved.runWorkerID == wk.runWorkerID || (wk.runWorkerID == "" && reserved.Name == wk.Name) {
+			wk.runWorkerID = reserved.runWorkerID
+			delete(w.reserved, reserved)
+			break
+		}
+	}
+
+	if wk.runWorkerID == "" {
+		wk.runWorkerID = w.newRunWorkerID(runID, wk.Name)
+	}
+
+	return wk.Name, true
+}
+
+// AssignRun marks the registered workers supporting the features of a load test request as taking part
+// in its run. It returns the names of the workers left out.
+func (w *WorkerService) AssignRun(runID string, req *messages.StartLoadTestRequest) []string {
+	w.workersLock.Lock()
+	defer w.workersLock.Unlock()
+
+	var unsupported []string
+	var participants []*worker
+	for _, wk := range w.workers {
+		wk.runWorkerID = ""
+
+		if !wk.supports(req) {
+			unsupported = append(unsupported, wk.Name)
+			continue
+		}
+
+		wk.runID = runID
+		wk.state = messages.WorkerStateNotStarted
+		participants = append(participants, wk)
+	}
+
+	// Workers with the same name are told apart by the order they joined, as in the Prometheus metrics.
+	sort.Slice(participants, func(i, j int) bool { return participants[i].seq < participants[j].seq })
+	for _, wk := range participants {
+		wk.runWorkerID = w.newRunWorkerID(runID, wk.Name)
+	}
+
+	sort.Strings(unsupported)
+
+	return unsupported
+}
+
+// newRunWorkerID returns an ID for a worker with a name in a run, not used by the workers of the run: the name,
+// or the name followed by "#2", "#3", ... when it is used. It must be called with workersLock held.
+func (w *WorkerService) newRunWorkerID(runID string, name string) string {
+	used := make(map[string]bool)
+	for _, wk := range w.workers {
+		if wk.runID == runID {
+			used[wk.runWorkerID] = true
+		}
+	}
+
+	for wk := range w.reserved {
+		if wk.runID == runID {
+			used[wk.runWorkerID] = true
+		}
+	}
+
+	id := name
+	for n := 2; used[id]; n++ {
+		id = name + "#" + strconv.Itoa(n)
+	}
+
+	return id
+}
+
+// sendToRun sends a command to the workers taking part in a run, each with its envelope encoding, built from
+// the worker's ID within the run. It returns the commands, whose acknowledgements may be awaited.
+func (w *WorkerService) sendToRun(runID string, kind string, build func(workerID string) interface{}) []*pendingAck {
+	w.workersLock.RLock()
+	defer w.workersLock.RUnlock()
+
+	var pending []*pendingAck
+	for _, wk := range w.workers {
+		if wk.runID == runID {
+			pending = append(pending, wk.command(w.newMessageID(), kind, build(wk.runWorkerID)))
+		}
+	}
+
+	return pending
+}
+
+// sendToAll sends a command to the registered workers, each with its envelope encoding.
+// It returns the commands, whose acknowledgements may be awaited.
+func (w *WorkerService) sendToAll(kind string, payload interface{}) []*pendingAck {
+	w.workersLock.RLock()
+	defer w.workersLock.RUnlock()
+
+	pending := make([]*pendingAck, 0, len(w.workers))
+	for _, wk := range w.workers {
+		pending = append(pending, wk.command(w.newMessageID(), kind, payload))
+	}
+
+	return pending
+}
+
+// sendShares sends a command to each worker taking part in a run, built from the worker's ID within the run
+// and share of the total rate. Workers that finished the run already are left out.
+// It returns the commands, whose acknowledgements may be awaited.
+func (w *WorkerService) sendShares(runID string, req *messages.StartLoadTestRequest, kind string, build func(workerID string, share float64) interface{}) []*pendingAck {
+	w.workersLock.RLock()
+	defer w.workersLock.RUnlock()
+
+	var participants []*worker
+	var weights []int
+
+	for _, wk := range w.workers {
+		if wk.runID != runID || wk.state == messages.WorkerStateDone || wk.state == messages.WorkerStateStopped {
+			continue
+		}
+
+		participants = append(participants, wk)
+		weights = append(weights, wk.Weight)
+	}
+
+	// Workers that lost their connection keep their share, as they may still be running it.
+	for wk := range w.reserved {
+		if wk.runID == runID {
+			weights = append(weights, wk.Weight)
+		}
+	}
+
+	shares := computeShares(req, weights)
+
+	pending := make([]*pendingAck, 0, len(participants))
+	for i, wk := range participants {
+		pending = append(pending, wk.command(w.newMessageID(), kind, build(wk.runWorkerID, shares[i])))
+	}
+
+	return pending
+}
+
+// WorkersMetrics returns the latest load test metrics reported by each registered worker.
+func (w *WorkerService) WorkersMetrics() []messages.WorkerLoadTestMetrics {
+	w.workersLock.RLock()
+	defer w.workersLock.RUnlock()
+
+	metrics := make([]messages.WorkerLoadTestMetrics, 0, len(w.workers))
+	for _, wk := range w.workers {
+		metrics = append(metrics, wk.Metrics)
 	}
+
+	return metrics
 }
 
-// HandleMessage handle messages from a worker.
-func (h *defaultMessageHandler) HandleMessage(conn *websocket.Conn, message []byte) {
-	var envelope messages.Envelope
-	err := json.Unmarshal(message, &envelope)
+// WorkersResults returns the latest load test metrics reported by each registered worker, along with its name
+// and ID within the run.
+func (w *WorkerService) WorkersResults() []messages.WorkerLoadTestResult {
+	w.workersLock.RLock()
+	defer w.workersLock.RUnlock()
 
+	results := make([]messages.WorkerLoadTestResult, 0, len(w.workers))
+	for _, wk := range w.workers {
+		results = append(results, messages.WorkerLoadTestResult{Name: wk.Name, ID: wk.resultsKey(), Metrics: wk.Metrics})
+	}
+
+	return results
+}
+
+// ResetMetrics clears the metrics and the time series reported by the registered workers.
+func (w *WorkerService) ResetMetrics() {
+	w.workersLock.Lock()
+	defer w.workersLock.Unlock()
+
+	for _, wk := range w.workers {
+		wk.Metrics = messages.WorkerLoadTestMetrics{}
+	}
+
+	w.timeSeries.Reset()
+}
+
+// AggregatedMetrics returns the load test metrics of all registered workers merged into a single result.
+func (w *WorkerService) AggregatedMetrics(quantiles ...float64) messages.LoadTestMetrics {
+	return AggregateMetrics(w.WorkersMetrics(), quantiles...)
+}
+
+// HandleMessage handle messages from a worker. Their data is decoded into the type registered for their kind.
+func (h *defaultMessageHandler) HandleMessage(conn transport.Conn, message []byte) {
+	_, payload, err := messages.Decode(message)
 	if err != nil {
 		return
 	}
 
-	if envelope.Kind == messages.KindWorkerInfo {
-		var workerInfo messages.WorkerInfo
-		json.Unmarshal([]byte(envelope.Data), &workerInfo)
+	switch payload := payload.(type) {
+	case *messages.WorkerInfo:
+		// A worker resuming a run announces its ID within the run. Info about an earlier run, sent before the worker
+		// received its current one, is ignored.
+		if payload.WorkerID != "" {
+			h.workerService.workersLock.Lock()
+			if w, ok := h.workerService.workers[conn]; ok && (w.runID == "" || w.runID == payload.RunID) {
+				w.runWorkerID = payload.WorkerID
+			}
+			h.workerService.workersLock.Unlock()
+		}
+
+		if payload.RunID != "" && h.workerService.resumeHandler != nil {
+			h.workerService.resumeHandler(conn, payload)
+		}
 
-		w := h.workerService.workers[conn]
+		// The state of an earlier run, e.g. a stopped one reported after the worker was assigned the current one,
+		// does not count.
+		h.workerService.workersLock.Lock()
+		changed := false
+		if w, ok := h.workerService.workers[conn]; ok && w.state != payload.State &&
+			(payload.RunID == "" || w.runID == "" || payload.RunID == w.runID) {
+			w.state = payload.State
+			changed = true
+		}
+		h.workerService.workersLock.Unlock()
 
-		if w.state != workerInfo.State {
-			w.state = workerInfo.State
+		if changed {
 			h.workerService.stateUpdatedCh <- struct{}{}
 		}
-	} else if envelope.Kind == messages.KindWorkerLoadTestMetrics {
-		w := h.workerService.workers[conn]
-		json.Unmarshal([]byte(envelope.Data), &w.Metrics)
+	case *messages.WorkerLoadTestMetrics:
+		// Intervals are only reported once, they are kept in the time series rather than with the latest metrics.
+		h.workerService.timeSeries.Add(payload.Intervals)
+		payload.Intervals = nil
+
+		h.workerService.workersLock.Lock()
+		if w, ok := h.workerService.workers[conn]; ok {
+			w.Metrics = *payload
+		}
+		h.workerService.workersLock.Unlock()
+	case *messages.WorkerResults:
+		h.workerService.workersLock.RLock()
+		w, ok := h.workerService.workers[conn]
+		workerID := ""
+		if ok {
+			workerID = w.resultsKey()
+		}
+		h.workerService.workersLock.RUnlock()
+
+		if ok && h.workerService.resultsHandler != nil {
+			h.workerService.resultsHandler(workerID, payload)
+		}
+	}
+}
+
+// marshalWorkers encodes the registered workers as JSON.
+func (w *WorkerService) marshalWorkers() ([]byte, error) {
+	w.workersLock.RLock()
+	defer w.workersLock.RUnlock()
+
+	var wks []*worker
+	for _, wk := range w.workers {
+		wks = append(wks, wk)
+	}
+
+	return json.Marshal(wks)
+}
+
+// runStates counts the states of the workers taking part in a run. It returns the counts by state
+// and the number of workers.
+func (w *WorkerService) runStates(runID string) (map[messages.WorkerState]int, int) {
+	w.workersLock.RLock()
+	defer w.workersLock.RUnlock()
+
+	states := make(map[messages.WorkerState]int)
+	participants := 0
+
+	for _, wk := range w.workers {
+		if wk.runID != runID {
+			continue
+		}
+
+		participants++
+		states[wk.state]++
+	}
+
+	return states, participants
+}
+
+// workerStatus is a snapshot of a registered worker.
+type workerStatus struct {
+	seq     uint64
+	name    string
+	state   messages.WorkerState
+	metrics messages.WorkerLoadTestMetrics
+}
+
+// workersStatus returns a snapshot of the registered workers, sorted by name, then by the order they joined.
+func (w *WorkerService) workersStatus() []workerStatus {
+	w.workersLock.RLock()
+	defer w.workersLock.RUnlock()
+
+	statuses := make([]workerStatus, 0, len(w.workers))
+	for _, wk := range w.workers {
+		statuses = append(statuses, workerStatus{seq: wk.seq, name: wk.Name, state: wk.state, metrics: wk.Metrics})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].name != statuses[j].name {
+			return statuses[i].name < statuses[j].name
+		}
+
+		return statuses[i].seq < statuses[j].seq
+	})
+
+	return statuses
+}
+
+func workerStateToString(s messages.WorkerState) string {
+	switch s {
+	case messages.WorkerStateNotStarted:
+		return "NotStarted"
+	case messages.WorkerStateRunning:
+		return "Running"
+	case messages.WorkerStateDone:
+		return "Done"
+	case messages.WorkerStateStopped:
+		return "Stopped"
 	}
+
+	return ""
+}
+
+// DisconnectWorker asks the workers with a name to leave without reconnecting, and closes their connections.
+// It returns false when there is none. Disconnected workers are removed once their connection handler returns.
+func (w *WorkerService) DisconnectWorker(name string) bool {
+	w.workersLock.Lock()
+	defer w.workersLock.Unlock()
+
+	found := false
+	for conn, wk := range w.workers {
+		if wk.Name == name {
+			wk.removed = true
+			wk.sendPayload(messages.KindRemoveWorkerRequest, nil)
+			conn.Close()
+			found = true
+		}
+	}
+
+	return found
+}
+
+// runWorkers returns the names of the workers taking part in a run, sorted.
+func (w *WorkerService) runWorkers(runID string) []string {
+	w.workersLock.RLock()
+	defer w.workersLock.RUnlock()
+
+	var names []string
+	for _, wk := range w.workers {
+		if runID != "" && wk.runID == runID {
+			names = append(names, wk.Name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
 }
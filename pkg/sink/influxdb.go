@@ -0,0 +1,165 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxDB pushes metrics in the InfluxDB line protocol, over HTTP or UDP.
+// Each point is written to the terjang measurement, and its status codes to terjang_status_codes,
+// tagged with the run ID. Latencies are written in nanoseconds. Points are written with their totals,
+// so a point pushed again replaces the previous one.
+type InfluxDB struct {
+	writeURL   string
+	token      string
+	httpClient *http.Client
+	conn       net.Conn
+}
+
+// NewInfluxDB creates an InfluxDB sink.
+//
+// With the influxdb and influxdbs schemes, lines are POSTed to the URL's path and query, e.g.
+// influxdb://host:8086/write?db=terjang for InfluxDB 1.x or
+// influxdb://host:8086/api/v2/write?org=my-org&bucket=terjang&token=my-token for InfluxDB 2.x.
+// The token query parameter is sent as an Authorization header, user info as basic auth.
+// With the influxdb+udp scheme, lines are sent to the UDP listener at the URL's host.
+func NewInfluxDB(u *url.URL) (*InfluxDB, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("InfluxDB sink requires a host")
+	}
+
+	if u.Scheme == "influxdb+udp" {
+		conn, err := net.Dial("udp", u.Host)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to connect to InfluxDB: %w", err)
+		}
+
+		return &InfluxDB{conn: conn}, nil
+	}
+
+	writeURL := *u
+	writeURL.Scheme = "http"
+	if u.Scheme == "influxdbs" {
+		writeURL.Scheme = "https"
+	}
+
+	query := writeURL.Query()
+	token := query.Get("token")
+	query.Del("token")
+	writeURL.RawQuery = query.Encode()
+
+	if writeURL.Path == "" {
+		writeURL.Path = "/write"
+	}
+
+	return &InfluxDB{
+		writeURL:   writeURL.String(),
+		token:      token,
+		httpClient: &http.Client{Timeout: httpTimeout},
+	}, nil
+}
+
+// Write pushes points as lines of the line protocol.
+func (s *InfluxDB) Write(runID string, points []Point) error {
+	lines := influxLines(runID, points)
+	if len(lines) == 0 {
+		return nil
+	}
+
+	if s.conn != nil {
+		if err := writePackets(s.conn, lines); err != nil {
+			return fmt.Errorf("Failed to write to InfluxDB: %w", err)
+		}
+
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.writeURL, strings.NewReader(strings.Join(lines, "\n")+"\n"))
+	if err != nil {
+		return fmt.Errorf("Failed to write to InfluxDB: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to write to InfluxDB: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Failed to write to InfluxDB: server responded with %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	return nil
+}
+
+// Close closes the UDP connection, if any.
+func (s *InfluxDB) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+
+	return s.conn.Close()
+}
+
+func influxLines(runID string, points []Point) []string {
+	tags := ",run_id=" + escapeInfluxTag(runID)
+
+	var lines []string
+	for _, point := range points {
+		p := point.Total
+		timestamp := " " + strconv.FormatInt(p.Timestamp.UnixNano(), 10)
+
+		fields := []string{
+			"requests=" + strconv.FormatUint(p.Requests, 10) + "i",
+			"successes=" + strconv.FormatUint(p.Successes, 10) + "i",
+			"bytes_in=" + strconv.FormatUint(p.BytesIn, 10) + "i",
+			"bytes_out=" + strconv.FormatUint(p.BytesOut, 10) + "i",
+		}
+
+		if p.Requests > 0 {
+			fields = append(fields,
+				"success="+strconv.FormatFloat(float64(p.Successes)/float64(p.Requests), 'f', -1, 64),
+				influxDuration("latency_mean", p.Latencies.Mean),
+				influxDuration("latency_min", p.Latencies.Min),
+				influxDuration("latency_p50", p.Latencies.P50),
+				influxDuration("latency_p90", p.Latencies.P90),
+				influxDuration("latency_p95", p.Latencies.P95),
+				influxDuration("latency_p99", p.Latencies.P99),
+				influxDuration("latency_max", p.Latencies.Max),
+			)
+		}
+
+		lines = append(lines, "terjang"+tags+" "+strings.Join(fields, ",")+timestamp)
+
+		for _, code := range sortedCodes(p.StatusCodes) {
+			line := "terjang_status_codes" + tags + ",code=" + escapeInfluxTag(code) +
+				" count=" + strconv.Itoa(p.StatusCodes[code]) + "i" + timestamp
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+func influxDuration(field string, d time.Duration) string {
+	return field + "=" + strconv.FormatInt(int64(d), 10) + "i"
+}
+
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+func escapeInfluxTag(v string) string {
+	return influxTagEscaper.Replace(v)
+}
@@ -0,0 +1,230 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// otlpAggregationTemporalityDelta marks sums of the values of a single point rather than cumulative sums.
+const otlpAggregationTemporalityDelta = 1
+
+// OTLP pushes metrics to an OpenTelemetry collector with OTLP/HTTP, JSON encoded.
+// Counts are sent as delta sums of the increments of points, and latencies, in milliseconds,
+// as a summary of their totals.
+// Data points have a run_id attribute.
+type OTLP struct {
+	metricsURL string
+	httpClient *http.Client
+}
+
+// NewOTLP creates an OTLP sink, e.g. otlp://localhost:4318 or otlps://collector.example.com.
+// Metrics are POSTed to /v1/metrics unless the URL has a path.
+func NewOTLP(u *url.URL) (*OTLP, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("OTLP sink requires a host")
+	}
+
+	metricsURL := *u
+	metricsURL.Scheme = "http"
+	if u.Scheme == "otlps" {
+		metricsURL.Scheme = "https"
+	}
+
+	if metricsURL.Path == "" {
+		metricsURL.Path = "/v1/metrics"
+	}
+
+	return &OTLP{metricsURL: metricsURL.String(), httpClient: &http.Client{Timeout: httpTimeout}}, nil
+}
+
+// Write pushes points as an OTLP metrics export request.
+func (s *OTLP) Write(runID string, points []Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(otlpRequest(runID, points))
+	if err != nil {
+		return fmt.Errorf("Failed to write to OTLP: %w", err)
+	}
+
+	resp, err := s.httpClient.Post(s.metricsURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Failed to write to OTLP: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Failed to write to OTLP: server responded with %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	return nil
+}
+
+// Close does nothing, requests are not kept open between writes.
+func (s *OTLP) Close() error {
+	return nil
+}
+
+// The types below follow the JSON encoding of the OTLP metrics protobuf messages.
+// 64 bits integers are encoded as strings.
+
+type otlpExportRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name    string       `json:"name"`
+	Unit    string       `json:"unit"`
+	Sum     *otlpSum     `json:"sum,omitempty"`
+	Summary *otlpSummary `json:"summary,omitempty"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                   `json:"aggregationTemporality"`
+	IsMonotonic            bool                  `json:"isMonotonic"`
+}
+
+type otlpNumberDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsInt             string          `json:"asInt"`
+}
+
+type otlpSummary struct {
+	DataPoints []otlpSummaryDataPoint `json:"dataPoints"`
+}
+
+type otlpSummaryDataPoint struct {
+	Attributes        []otlpAttribute     `json:"attributes"`
+	StartTimeUnixNano string              `json:"startTimeUnixNano"`
+	TimeUnixNano      string              `json:"timeUnixNano"`
+	Count             string              `json:"count"`
+	Sum               float64             `json:"sum"`
+	QuantileValues    []otlpQuantileValue `json:"quantileValues"`
+}
+
+type otlpQuantileValue struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+type otlpAttribute struct {
+	Key   string             `json:"key"`
+	Value otlpAttributeValue `json:"value"`
+}
+
+type otlpAttributeValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func otlpRequest(runID string, points []Point) *otlpExportRequest {
+	requests := otlpCounter("terjang.requests", "{request}")
+	successes := otlpCounter("terjang.successes", "{request}")
+	bytesIn := otlpCounter("terjang.bytes_in", "By")
+	bytesOut := otlpCounter("terjang.bytes_out", "By")
+	responses := otlpCounter("terjang.responses", "{response}")
+	latency := otlpMetric{Name: "terjang.latency", Unit: "ms", Summary: &otlpSummary{}}
+
+	attributes := []otlpAttribute{otlpString("run_id", runID)}
+
+	for _, point := range points {
+		p, total := point.Increment, point.Total
+		start := strconv.FormatInt(total.Timestamp.UnixNano(), 10)
+		end := strconv.FormatInt(total.Timestamp.Add(time.Second).UnixNano(), 10)
+
+		count := func(m *otlpMetric, value uint64, attrs ...otlpAttribute) {
+			m.Sum.DataPoints = append(m.Sum.DataPoints, otlpNumberDataPoint{
+				Attributes:        append(append([]otlpAttribute{}, attributes...), attrs...),
+				StartTimeUnixNano: start,
+				TimeUnixNano:      end,
+				AsInt:             strconv.FormatUint(value, 10),
+			})
+		}
+
+		count(&requests, p.Requests)
+		count(&successes, p.Successes)
+		count(&bytesIn, p.BytesIn)
+		count(&bytesOut, p.BytesOut)
+
+		for _, code := range sortedCodes(p.StatusCodes) {
+			count(&responses, uint64(p.StatusCodes[code]), otlpString("code", code))
+		}
+
+		if total.Requests > 0 {
+			latency.Summary.DataPoints = append(latency.Summary.DataPoints, otlpSummaryDataPoint{
+				Attributes:        attributes,
+				StartTimeUnixNano: start,
+				TimeUnixNano:      end,
+				Count:             strconv.FormatUint(total.Requests, 10),
+				Sum:               otlpMillis(total.Latencies.Total),
+				QuantileValues: []otlpQuantileValue{
+					{Quantile: 0, Value: otlpMillis(total.Latencies.Min)},
+					{Quantile: 0.5, Value: otlpMillis(total.Latencies.P50)},
+					{Quantile: 0.9, Value: otlpMillis(total.Latencies.P90)},
+					{Quantile: 0.95, Value: otlpMillis(total.Latencies.P95)},
+					{Quantile: 0.99, Value: otlpMillis(total.Latencies.P99)},
+					{Quantile: 1, Value: otlpMillis(total.Latencies.Max)},
+				},
+			})
+		}
+	}
+
+	metrics := []otlpMetric{requests, successes, bytesIn, bytesOut}
+	if len(responses.Sum.DataPoints) > 0 {
+		metrics = append(metrics, responses)
+	}
+	if len(latency.Summary.DataPoints) > 0 {
+		metrics = append(metrics, latency)
+	}
+
+	return &otlpExportRequest{
+		ResourceMetrics: []otlpResourceMetrics{{
+			Resource:     otlpResource{Attributes: []otlpAttribute{otlpString("service.name", "terjang")}},
+			ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScope{Name: "terjang"}, Metrics: metrics}},
+		}},
+	}
+}
+
+func otlpCounter(name string, unit string) otlpMetric {
+	return otlpMetric{
+		Name: name,
+		Unit: unit,
+		Sum:  &otlpSum{AggregationTemporality: otlpAggregationTemporalityDelta, IsMonotonic: true},
+	}
+}
+
+func otlpString(key string, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpAttributeValue{StringValue: value}}
+}
+
+func otlpMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
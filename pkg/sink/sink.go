@@ -0,0 +1,109 @@
+// Package sink pushes the per-second metrics of load tests to external time-series backends.
+package sink
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+)
+
+// httpTimeout bounds the requests sent to HTTP backends, so a slow backend does not pile up pushes.
+const httpTimeout = 5 * time.Second
+
+// maxPacketSize is the maximum size of a UDP packet sent to a backend. It fits in a typical
+// Ethernet MTU so packets are not fragmented.
+const maxPacketSize = 1432
+
+// Point is a point of the time series of a run. A point is pushed as soon as workers report its second, and pushed
+// again when they report results of that second that completed later.
+type Point struct {
+	// Total holds the metrics of the second reported so far.
+	Total messages.MetricsInterval
+	// Increment holds the metrics reported since the point was last pushed. It equals Total the first time.
+	Increment messages.MetricsInterval
+}
+
+// Sink receives the per-second metrics of load tests.
+type Sink interface {
+	// Write pushes points of the time series of a run, in chronological order.
+	Write(runID string, points []Point) error
+	// Close releases the resources of the sink.
+	Close() error
+}
+
+// New creates a sink from a URL whose scheme selects the backend:
+//
+//	influxdb://host:8086/write?db=terjang     InfluxDB line protocol over HTTP (influxdbs:// for HTTPS)
+//	influxdb+udp://host:8089                  InfluxDB line protocol over UDP
+//	statsd://host:8125                        StatsD over UDP
+//	dogstatsd://host:8125                     DogStatsD over UDP, with tags
+//	otlp://host:4318                          OpenTelemetry OTLP/HTTP metrics (otlps:// for HTTPS)
+//
+// See the constructor of each backend for the supported options.
+func New(rawURL string) (Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse sink URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "influxdb", "influxdbs", "influxdb+udp":
+		return NewInfluxDB(u)
+	case "statsd":
+		return NewStatsD(u, false)
+	case "dogstatsd":
+		return NewStatsD(u, true)
+	case "otlp", "otlps":
+		return NewOTLP(u)
+	}
+
+	return nil, fmt.Errorf("unknown sink %q: must be one of influxdb, influxdbs, influxdb+udp, statsd, dogstatsd, otlp, otlps", u.Scheme)
+}
+
+// writePackets sends lines over a UDP connection, packing as many lines as fit in a packet.
+func writePackets(conn net.Conn, lines []string) error {
+	var packet strings.Builder
+
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+
+		_, err := conn.Write([]byte(packet.String()))
+		packet.Reset()
+
+		return err
+	}
+
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxPacketSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+
+	return flush()
+}
+
+// sortedCodes returns the status codes of a histogram in ascending order.
+func sortedCodes(statusCodes map[string]int) []string {
+	codes := make([]string, 0, len(statusCodes))
+	for code := range statusCodes {
+		codes = append(codes, code)
+	}
+
+	sort.Strings(codes)
+
+	return codes
+}
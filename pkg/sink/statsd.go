@@ -0,0 +1,103 @@
+package sink
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// defaultStatsDPrefix is prepended to the names of the metrics sent to StatsD.
+const defaultStatsDPrefix = "terjang."
+
+// StatsD pushes metrics to a StatsD or DogStatsD daemon over UDP. StatsD adds up counters, so only
+// the increments of points are sent: counts as counters and latencies, in milliseconds, as gauges.
+// DogStatsD metrics are tagged with the run ID and the status code, plain StatsD metrics have
+// the status code in their name.
+type StatsD struct {
+	conn   net.Conn
+	prefix string
+	dog    bool
+}
+
+// NewStatsD creates a StatsD sink sending to the URL's host, e.g. statsd://localhost:8125.
+// The prefix query parameter overrides the metric name prefix, terjang. by default.
+// dog enables DogStatsD tags.
+func NewStatsD(u *url.URL, dog bool) (*StatsD, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("StatsD sink requires a host")
+	}
+
+	prefix := defaultStatsDPrefix
+	if query := u.Query(); query["prefix"] != nil {
+		prefix = query.Get("prefix")
+	}
+
+	conn, err := net.Dial("udp", u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to StatsD: %w", err)
+	}
+
+	return &StatsD{conn: conn, prefix: prefix, dog: dog}, nil
+}
+
+// Write pushes points as StatsD metrics.
+func (s *StatsD) Write(runID string, points []Point) error {
+	var lines []string
+
+	metric := func(name string, value string, typ string, tags ...string) {
+		line := s.prefix + name + ":" + value + "|" + typ
+
+		if s.dog {
+			line += "|#run_id:" + runID
+			for _, tag := range tags {
+				line += "," + tag
+			}
+		}
+
+		lines = append(lines, line)
+	}
+
+	for _, point := range points {
+		p := point.Increment
+		metric("requests", strconv.FormatUint(p.Requests, 10), "c")
+		metric("successes", strconv.FormatUint(p.Successes, 10), "c")
+		metric("bytes_in", strconv.FormatUint(p.BytesIn, 10), "c")
+		metric("bytes_out", strconv.FormatUint(p.BytesOut, 10), "c")
+
+		if p.Requests > 0 {
+			metric("latency.mean", statsDMillis(p.Latencies.Mean), "g")
+			metric("latency.p50", statsDMillis(p.Latencies.P50), "g")
+			metric("latency.p90", statsDMillis(p.Latencies.P90), "g")
+			metric("latency.p95", statsDMillis(p.Latencies.P95), "g")
+			metric("latency.p99", statsDMillis(p.Latencies.P99), "g")
+			metric("latency.max", statsDMillis(p.Latencies.Max), "g")
+		}
+
+		for _, code := range sortedCodes(p.StatusCodes) {
+			count := strconv.Itoa(p.StatusCodes[code])
+
+			if s.dog {
+				metric("responses", count, "c", "code:"+code)
+			} else {
+				metric("responses."+code, count, "c")
+			}
+		}
+	}
+
+	if err := writePackets(s.conn, lines); err != nil {
+		return fmt.Errorf("Failed to write to StatsD: %w", err)
+	}
+
+	return nil
+}
+
+// Close closes the UDP connection.
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
+
+func statsDMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+}
@@ -0,0 +1,224 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+)
+
+const runFileExt = ".json"
+
+// resultsDirExt is the extension of the directory holding the raw results of a run, one file per worker.
+const resultsDirExt = ".results"
+
+// resultsFilePrefix prefixes the escaped worker names of raw results files,
+// so that no worker name maps to a special file name such as "..".
+const resultsFilePrefix = "worker-"
+
+// FileStore is a Store that keeps each load test run as a JSON file in a directory.
+type FileStore struct {
+	dir  string
+	lock sync.RWMutex
+}
+
+// NewFileStore creates a file based store in the given directory.
+// The directory is created if it does not exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create store directory: %w", err)
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// SaveRun creates or replaces a load test run.
+func (f *FileStore) SaveRun(run *messages.LoadTestRun) error {
+	path, err := f.runPath(run.ID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	// Write to a temporary file first so a crash never leaves a partially written run behind.
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// GetRun returns the load test run with the given ID.
+func (f *FileStore) GetRun(id string) (*messages.LoadTestRun, error) {
+	path, err := f.runPath(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return readRunFile(path)
+}
+
+// ListRuns returns all load test runs, most recent first. Unreadable run files are skipped.
+func (f *FileStore) ListRuns() ([]*messages.LoadTestRun, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	entries, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]*messages.LoadTestRun, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != runFileExt {
+			continue
+		}
+
+		// A corrupt file, e.g. truncated by a crash, must not hide the rest of the history.
+		run, err := readRunFile(filepath.Join(f.dir, entry.Name()))
+		if err != nil {
+			logger.Warnw("Skipping unreadable load test run", "file", entry.Name(), "error", err)
+			continue
+		}
+
+		runs = append(runs, run)
+	}
+
+	sortRuns(runs)
+
+	return runs, nil
+}
+
+func (f *FileStore) runPath(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", fmt.Errorf("invalid load test run id %q", id)
+	}
+
+	return filepath.Join(f.dir, id+runFileExt), nil
+}
+
+// AppendResults appends a chunk to the raw results stream of a worker in a run.
+func (f *FileStore) AppendResults(id string, worker string, data []byte) error {
+	dir, err := f.resultsDir(id)
+	if err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, resultsFilePrefix+url.PathEscape(worker)), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// ResultsWorkers returns the names of the workers that have raw results in a run, sorted.
+func (f *FileStore) ResultsWorkers(id string) ([]string, error) {
+	dir, err := f.resultsDir(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	workers := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), resultsFilePrefix) {
+			continue
+		}
+
+		worker, err := url.PathUnescape(strings.TrimPrefix(entry.Name(), resultsFilePrefix))
+		if err != nil {
+			continue
+		}
+
+		workers = append(workers, worker)
+	}
+
+	sort.Strings(workers)
+
+	return workers, nil
+}
+
+// OpenResults returns the raw results stream of a worker in a run.
+func (f *FileStore) OpenResults(id string, worker string) (io.ReadCloser, error) {
+	dir, err := f.resultsDir(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	file, err := os.Open(filepath.Join(dir, resultsFilePrefix+url.PathEscape(worker)))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	return file, err
+}
+
+func (f *FileStore) resultsDir(id string) (string, error) {
+	path, err := f.runPath(id)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(path, runFileExt) + resultsDirExt, nil
+}
+
+func readRunFile(path string) (*messages.LoadTestRun, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var run messages.LoadTestRun
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("Failed to decode load test run %s: %w", path, err)
+	}
+
+	return &run, nil
+}
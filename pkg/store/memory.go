@@ -0,0 +1,124 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+)
+
+// MemoryStore is a Store that keeps load test runs in memory.
+// History is lost when the server stops.
+type MemoryStore struct {
+	runs     map[string][]byte
+	results  map[string]map[string][]byte
+	runsLock sync.RWMutex
+}
+
+// NewMemoryStore creates a new in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		runs:    make(map[string][]byte),
+		results: make(map[string]map[string][]byte),
+	}
+}
+
+// SaveRun creates or replaces a load test run.
+func (m *MemoryStore) SaveRun(run *messages.LoadTestRun) error {
+	// Runs are stored encoded so later modifications by the caller do not leak into the store.
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	m.runsLock.Lock()
+	defer m.runsLock.Unlock()
+
+	m.runs[run.ID] = data
+
+	return nil
+}
+
+// GetRun returns the load test run with the given ID.
+func (m *MemoryStore) GetRun(id string) (*messages.LoadTestRun, error) {
+	m.runsLock.RLock()
+	data, ok := m.runs[id]
+	m.runsLock.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	var run messages.LoadTestRun
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+// ListRuns returns all load test runs, most recent first.
+func (m *MemoryStore) ListRuns() ([]*messages.LoadTestRun, error) {
+	m.runsLock.RLock()
+	defer m.runsLock.RUnlock()
+
+	runs := make([]*messages.LoadTestRun, 0, len(m.runs))
+	for _, data := range m.runs {
+		var run messages.LoadTestRun
+		if err := json.Unmarshal(data, &run); err != nil {
+			return nil, err
+		}
+
+		runs = append(runs, &run)
+	}
+
+	sortRuns(runs)
+
+	return runs, nil
+}
+
+// AppendResults appends a chunk to the raw results stream of a worker in a run.
+func (m *MemoryStore) AppendResults(id string, worker string, data []byte) error {
+	m.runsLock.Lock()
+	defer m.runsLock.Unlock()
+
+	if _, ok := m.results[id]; !ok {
+		m.results[id] = make(map[string][]byte)
+	}
+
+	m.results[id][worker] = append(m.results[id][worker], data...)
+
+	return nil
+}
+
+// ResultsWorkers returns the names of the workers that have raw results in a run, sorted.
+func (m *MemoryStore) ResultsWorkers(id string) ([]string, error) {
+	m.runsLock.RLock()
+	defer m.runsLock.RUnlock()
+
+	workers := make([]string, 0, len(m.results[id]))
+	for worker := range m.results[id] {
+		workers = append(workers, worker)
+	}
+
+	sort.Strings(workers)
+
+	return workers, nil
+}
+
+// OpenResults returns the raw results stream of a worker in a run.
+func (m *MemoryStore) OpenResults(id string, worker string) (io.ReadCloser, error) {
+	m.runsLock.RLock()
+	defer m.runsLock.RUnlock()
+
+	data, ok := m.results[id][worker]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
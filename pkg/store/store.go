@@ -0,0 +1,53 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"go.uber.org/zap"
+)
+
+var logger *zap.SugaredLogger
+
+func init() {
+	l, err := zap.NewProduction()
+
+	if err != nil {
+		panic("Can not create logger")
+	}
+
+	logger = l.Sugar()
+}
+
+// SetLogger registers a logger to be used by terjang stores.
+func SetLogger(l *zap.SugaredLogger) {
+	logger = l
+}
+
+// ErrNotFound is returned when a load test run does not exist in the store.
+var ErrNotFound = errors.New("load test run not found")
+
+// Store persists load test runs so they can be browsed after the run
+// has finished or the server has restarted.
+type Store interface {
+	// SaveRun creates or replaces a load test run.
+	SaveRun(run *messages.LoadTestRun) error
+	// GetRun returns the load test run with the given ID, or ErrNotFound.
+	GetRun(id string) (*messages.LoadTestRun, error)
+	// ListRuns returns all load test runs, most recent first.
+	ListRuns() ([]*messages.LoadTestRun, error)
+	// AppendResults appends a chunk to the raw results stream of a worker in a run.
+	AppendResults(id string, worker string, data []byte) error
+	// ResultsWorkers returns the names of the workers that have raw results in a run, sorted.
+	ResultsWorkers(id string) ([]string, error)
+	// OpenResults returns the raw results stream of a worker in a run, or ErrNotFound.
+	OpenResults(id string, worker string) (io.ReadCloser, error)
+}
+
+func sortRuns(runs []*messages.LoadTestRun) {
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+}
@@ -0,0 +1,111 @@
+package transport
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrClosed is returned when reading from or writing to a closed connection.
+var ErrClosed = errors.New("connection closed")
+
+// pipeBufferSize is the number of messages an in-memory connection buffers in each direction.
+const pipeBufferSize = 256
+
+// writeTimeout bounds a websocket write, so a peer that stopped reading can not block the writer forever.
+const writeTimeout = 10 * time.Second
+
+// Conn is a message oriented connection between the server and a worker.
+// Reads and writes may happen concurrently, but callers serialize their writes.
+type Conn interface {
+	ReadMessage() ([]byte, error)
+	WriteMessage(message []byte) error
+	Close() error
+}
+
+type websocketConn struct {
+	conn *websocket.Conn
+}
+
+// NewWebsocketConn creates a connection sending text messages over a websocket.
+func NewWebsocketConn(conn *websocket.Conn) Conn {
+	return &websocketConn{conn: conn}
+}
+
+func (c *websocketConn) ReadMessage() ([]byte, error) {
+	_, message, err := c.conn.ReadMessage()
+	return message, err
+}
+
+func (c *websocketConn) WriteMessage(message []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.conn.WriteMessage(websocket.TextMessage, message)
+}
+
+func (c *websocketConn) Close() error {
+	return c.conn.Close()
+}
+
+type pipeConn struct {
+	in        <-chan []byte
+	out       chan<- []byte
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+// Pipe creates two in-memory connections, messages written to one are read from the other.
+// Closing either connection closes both.
+func Pipe() (Conn, Conn) {
+	a := make(chan []byte, pipeBufferSize)
+	b := make(chan []byte, pipeBufferSize)
+	closed := make(chan struct{})
+	closeOnce := &sync.Once{}
+
+	return &pipeConn{in: a, out: b, closed: closed, closeOnce: closeOnce},
+		&pipeConn{in: b, out: a, closed: closed, closeOnce: closeOnce}
+}
+
+func (c *pipeConn) ReadMessage() ([]byte, error) {
+	// Messages written before the connection was closed are still delivered.
+	select {
+	case message := <-c.in:
+		return message, nil
+	default:
+	}
+
+	select {
+	case message := <-c.in:
+		return message, nil
+	case <-c.closed:
+		return nil, ErrClosed
+	}
+}
+
+func (c *pipeConn) WriteMessage(message []byte) error {
+	// The caller may reuse its buffer once the write returns.
+	m := make([]byte, len(message))
+	copy(m, message)
+
+	select {
+	case <-c.closed:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case c.out <- m:
+		return nil
+	case <-c.closed:
+		return ErrClosed
+	}
+}
+
+func (c *pipeConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"sync/atomic"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+)
+
+// features returns the load test features the worker supports.
+func features() messages.Features {
+	return messages.Features{
+		Pacers:    []string{messages.PacerConstant, messages.PacerLinear, messages.PacerSine, messages.PacerStages},
+		Encodings: []string{messages.ResultsEncodingGob, messages.ResultsEncodingCSV, messages.ResultsEncodingJSON},
+		Targets:   true,
+		Templates: true,
+	}
+}
+
+// sendHelloToServer sends the worker's protocol version, build version, features and envelope encodings,
+// first thing once connected. The hello is in the original envelope encoding, which every server reads.
+func (w *Worker) sendHelloToServer() {
+	w.sendToServer(messages.KindHello, &messages.Hello{
+		ProtocolVersion:   messages.ProtocolVersion,
+		Version:           w.version,
+		Features:          features(),
+		EnvelopeEncodings: messages.EnvelopeEncodings(),
+	})
+}
+
+// handleConnMessage handles the welcome and the pings of the server. It returns false for other messages.
+func (w *Worker) handleConnMessage(message []byte) bool {
+	switch messages.KindOf(message) {
+	case messages.KindPing:
+		w.sendToServer(messages.KindPong, nil)
+	case messages.KindWelcome:
+		_, payload, err := messages.Decode(message)
+		welcome, ok := payload.(*messages.Welcome)
+		if err != nil || !ok {
+			logger.Errorw("Failed to unmarshal a message from server", "message", string(message))
+			return true
+		}
+
+		w.handleWelcome(welcome)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// handleWelcome closes the worker when the server rejected it or speaks a protocol version it does not support.
+// Otherwise, the worker switches to the envelope encoding the server chose, if any, and to its heartbeat.
+func (w *Worker) handleWelcome(welcome *messages.Welcome) {
+	if welcome.Error != "" {
+		logger.Errorw("Server rejected the worker", "error", welcome.Error, "server_version", welcome.Version)
+		w.Close()
+		return
+	}
+
+	if welcome.ProtocolVersion < messages.MinProtocolVersion {
+		logger.Errorw("Server protocol version is not supported", "protocol_version", welcome.ProtocolVersion,
+			"server_version", welcome.Version)
+		w.Close()
+		return
+	}
+
+	// Servers older than the envelope encoding negotiation choose none.
+	if welcome.EnvelopeEncoding != "" {
+		w.setEnvelopeEncoding(messages.NegotiateEnvelopeEncoding([]string{welcome.EnvelopeEncoding}))
+	}
+
+	w.adjustHeartbeatTimeout(welcome.Heartbeat)
+
+	logger.Infow("Joined server", "server_version", welcome.Version, "protocol_version", welcome.ProtocolVersion,
+		"envelope_encoding", welcome.EnvelopeEncoding)
+}
+
+// adjustHeartbeatTimeout fits the heartbeat timeout of the current connection to the server's heartbeat, so a server
+// that pings seldom or never is not mistaken for an unresponsive one. Servers older than it send none.
+func (w *Worker) adjustHeartbeatTimeout(heartbeat *messages.Heartbeat) {
+	if heartbeat == nil || w.heartbeatTimeout <= 0 {
+		return
+	}
+
+	if heartbeat.Interval <= 0 {
+		atomic.StoreInt64(&w.serverTimeout, 0)
+		return
+	}
+
+	// A responsive server is silent for up to an interval between pings.
+	timeout := w.heartbeatTimeout
+	if heartbeat.Timeout > timeout {
+		timeout = heartbeat.Timeout
+	}
+	if 2*heartbeat.Interval > timeout {
+		timeout = 2 * heartbeat.Interval
+	}
+
+	atomic.StoreInt64(&w.serverTimeout, int64(timeout))
+}
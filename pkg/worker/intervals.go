@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/influxdata/tdigest"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// intervalRecorder groups results into one second intervals by the time their request was sent.
+// It is not safe for concurrent use.
+type intervalRecorder struct {
+	buckets map[int64]*intervalBucket
+}
+
+type intervalBucket struct {
+	interval messages.MetricsInterval
+	digest   *tdigest.TDigest
+}
+
+func newIntervalRecorder() *intervalRecorder {
+	return &intervalRecorder{buckets: make(map[int64]*intervalBucket)}
+}
+
+// Add records a result in the interval it was sent in.
+func (r *intervalRecorder) Add(res *vegeta.Result) {
+	key := res.Timestamp.Unix()
+
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &intervalBucket{
+			interval: messages.MetricsInterval{
+				Timestamp:   time.Unix(key, 0).UTC(),
+				StatusCodes: make(map[string]int),
+			},
+			digest: tdigest.NewWithCompression(messages.LatencyCompression),
+		}
+		r.buckets[key] = b
+	}
+
+	in := &b.interval
+	in.Requests++
+	in.BytesIn += res.BytesIn
+	in.BytesOut += res.BytesOut
+	in.StatusCodes[strconv.Itoa(int(res.Code))]++
+	in.Latencies.Total += res.Latency
+
+	if res.Code >= 200 && res.Code < 400 {
+		in.Successes++
+	}
+
+	if res.Latency > in.Latencies.Max {
+		in.Latencies.Max = res.Latency
+	}
+
+	if in.Latencies.Min == 0 || res.Latency < in.Latencies.Min {
+		in.Latencies.Min = res.Latency
+	}
+
+	b.digest.Add(float64(res.Latency), 1)
+}
+
+// Flush removes and returns the intervals that started before the given time.
+// Results that complete after their interval was flushed are reported again in
+// a new interval with the same timestamp, which the server merges.
+func (r *intervalRecorder) Flush(before time.Time) []messages.MetricsInterval {
+	var intervals []messages.MetricsInterval
+
+	for key, b := range r.buckets {
+		if !b.interval.Timestamp.Before(before) {
+			continue
+		}
+
+		in := b.interval
+		in.Latencies.Mean = time.Duration(float64(in.Latencies.Total) / float64(in.Requests))
+		in.Latencies.P50 = time.Duration(b.digest.Quantile(0.50))
+		in.Latencies.P90 = time.Duration(b.digest.Quantile(0.90))
+		in.Latencies.P95 = time.Duration(b.digest.Quantile(0.95))
+		in.Latencies.P99 = time.Duration(b.digest.Quantile(0.99))
+		in.LatencySketch = messages.NewLatencySketch(b.digest)
+
+		intervals = append(intervals, in)
+		delete(r.buckets, key)
+	}
+
+	return intervals
+}
@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+func TestIntervalsAreKeptUntilSent(t *testing.T) {
+	w := NewWorker()
+	w.resetLoadTest()
+
+	sent := time.Now().Add(-2 * time.Second)
+	w.intervals.Add(&vegeta.Result{Code: 200, Timestamp: sent, Latency: time.Millisecond})
+
+	// Disconnected, the interval cannot be sent.
+	w.SendMetricsToServer()
+
+	conn, server := transport.Pipe()
+	defer conn.Close()
+
+	w.connWriteLock.Lock()
+	w.conn = conn
+	w.envelopeEncoding = messages.EnvelopeEncodingJSON
+	w.connWriteLock.Unlock()
+
+	w.intervals.Add(&vegeta.Result{Code: 200, Timestamp: sent.Add(time.Second), Latency: time.Millisecond})
+	w.SendMetricsToServer()
+
+	message, err := server.ReadMessage()
+	require.NoError(t, err)
+
+	_, payload, err := messages.Decode(message)
+	require.NoError(t, err)
+
+	metrics := payload.(*messages.WorkerLoadTestMetrics)
+	require.Len(t, metrics.Intervals, 2)
+	for _, in := range metrics.Intervals {
+		assert.Equal(t, uint64(1), in.Requests)
+	}
+
+	// Sent intervals are not sent again.
+	w.SendMetricsToServer()
+
+	message, err = server.ReadMessage()
+	require.NoError(t, err)
+
+	_, payload, err = messages.Decode(message)
+	require.NoError(t, err)
+	assert.Empty(t, payload.(*messages.WorkerLoadTestMetrics).Intervals)
+}
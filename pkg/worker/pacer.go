@@ -0,0 +1,300 @@
+package worker
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// newPacer creates the pacer described by a load test request, along with the duration of the attack.
+// In RateModeTotal, the load profile is scaled down to the worker's share.
+func newPacer(req *messages.StartLoadTestRequest) (*rebalancingPacer, time.Duration, error) {
+	share := 1.0
+	if req.RateMode == messages.RateModeTotal {
+		share = req.Share
+	}
+
+	inner, duration, err := newScaledPacer(req, share)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &rebalancingPacer{req: *req, share: share, inner: inner}, duration, nil
+}
+
+// newScaledPacer creates the pacer described by a load test request with all of its rates multiplied by scale.
+func newScaledPacer(req *messages.StartLoadTestRequest, scale float64) (vegeta.Pacer, time.Duration, error) {
+	duration := time.Duration(req.Duration) * time.Second
+
+	if scale <= 0 {
+		// The worker got no share of the total rate.
+		return stoppedPacer{}, duration, nil
+	}
+
+	switch req.Pacer {
+	case "", messages.PacerConstant:
+		return scaledRate(float64(req.Rate), scale), duration, nil
+
+	case messages.PacerLinear:
+		if req.Rate == 0 {
+			return nil, 0, fmt.Errorf("linear pacer requires a rate to start at")
+		}
+
+		return vegeta.LinearPacer{
+			StartAt: scaledRate(float64(req.Rate), scale),
+			Slope:   req.Slope * scale,
+		}, duration, nil
+
+	case messages.PacerSine:
+		startAt, err := sineStartAt(req.SineStartAt)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if req.SinePeriod == 0 || req.Rate == 0 || req.SineAmplitude >= req.Rate {
+			return nil, 0, fmt.Errorf("sine pacer requires a period and an amplitude lower than the rate")
+		}
+
+		return vegeta.SinePacer{
+			Period:  time.Duration(req.SinePeriod) * time.Second,
+			Mean:    scaledRate(float64(req.Rate), scale),
+			Amp:     scaledRate(float64(req.SineAmplitude), scale),
+			StartAt: startAt,
+		}, duration, nil
+
+	case messages.PacerStages:
+		if len(req.Stages) == 0 {
+			return nil, 0, fmt.Errorf("stages pacer requires at least one stage")
+		}
+
+		p := newStagesPacer(float64(req.Rate), req.Stages, scale)
+
+		return p, p.duration, nil
+	}
+
+	return nil, 0, fmt.Errorf("unknown pacer %q", req.Pacer)
+}
+
+// scaledRate returns a vegeta rate of rate*scale requests per second. Fractional rates are
+// expressed per 1000 seconds, which keeps millisecond precision.
+func scaledRate(rate float64, scale float64) vegeta.Rate {
+	r := rate * scale
+	if r == math.Trunc(r) {
+		return vegeta.Rate{Freq: int(r), Per: time.Second}
+	}
+
+	return vegeta.Rate{Freq: int(math.Round(r * 1000)), Per: 1000 * time.Second}
+}
+
+// rebalancingPacer is a pacer whose share of the total load profile can be changed during an attack.
+type rebalancingPacer struct {
+	req   messages.StartLoadTestRequest
+	share float64
+	inner vegeta.Pacer
+	// offset is added to the hits sent by the attacker to get the hits the inner pacer expects.
+	offset   float64
+	lastHits uint64
+	lock     sync.Mutex
+}
+
+// Pace determines the length of time to sleep until the next hit is sent.
+func (p *rebalancingPacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.lastHits = hits
+
+	virtualHits := math.Max(0, float64(hits)+p.offset)
+
+	return p.inner.Pace(elapsed, uint64(math.Round(virtualHits)))
+}
+
+// Rate returns the instantaneous hit rate (i.e. requests per second) at the given elapsed duration of an attack.
+func (p *rebalancingPacer) Rate(elapsed time.Duration) float64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.inner.Rate(elapsed)
+}
+
+// Rebalance continues the attack with a new share of the total load profile.
+//
+// The inner pacer is replaced by one scaled to the new share. Its expected hits are the
+// old pacer's hits scaled by the ratio of the shares, so the attacker's hit count is
+// offset accordingly: the hits sent so far are kept and only the upcoming hits follow the new share.
+func (p *rebalancingPacer) Rebalance(share float64) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	inner, _, err := newScaledPacer(&p.req, share)
+	if err != nil {
+		return err
+	}
+
+	virtualHits := 0.0
+	if p.share > 0 {
+		virtualHits = (float64(p.lastHits) + p.offset) * share / p.share
+	}
+
+	p.offset = virtualHits - float64(p.lastHits)
+	p.inner = inner
+	p.share = share
+
+	return nil
+}
+
+// stoppedPacer is a pacer that stops the attack right away.
+type stoppedPacer struct{}
+
+func (stoppedPacer) Pace(time.Duration, uint64) (time.Duration, bool) { return 0, true }
+func (stoppedPacer) Rate(time.Duration) float64                       { return 0 }
+
+func sineStartAt(s string) (float64, error) {
+	switch s {
+	case "", "mean-up":
+		return vegeta.MeanUp, nil
+	case "peak":
+		return vegeta.Peak, nil
+	case "mean-down":
+		return vegeta.MeanDown, nil
+	case "trough":
+		return vegeta.Trough, nil
+	}
+
+	return 0, fmt.Errorf("unknown sine start %q", s)
+}
+
+// stagesPacer paces an attack through a list of stages, ramping the rate linearly within each stage.
+type stagesPacer struct {
+	stages   []pacerStage
+	duration time.Duration
+}
+
+type pacerStage struct {
+	// start is the elapsed time in seconds at which the stage starts.
+	start float64
+	// length of the stage in seconds.
+	length float64
+	// from and to are the rates in hits per second at the start and the end of the stage.
+	from, to float64
+	// hits is the number of hits expected before the stage starts.
+	hits float64
+}
+
+// newStagesPacer creates a stages pacer with all rates multiplied by scale.
+func newStagesPacer(startRate float64, stages []messages.LoadTestStage, scale float64) *stagesPacer {
+	p := &stagesPacer{}
+
+	var start, hits float64
+	from := startRate * scale
+
+	for _, s := range stages {
+		st := pacerStage{start: start, length: float64(s.Duration), from: from, to: float64(s.Rate) * scale, hits: hits}
+		p.stages = append(p.stages, st)
+
+		start += st.length
+		hits += st.hitsAt(st.length)
+		from = st.to
+	}
+
+	p.duration = time.Duration(start * float64(time.Second))
+
+	return p
+}
+
+// Pace determines the length of time to sleep until the next hit is sent.
+func (p *stagesPacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	t := elapsed.Seconds()
+
+	if hits < uint64(p.hits(t)) {
+		// Running behind, send next hit immediately.
+		return 0, false
+	}
+
+	target := float64(hits + 1)
+
+	for _, st := range p.stages {
+		if st.start+st.length < t || st.length == 0 {
+			continue
+		}
+
+		if at, ok := st.timeOf(target); ok {
+			return time.Duration((at - t) * float64(time.Second)), false
+		}
+	}
+
+	// The next hit is not due before the last stage ends.
+	return 0, true
+}
+
+// Rate returns the instantaneous hit rate (i.e. requests per second) at the given elapsed duration of an attack.
+func (p *stagesPacer) Rate(elapsed time.Duration) float64 {
+	t := elapsed.Seconds()
+
+	for i := len(p.stages) - 1; i >= 0; i-- {
+		st := p.stages[i]
+		if t >= st.start && st.length > 0 {
+			d := math.Min(t-st.start, st.length)
+			return st.from + (st.to-st.from)*d/st.length
+		}
+	}
+
+	return 0
+}
+
+// hits returns the number of hits expected after t seconds.
+func (p *stagesPacer) hits(t float64) float64 {
+	for i := len(p.stages) - 1; i >= 0; i-- {
+		st := p.stages[i]
+		if t >= st.start {
+			return st.hits + st.hitsAt(math.Min(t-st.start, st.length))
+		}
+	}
+
+	return 0
+}
+
+// hitsAt returns the number of hits expected within the stage after d seconds.
+func (st pacerStage) hitsAt(d float64) float64 {
+	if st.length == 0 {
+		return 0
+	}
+
+	slope := (st.to - st.from) / st.length
+
+	return st.from*d + slope*d*d/2
+}
+
+// timeOf returns the elapsed time in seconds at which the expected number of hits reaches target,
+// if that happens within the stage.
+func (st pacerStage) timeOf(target float64) (float64, bool) {
+	remaining := target - st.hits
+	slope := (st.to - st.from) / st.length
+
+	var d float64
+	if slope == 0 {
+		if st.from <= 0 {
+			return 0, false
+		}
+
+		d = remaining / st.from
+	} else {
+		// Solve from*d + slope*d^2/2 = remaining for the smallest non-negative d.
+		discriminant := st.from*st.from + 2*slope*remaining
+		if discriminant < 0 {
+			return 0, false
+		}
+
+		d = (-st.from + math.Sqrt(discriminant)) / slope
+	}
+
+	if d < 0 || d > st.length {
+		return 0, false
+	}
+
+	return st.start + d, true
+}
@@ -0,0 +1,142 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// resultsBatchSize is the size of encoded results above which a batch is sent to the server
+// without waiting for the next metrics report.
+const resultsBatchSize = 1 << 20
+
+// resultsWriter encodes the raw results of a load test, either into batches sent to the server
+// or into a local file. Batches are JSON lines, so each batch decodes on its own.
+type resultsWriter struct {
+	runID   string
+	lock    sync.Mutex
+	encoder vegeta.Encoder
+	buf     *bytes.Buffer
+	file    *os.File
+	writer  *bufio.Writer
+	// sendLock keeps batches in order, and seq is the sequence number of the last batch sent.
+	sendLock sync.Mutex
+	seq      uint64
+}
+
+// newResultsWriter creates the results writer requested by a load test request, or nil when raw results are discarded.
+// File outputs are created in dir.
+func newResultsWriter(req *messages.StartLoadTestRequest, dir string, workerName string) (*resultsWriter, error) {
+	var ext string
+	var newEncoder func(io.Writer) vegeta.Encoder
+
+	switch req.ResultsEncoding {
+	case "", messages.ResultsEncodingGob:
+		ext, newEncoder = ".gob", vegeta.NewEncoder
+	case messages.ResultsEncodingCSV:
+		ext, newEncoder = ".csv", vegeta.NewCSVEncoder
+	case messages.ResultsEncodingJSON:
+		ext, newEncoder = ".json", vegeta.NewJSONEncoder
+	default:
+		return nil, fmt.Errorf("unknown results encoding %q", req.ResultsEncoding)
+	}
+
+	switch req.ResultsOutput {
+	case "":
+		return nil, nil
+	case messages.ResultsOutputServer:
+		// Unlike gob, JSON lines batches decode on their own, whatever batch was lost before.
+		buf := &bytes.Buffer{}
+		return &resultsWriter{runID: req.RunID, encoder: vegeta.NewJSONEncoder(buf), buf: buf}, nil
+	case messages.ResultsOutputFile:
+		// The run ID comes from the server, it must not lead out of the results directory.
+		if req.RunID == "" || strings.ContainsAny(req.RunID, `/\.`) {
+			return nil, fmt.Errorf("invalid load test run id %q", req.RunID)
+		}
+
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("Failed to create results directory: %w", err)
+		}
+
+		path := filepath.Join(dir, req.RunID+"-"+url.PathEscape(workerName)+ext)
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create results file: %w", err)
+		}
+
+		writer := bufio.NewWriter(file)
+		return &resultsWriter{runID: req.RunID, encoder: newEncoder(writer), file: file, writer: writer}, nil
+	}
+
+	return nil, fmt.Errorf("unknown results output %q", req.ResultsOutput)
+}
+
+// Add encodes a result. It returns true when a batch is ready to be sent to the server.
+func (r *resultsWriter) Add(res *vegeta.Result) (bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.encoder.Encode(res); err != nil {
+		return false, err
+	}
+
+	return r.buf != nil && r.buf.Len() >= resultsBatchSize, nil
+}
+
+// Flush passes the results encoded since the previous batch to send, with the batch's sequence number.
+// Batches are sent in order. A batch that failed to be sent is sent again with the next one.
+// It does nothing for file outputs.
+func (r *resultsWriter) Flush(send func(batch []byte, seq uint64) error) {
+	r.sendLock.Lock()
+	defer r.sendLock.Unlock()
+
+	r.lock.Lock()
+	if r.buf == nil || r.buf.Len() == 0 {
+		r.lock.Unlock()
+		return
+	}
+
+	batch := make([]byte, r.buf.Len())
+	copy(batch, r.buf.Bytes())
+	r.buf.Reset()
+	r.lock.Unlock()
+
+	// Results keep being encoded while the batch is sent.
+	if err := send(batch, r.seq+1); err != nil {
+		r.lock.Lock()
+		rest := append(batch, r.buf.Bytes()...)
+		r.buf.Reset()
+		r.buf.Write(rest)
+		r.lock.Unlock()
+
+		return
+	}
+
+	r.seq++
+}
+
+// Close flushes and closes the results file, if any.
+func (r *resultsWriter) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	if err := r.writer.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+
+	return r.file.Close()
+}
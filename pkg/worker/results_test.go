@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+func decodeAll(t *testing.T, data []byte) []uint64 {
+	dec := vegeta.DecoderFor(bytes.NewReader(data))
+	require.NotNil(t, dec)
+
+	var seqs []uint64
+	for {
+		var res vegeta.Result
+		err := dec.Decode(&res)
+		if err == io.EOF {
+			return seqs
+		}
+		require.NoError(t, err)
+
+		seqs = append(seqs, res.Seq)
+	}
+}
+
+func TestResultsWriterBatches(t *testing.T) {
+	r, err := newResultsWriter(&messages.StartLoadTestRequest{
+		RunID:           "run",
+		ResultsOutput:   messages.ResultsOutputServer,
+		ResultsEncoding: messages.ResultsEncodingGob,
+	}, t.TempDir(), "worker")
+	require.NoError(t, err)
+
+	add := func(seqs ...uint64) {
+		for _, seq := range seqs {
+			_, err := r.Add(&vegeta.Result{Seq: seq, Code: 200, Timestamp: time.Unix(0, 0)})
+			require.NoError(t, err)
+		}
+	}
+
+	type sent struct {
+		seq  uint64
+		data []byte
+	}
+	var batches []sent
+	fail := false
+
+	send := func(batch []byte, seq uint64) error {
+		if fail {
+			return errors.New("disconnected")
+		}
+
+		batches = append(batches, sent{seq: seq, data: batch})
+		return nil
+	}
+
+	add(0, 1)
+	r.Flush(send)
+
+	// A batch that failed to be sent is sent again with the next one.
+	add(2)
+	fail = true
+	r.Flush(send)
+	fail = false
+
+	add(3)
+	r.Flush(send)
+
+	// Nothing to send.
+	r.Flush(send)
+
+	require.Len(t, batches, 2)
+	assert.Equal(t, uint64(1), batches[0].seq)
+	assert.Equal(t, uint64(2), batches[1].seq)
+
+	// Each batch decodes on its own, whatever the requested encoding.
+	assert.Equal(t, []uint64{0, 1}, decodeAll(t, batches[0].data))
+	assert.Equal(t, []uint64{2, 3}, decodeAll(t, batches[1].data))
+	assert.Equal(t, []uint64{0, 1, 2, 3}, decodeAll(t, append(batches[0].data, batches[1].data...)))
+}
+
+func TestResultsFileStaysInResultsDir(t *testing.T) {
+	tests := []struct {
+		runID   string
+		wantErr bool
+	}{
+		{"20200101-000000-00000000", false},
+		{"", true},
+		{"../escaped", true},
+		{"..", true},
+		{"sub/run", true},
+		{`sub\run`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.runID, func(t *testing.T) {
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "results")
+
+			r, err := newResultsWriter(&messages.StartLoadTestRequest{
+				RunID:         tt.runID,
+				ResultsOutput: messages.ResultsOutputFile,
+			}, dir, "worker")
+
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid load test run id")
+
+				// Nothing was created, in the results directory or out of it.
+				entries, err := ioutil.ReadDir(parent)
+				require.NoError(t, err)
+				assert.Empty(t, entries)
+				return
+			}
+
+			require.NoError(t, err)
+			r.file.Close()
+
+			entries, err := ioutil.ReadDir(dir)
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+			assert.Equal(t, tt.runID+"-worker.gob", entries[0].Name())
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package worker
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// newTargeter creates the targeter described by a load test request.
+// workerName is made available to request templates. Bodies referenced by targets files are read from bodiesDir.
+func newTargeter(req *messages.StartLoadTestRequest, workerName string, bodiesDir string) (vegeta.Targeter, error) {
+	targets, weights, err := requestTargets(req, bodiesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Templated URLs are only known once evaluated.
+	if !req.Template {
+		if err := validateTargets(targets); err != nil {
+			return nil, err
+		}
+	}
+
+	var targeter vegeta.Targeter
+	if weights == nil {
+		targeter = vegeta.NewStaticTargeter(targets...)
+	} else {
+		targeter = newWeightedTargeter(targets, weights)
+	}
+
+	if !req.Template {
+		return targeter, nil
+	}
+
+	feed, err := readDataFeed(req.DataFeed, req.DataFeedFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTemplateTargeter(targeter, targets, feed, workerName, req.RunID)
+}
+
+// requestTargets returns the targets of a load test request, and their weights if any target is weighted.
+func requestTargets(req *messages.StartLoadTestRequest, bodiesDir string) ([]vegeta.Target, []uint64, error) {
+	if req.TargetsFile != "" {
+		targets, err := readTargetsFile(req.TargetsFile, req.TargetsFormat, bodiesDir)
+		return targets, nil, err
+	}
+
+	if len(req.Targets) == 0 {
+		return []vegeta.Target{{
+			Method: req.Method,
+			URL:    req.URL,
+			Header: parseHeader(req.Header),
+			Body:   []byte(req.Body),
+		}}, nil, nil
+	}
+
+	targets := make([]vegeta.Target, 0, len(req.Targets))
+	weights := make([]uint64, 0, len(req.Targets))
+	weighted := false
+
+	for _, t := range req.Targets {
+		targets = append(targets, vegeta.Target{
+			Method: t.Method,
+			URL:    t.URL,
+			Header: parseHeader(t.Header),
+			Body:   []byte(t.Body),
+		})
+
+		weight := t.Weight
+		if weight == 0 {
+			weight = 1
+		} else {
+			weighted = true
+		}
+
+		weights = append(weights, weight)
+	}
+
+	if !weighted {
+		return targets, nil, nil
+	}
+
+	return targets, weights, nil
+}
+
+// validateTargets checks that the targets' URLs are absolute HTTP URLs.
+func validateTargets(targets []vegeta.Target) error {
+	for _, t := range targets {
+		u, err := url.Parse(t.URL)
+		if err != nil {
+			return fmt.Errorf("invalid URL %q: %w", t.URL, err)
+		}
+
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid URL %q: not an absolute http or https URL", t.URL)
+		}
+	}
+
+	return nil
+}
+
+// readTargetsFile reads all targets of a vegeta targets file. Bodies referenced with @path are read from bodiesDir.
+func readTargetsFile(content string, format string, bodiesDir string) ([]vegeta.Target, error) {
+	var targeter vegeta.Targeter
+
+	switch format {
+	case "", messages.TargetsFormatHTTP:
+		content, err := resolveBodyFiles(content, bodiesDir)
+		if err != nil {
+			return nil, err
+		}
+
+		targeter = vegeta.NewHTTPTargeter(strings.NewReader(content), nil, nil)
+	case messages.TargetsFormatJSON:
+		targeter = vegeta.NewJSONTargeter(strings.NewReader(content), nil, nil)
+	default:
+		return nil, fmt.Errorf("unknown targets format %q", format)
+	}
+
+	targets, err := vegeta.ReadAllTargets(targeter)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read targets file: %w", err)
+	}
+
+	if len(targets) == 0 {
+		return nil, vegeta.ErrNoTargets
+	}
+
+	return targets, nil
+}
+
+// resolveBodyFiles rewrites the @path body references of an HTTP targets file to paths within bodiesDir. Targets files
+// come from the server, which must not read any other file of the worker, so references are rejected without bodiesDir.
+func resolveBodyFiles(content string, bodiesDir string) (string, error) {
+	lines := strings.Split(content, "\n")
+
+	for i, line := range lines {
+		ref := strings.TrimSpace(line)
+		if !strings.HasPrefix(ref, "@") {
+			continue
+		}
+
+		if bodiesDir == "" {
+			return "", fmt.Errorf("body file %q is not allowed, the worker has no bodies directory", ref[1:])
+		}
+
+		// Cleaned as an absolute path first, so that ".." cannot leave the directory.
+		lines[i] = "@" + filepath.Join(bodiesDir, filepath.Clean("/"+ref[1:]))
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// parseHeader parses a header of "Key: Value" lines.
+func parseHeader(s string) http.Header {
+	header := http.Header{}
+	for _, line := range strings.Split(s, "\n") {
+		parts := strings.Split(line, ":")
+
+		if len(parts) != 2 {
+			continue
+		}
+
+		key := parts[0]
+		value := strings.TrimLeft(parts[1], " ")
+
+		header.Add(key, value)
+	}
+
+	return header
+}
+
+// newWeightedTargeter returns a targeter that hits targets proportionally to their weights.
+// It uses smooth weighted round-robin, which spreads the hits of heavier targets evenly
+// instead of sending them in bursts.
+func newWeightedTargeter(targets []vegeta.Target, weights []uint64) vegeta.Targeter {
+	var lock sync.Mutex
+	current := make([]int64, len(targets))
+
+	total := int64(0)
+	for _, w := range weights {
+		total += int64(w)
+	}
+
+	return func(tgt *vegeta.Target) error {
+		if tgt == nil {
+			return vegeta.ErrNilTarget
+		}
+
+		lock.Lock()
+		best := 0
+		for i, w := range weights {
+			current[i] += int64(w)
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		lock.Unlock()
+
+		*tgt = targets[best]
+
+		return nil
+	}
+}
@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBodyFilesAreReadFromBodiesDir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "body.json"), []byte(`{"a":1}`), 0644))
+
+	tests := []struct {
+		name      string
+		content   string
+		bodiesDir string
+		body      string
+		err       string
+	}{
+		{"no reference", "GET http://127.0.0.1/\n", "", "", ""},
+		{"no bodies dir", "POST http://127.0.0.1/\n@/etc/passwd\n", "", "", "not allowed"},
+		{"relative", "POST http://127.0.0.1/\n@body.json\n", dir, `{"a":1}`, ""},
+		{"absolute", "POST http://127.0.0.1/\n@/body.json\n", dir, `{"a":1}`, ""},
+		{"escaping", "POST http://127.0.0.1/\n@../../../../etc/hostname\n", dir, "", "no such file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, err := readTargetsFile(tt.content, "", tt.bodiesDir)
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, targets, 1)
+			assert.Equal(t, tt.body, string(targets[0].Body))
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package worker
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// templateData holds the variables available to request templates.
+type templateData struct {
+	// Seq is the sequence number of the request on this worker, starting at 0.
+	Seq uint64
+	// Worker is the name of the worker sending the request.
+	Worker string
+	// RunID is the ID of the load test run.
+	RunID string
+	// Timestamp is the time the request is generated.
+	Timestamp time.Time
+	// Data is the data feed record of the request, nil without a data feed.
+	Data map[string]interface{}
+}
+
+var templateFuncs = template.FuncMap{
+	"uuid":    newUUID,
+	"randInt": randInt,
+}
+
+// templateTargeter renders the templates of the targets produced by another targeter.
+type templateTargeter struct {
+	base       vegeta.Targeter
+	templates  map[string]*template.Template
+	feed       []map[string]interface{}
+	workerName string
+	runID      string
+	seq        uint64
+}
+
+// newTemplateTargeter wraps a targeter producing the given targets so that their URL, header values
+// and body are rendered as templates for every request. All templates are parsed upfront so that
+// syntax errors are reported before the load test starts.
+func newTemplateTargeter(base vegeta.Targeter, targets []vegeta.Target, feed []map[string]interface{}, workerName string, runID string) (vegeta.Targeter, error) {
+	t := &templateTargeter{
+		base:       base,
+		templates:  make(map[string]*template.Template),
+		feed:       feed,
+		workerName: workerName,
+		runID:      runID,
+	}
+
+	for _, target := range targets {
+		if err := t.parse(target.URL); err != nil {
+			return nil, err
+		}
+
+		for _, values := range target.Header {
+			for _, v := range values {
+				if err := t.parse(v); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if err := t.parse(string(target.Body)); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.Next, nil
+}
+
+func (t *templateTargeter) parse(text string) error {
+	if !strings.Contains(text, "{{") {
+		return nil
+	}
+
+	if _, ok := t.templates[text]; ok {
+		return nil
+	}
+
+	tmpl, err := template.New("request").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return fmt.Errorf("Failed to parse request template %q: %w", text, err)
+	}
+
+	t.templates[text] = tmpl
+
+	return nil
+}
+
+// Next renders the next target.
+func (t *templateTargeter) Next(tgt *vegeta.Target) error {
+	if err := t.base(tgt); err != nil {
+		return err
+	}
+
+	data := templateData{
+		Seq:       atomic.AddUint64(&t.seq, 1) - 1,
+		Worker:    t.workerName,
+		RunID:     t.runID,
+		Timestamp: time.Now(),
+	}
+
+	if len(t.feed) > 0 {
+		data.Data = t.feed[data.Seq%uint64(len(t.feed))]
+	}
+
+	url, err := t.render(tgt.URL, &data)
+	if err != nil {
+		return err
+	}
+
+	header := make(http.Header, len(tgt.Header))
+	for key, values := range tgt.Header {
+		rendered := make([]string, 0, len(values))
+		for _, v := range values {
+			r, err := t.render(v, &data)
+			if err != nil {
+				return err
+			}
+			rendered = append(rendered, r)
+		}
+		header[key] = rendered
+	}
+
+	body, err := t.render(string(tgt.Body), &data)
+	if err != nil {
+		return err
+	}
+
+	tgt.URL = url
+	tgt.Header = header
+	tgt.Body = []byte(body)
+
+	return nil
+}
+
+func (t *templateTargeter) render(text string, data *templateData) (string, error) {
+	tmpl, ok := t.templates[text]
+	if !ok {
+		return text, nil
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("Failed to render request template: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// readDataFeed parses the records of a data feed.
+func readDataFeed(content string, format string) ([]map[string]interface{}, error) {
+	if content == "" {
+		return nil, nil
+	}
+
+	switch format {
+	case "", messages.DataFeedFormatCSV:
+		rows, err := csv.NewReader(strings.NewReader(content)).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read data feed: %w", err)
+		}
+
+		if len(rows) < 2 {
+			return nil, fmt.Errorf("data feed has no records")
+		}
+
+		columns := rows[0]
+		records := make([]map[string]interface{}, 0, len(rows)-1)
+
+		for _, row := range rows[1:] {
+			record := make(map[string]interface{}, len(columns))
+			for i, column := range columns {
+				record[column] = row[i]
+			}
+			records = append(records, record)
+		}
+
+		return records, nil
+	case messages.DataFeedFormatJSON:
+		var records []map[string]interface{}
+		if err := json.Unmarshal([]byte(content), &records); err != nil {
+			return nil, fmt.Errorf("Failed to read data feed: %w", err)
+		}
+
+		if len(records) == 0 {
+			return nil, fmt.Errorf("data feed has no records")
+		}
+
+		return records, nil
+	default:
+		return nil, fmt.Errorf("unknown data feed format %q", format)
+	}
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// randInt returns a random integer in [min, max).
+func randInt(min int, max int) (int, error) {
+	if max <= min {
+		return 0, fmt.Errorf("randInt: max must be greater than min")
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max-min)))
+	if err != nil {
+		return 0, err
+	}
+
+	return min + int(n.Int64()), nil
+}
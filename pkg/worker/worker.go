@@ -1,15 +1,21 @@
 package worker
 
 import (
-	"encoding/json"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand"
 	"net/http"
 	"net/url"
-	"strings"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/transport"
 	"github.com/gorilla/websocket"
+	"github.com/influxdata/tdigest"
 	vegeta "github.com/tsenart/vegeta/v12/lib"
 	"go.uber.org/zap"
 )
@@ -34,16 +40,45 @@ func SetLogger(l *zap.SugaredLogger) {
 // Worker represents a worker object. It receives commands from server
 // to start and stop a load test. It also reports metrics to the server.
 type Worker struct {
-	name                 string
-	conn                 *websocket.Conn
-	connWriteLock        sync.Mutex
+	// serverTimeout is the heartbeat timeout of the current connection in nanoseconds, adjusted to the
+	// server's heartbeat once welcomed. It is accessed atomically and first for alignment.
+	serverTimeout int64
+	name          string
+	weight        int
+	conn          transport.Conn
+	connWriteLock sync.Mutex
+	// envelopeEncoding is the envelope encoding negotiated with the server of the current connection.
+	envelopeEncoding     string
 	messageHandler       MessageHandler
 	connectRetryInterval time.Duration
-	attacker             *vegeta.Attacker
-	metrics              vegeta.Metrics
-	metricsLock          sync.RWMutex
-	loadTestState        messages.WorkerState
-	connectedCallbacks   []func()
+	// maxConnectRetryInterval caps the exponential backoff between connection attempts.
+	maxConnectRetryInterval time.Duration
+	closed                  chan struct{}
+	// heartbeatTimeout is how long the server may stay silent before the worker reconnects.
+	heartbeatTimeout time.Duration
+	version          string
+	closeOnce        sync.Once
+	attacker         *vegeta.Attacker
+	metrics          vegeta.Metrics
+	latencies        *tdigest.TDigest
+	intervals        *intervalRecorder
+	// unsentIntervals are the intervals flushed but not sent yet, e.g. while disconnected.
+	unsentIntervals []messages.MetricsInterval
+	metricsLock     sync.RWMutex
+	// loadTestLock guards the state, run, attacker, pacer and results of the load test.
+	loadTestLock  sync.Mutex
+	loadTestState messages.WorkerState
+	runID         string
+	runWorkerID   string
+	// attackDone is closed once the attack of the load test finished.
+	attackDone         chan struct{}
+	pacer              *rebalancingPacer
+	results            *resultsWriter
+	resultsDir         string
+	bodiesDir          string
+	clusterSecret      string
+	tlsConfig          *tls.Config
+	connectedCallbacks []func()
 }
 
 // MessageHandler is interface to handle message from the server.
@@ -58,8 +93,16 @@ type defaultMessageHandler struct {
 // NewWorker creates a new worker.
 func NewWorker() *Worker {
 	worker := &Worker{
-		connectRetryInterval: 5 * time.Second,
-		attacker:             vegeta.NewAttacker(),
+		connectRetryInterval:    5 * time.Second,
+		maxConnectRetryInterval: time.Minute,
+		closed:                  make(chan struct{}),
+		heartbeatTimeout:        30 * time.Second,
+		version:                 "dev",
+		weight:                  1,
+		resultsDir:              "terjang-results",
+		attacker:                vegeta.NewAttacker(),
+		latencies:               tdigest.NewWithCompression(messages.LatencyCompression),
+		intervals:               newIntervalRecorder(),
 	}
 
 	msgHandler := &defaultMessageHandler{worker: worker}
@@ -73,43 +116,171 @@ func (w *Worker) SetName(name string) {
 	w.name = name
 }
 
+// SetVersion sets the build version the worker reports to the server. The default is "dev".
+func (w *Worker) SetVersion(version string) {
+	w.version = version
+}
+
+// SetWeight sets the worker's weight. When the server divides a total rate across workers,
+// each worker gets a share proportional to its weight. The default weight is 1.
+func (w *Worker) SetWeight(weight int) {
+	w.weight = weight
+}
+
+// SetBodiesDir sets the directory the bodies referenced with @path in targets files are read from. Paths are
+// relative to it and cannot leave it. Without it, targets files referencing bodies are rejected, so that the server
+// cannot read the worker's files.
+func (w *Worker) SetBodiesDir(dir string) {
+	w.bodiesDir = dir
+}
+
+// SetResultsDir sets the directory raw results are written to when a load test asks for a file output.
+func (w *Worker) SetResultsDir(dir string) {
+	w.resultsDir = dir
+}
+
+// SetClusterSecret sets the secret presented to the server when joining the cluster.
+func (w *Worker) SetClusterSecret(secret string) {
+	w.clusterSecret = secret
+}
+
+// SetTLSConfig makes the worker connect to the server with a secure websocket (wss) and a TLS configuration,
+// e.g. from tlsconfig.Client. The configuration holds the worker's client certificate when the server requires one.
+func (w *Worker) SetTLSConfig(config *tls.Config) {
+	w.tlsConfig = config
+}
+
 // Run connects to the server to establish communication to receive start and stop load test requests.
-// The connection is also used to reports metrics.
+// The connection is also used to reports metrics. When the connection fails or is lost, the worker reconnects
+// with a jittered exponential backoff until it is closed or removed by the server. A load test keeps running
+// while the worker is disconnected, and is resumed on the server once reconnected.
 func (w *Worker) Run(addr string) {
-	serverURL := url.URL{Scheme: "ws", Host: addr, Path: "/cluster/join", RawQuery: "name=" + w.name}
+	query := url.Values{"name": {w.name}, "weight": {strconv.Itoa(w.weight)}}
+	serverURL := url.URL{Scheme: "ws", Host: addr, Path: "/cluster/join", RawQuery: query.Encode()}
+
+	dialer := *websocket.DefaultDialer
+	if w.tlsConfig != nil {
+		serverURL.Scheme = "wss"
+		dialer.TLSClientConfig = w.tlsConfig
+	}
 
 	serverURLStr := serverURL.String()
 
-	var conn *websocket.Conn
-	var err error
+	header := http.Header{}
+	if w.clusterSecret != "" {
+		header.Set("Authorization", "Bearer "+w.clusterSecret)
+	}
+
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	attempt := 0
 
-	for i := 0; i < 10; i++ {
+	for !w.isClosed() {
 		logger.Infow("Connecting to server", "address", addr)
 
-		conn, _, err = websocket.DefaultDialer.Dial(serverURLStr, nil)
+		conn, resp, err := dialer.Dial(serverURLStr, header)
 
 		if err == nil {
-			break
+			logger.Infow("Connected to server", "address", addr)
+
+			attempt = 0
+			w.RunWithConn(transport.NewWebsocketConn(conn))
+
+			if w.isClosed() {
+				break
+			}
+
+			logger.Warnw("Disconnected from server", "address", addr)
+		} else if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			logger.Errorw("Server rejected the cluster secret", "address", addr)
+		} else if resp != nil && resp.StatusCode == http.StatusForbidden {
+			logger.Errorw("Server requires a valid client certificate", "address", addr)
+		} else {
+			logger.Warnw("Failed to connect to server", "address", addr, "error", err)
 		}
 
-		time.Sleep(w.connectRetryInterval)
+		select {
+		case <-time.After(w.backoff(attempt, random)):
+		case <-w.closed:
+		}
+
+		attempt++
+	}
+
+	logger.Infow("Worker closed")
+}
+
+// backoff returns the delay before a connection attempt: the connect retry interval doubled on each failed attempt,
+// up to the max connect retry interval, with a random jitter of up to half of it.
+func (w *Worker) backoff(attempt int, random *rand.Rand) time.Duration {
+	delay := w.connectRetryInterval
+	for i := 0; i < attempt && delay < w.maxConnectRetryInterval; i++ {
+		delay *= 2
+	}
+
+	if delay > w.maxConnectRetryInterval {
+		delay = w.maxConnectRetryInterval
+	}
+
+	if delay < 2 {
+		return delay
 	}
 
-	logger.Infow("Connected to server", "address", addr)
+	return delay/2 + time.Duration(random.Int63n(int64(delay/2)))
+}
 
+// RunWithConn receives start and stop load test requests and reports metrics through an established connection,
+// e.g. one end of a transport.Pipe whose other end is served by an in-process server.
+// The worker says hello first, then a worker that took part in a load test announces its state and run,
+// so the server can resume it.
+// It returns when the connection is closed.
+func (w *Worker) RunWithConn(conn transport.Conn) {
+	w.connWriteLock.Lock()
 	w.conn = conn
+	w.envelopeEncoding = messages.EnvelopeEncodingJSONString
+	w.connWriteLock.Unlock()
+
+	atomic.StoreInt64(&w.serverTimeout, int64(w.heartbeatTimeout))
+
+	disconnected := make(chan struct{})
+	defer close(disconnected)
 	defer conn.Close()
 
-	go func() {
-		for _, callback := range w.connectedCallbacks {
-			callback()
-		}
-	}()
+	w.sendHelloToServer()
+
+	if _, runID := w.loadTestInfo(); runID != "" {
+		w.sendWorkerInfoToServer()
+	}
+
+	go w.loopSendMetricsToServer(disconnected)
+
+	lastReceived := time.Now().UnixNano()
+	go w.watchServer(conn, &lastReceived, disconnected)
 
-	go w.LoopSendMetricsToServer()
+	joined := false
 
 	for {
-		_, message, err := conn.ReadMessage()
+		message, err := conn.ReadMessage()
+		atomic.StoreInt64(&lastReceived, time.Now().UnixNano())
+
+		// The handshake and heartbeats are handled here rather than by the message handler.
+		handled := w.handleConnMessage(message)
+
+		// The worker joined once welcomed, so the server knows its features. Servers older than the handshake
+		// send no welcome, their first message tells the worker is in.
+		if !joined && err == nil && !w.isClosed() {
+			joined = true
+
+			go func() {
+				for _, callback := range w.connectedCallbacks {
+					callback()
+				}
+			}()
+		}
+
+		if handled {
+			continue
+		}
+
 		w.messageHandler.HandleMessage(message)
 
 		if err != nil {
@@ -118,22 +289,126 @@ func (w *Worker) Run(addr string) {
 	}
 }
 
+// watchServer closes the connection when nothing is received from the server for longer than the heartbeat timeout,
+// e.g. on a half-open connection, so the worker reconnects. It stops watching when the server turns out not to ping.
+func (w *Worker) watchServer(conn transport.Conn, lastReceived *int64, done <-chan struct{}) {
+	if w.heartbeatTimeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(w.heartbeatTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			timeout := time.Duration(atomic.LoadInt64(&w.serverTimeout))
+			if timeout <= 0 {
+				return
+			}
+
+			if time.Since(time.Unix(0, atomic.LoadInt64(lastReceived))) > timeout {
+				logger.Warnw("Server is unresponsive, disconnecting", "timeout", timeout)
+				conn.Close()
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// Close stops the worker: the running load test is stopped, the connection is closed and Run returns
+// instead of reconnecting.
+func (w *Worker) Close() {
+	w.closeOnce.Do(func() {
+		close(w.closed)
+	})
+
+	if state, _ := w.loadTestInfo(); state == messages.WorkerStateRunning {
+		w.stopLoadTest()
+	}
+
+	w.connWriteLock.Lock()
+	defer w.connWriteLock.Unlock()
+
+	if w.conn != nil {
+		w.conn.Close()
+	}
+}
+
+func (w *Worker) isClosed() bool {
+	select {
+	case <-w.closed:
+		return true
+	default:
+		return false
+	}
+}
+
 // SetConnectRetryInterval sets connect retry interval. On connect failure, worker retries with backoff time
-// set by this function.
+// set by this function, doubled on each consecutive failure.
 func (w *Worker) SetConnectRetryInterval(d time.Duration) {
 	w.connectRetryInterval = d
 }
 
+// SetHeartbeatTimeout sets how long the server may stay silent before the worker considers the connection dead
+// and reconnects. The server pings its workers, so it is never silent for long. Once welcomed, the timeout is
+// raised to fit the server's heartbeat, or disabled when the server does not ping. The default is 30 seconds,
+// zero disables it.
+func (w *Worker) SetHeartbeatTimeout(d time.Duration) {
+	w.heartbeatTimeout = d
+}
+
+// SetMaxConnectRetryInterval sets the maximum backoff time between connection attempts. The default is 1 minute.
+func (w *Worker) SetMaxConnectRetryInterval(d time.Duration) {
+	w.maxConnectRetryInterval = d
+}
+
 // SendMessageToServer sends a message to the connected server.
 func (w *Worker) SendMessageToServer(message []byte) {
+	if err := w.writeToServer(message); err != nil {
+		logger.Errorw("Failed to send message to server", "error", err)
+	}
+}
+
+func (w *Worker) writeToServer(message []byte) error {
+	w.connWriteLock.Lock()
+	defer w.connWriteLock.Unlock()
+
 	if w.conn == nil {
-		logger.Errorw("Can not send message to server because we are disconnected")
-	} else {
-		w.connWriteLock.Lock()
-		defer w.connWriteLock.Unlock()
+		return errors.New("disconnected from server")
+	}
+
+	return w.conn.WriteMessage(message)
+}
+
+// sendToServer sends a message to the connected server, in the envelope encoding negotiated with it.
+func (w *Worker) sendToServer(kind string, payload interface{}) error {
+	w.connWriteLock.Lock()
+	encoding := w.envelopeEncoding
+	w.connWriteLock.Unlock()
 
-		w.conn.WriteMessage(websocket.TextMessage, message)
+	message, err := messages.Encode(encoding, kind, payload)
+	if err != nil {
+		logger.Errorw("Failed to encode a message to server", "kind", kind, "error", err)
+		return err
+	}
+
+	if err := w.writeToServer(message); err != nil {
+		logger.Errorw("Failed to send message to server", "kind", kind, "error", err)
+		return err
 	}
+
+	return nil
+}
+
+// setEnvelopeEncoding sets the envelope encoding of the messages sent on the current connection.
+func (w *Worker) setEnvelopeEncoding(encoding string) {
+	w.connWriteLock.Lock()
+	defer w.connWriteLock.Unlock()
+
+	w.envelopeEncoding = encoding
 }
 
 // GetMessageHandler returns the registered message handler.
@@ -146,7 +421,8 @@ func (w *Worker) SetMessageHandler(h MessageHandler) {
 	w.messageHandler = h
 }
 
-// AddConnectedCallback registers a callback function that will be called on connected to server.
+// AddConnectedCallback registers a callback function that will be called on joining a server, once it welcomed
+// the worker.
 func (w *Worker) AddConnectedCallback(f func()) {
 	w.connectedCallbacks = append(w.connectedCallbacks, f)
 }
@@ -156,85 +432,168 @@ func (h *defaultMessageHandler) HandleMessage(message []byte) {
 		return
 	}
 
-	var envelope messages.Envelope
-	err := json.Unmarshal(message, &envelope)
-
 	logger.Debugw("Received message from server", "message", string(message))
 
+	kind, payload, err := messages.Decode(message)
 	if err != nil {
-		logger.Errorw("Failed to unmarshal a message from server", "message", string(message))
+		logger.Errorw("Failed to unmarshal a message from server", "message", string(message), "error", err)
 		return
 	}
 
-	if envelope.Kind == messages.KindStartLoadTestRequest {
-		var req messages.StartLoadTestRequest
-		err = json.Unmarshal([]byte(envelope.Data), &req)
+	// Commands with an ID are acknowledged, rejecting invalid ones.
+	id := messages.IDOf(message)
+
+	switch req := payload.(type) {
+	case *messages.StartLoadTestRequest:
+		pacer, duration, err := newPacer(req)
+		if err != nil {
+			logger.Errorw("Invalid load test request", "request", req, "error", err)
+			h.worker.acknowledge(id, err)
+			return
+		}
 
+		targeter, err := newTargeter(req, h.worker.name, h.worker.bodiesDir)
 		if err != nil {
-			logger.Errorw("Failed to unmarshal a message from server", "message", string(message))
+			logger.Errorw("Invalid load test request", "request", req, "error", err)
+			h.worker.acknowledge(id, err)
 			return
 		}
 
-		header := http.Header{}
-		for _, line := range strings.Split(req.Header, "\n") {
-			parts := strings.Split(line, ":")
+		results, err := newResultsWriter(req, h.worker.resultsDir, h.worker.name)
+		if err != nil {
+			logger.Errorw("Invalid load test request", "request", req, "error", err)
+			h.worker.acknowledge(id, err)
+			return
+		}
 
-			if len(parts) != 2 {
-				continue
-			}
+		logger.Infow("Starting load test", "request", req)
 
-			key := parts[0]
-			value := strings.TrimLeft(parts[1], " ")
+		// The results of a load test still running must not end up with the new one.
+		h.worker.stopPreviousLoadTest()
+		h.worker.resetLoadTest()
 
-			header.Add(key, value)
+		// The load test is running from now on, so that a stop request received right away stops it.
+		h.worker.loadTestLock.Lock()
+		h.worker.loadTestState = messages.WorkerStateRunning
+		h.worker.runID = req.RunID
+		h.worker.runWorkerID = req.WorkerID
+		h.worker.pacer = pacer
+		h.worker.results = results
+		h.worker.attackDone = make(chan struct{})
+		h.worker.loadTestLock.Unlock()
+
+		go h.worker.startLoadTest(targeter, pacer, duration, "terjang")
+
+		h.worker.acknowledge(id, nil)
+	case *messages.RebalanceLoadTestRequest:
+		h.worker.loadTestLock.Lock()
+		pacer := h.worker.pacer
+		running := pacer != nil && req.RunID == h.worker.runID && h.worker.loadTestState == messages.WorkerStateRunning
+		h.worker.loadTestLock.Unlock()
+
+		if !running {
+			h.worker.acknowledge(id, fmt.Errorf("not running load test %s", req.RunID))
+			return
 		}
 
-		rate := vegeta.Rate{Freq: int(req.Rate), Per: time.Second}
-		duration := time.Duration(req.Duration) * time.Second
-		targeter := vegeta.NewStaticTargeter(vegeta.Target{
-			Method: req.Method,
-			URL:    req.URL,
-			Header: header,
-			Body:   []byte(req.Body),
-		})
-
-		logger.Infow("Starting load test", "request", &req)
+		if err := pacer.Rebalance(req.Share); err != nil {
+			logger.Errorw("Failed to rebalance load test", "error", err)
+			h.worker.acknowledge(id, err)
+			return
+		}
 
-		h.worker.resetLoadTest()
-		go h.worker.startLoadTest(targeter, rate, duration, "terjang")
-	} else if envelope.Kind == messages.KindStopLoadTestRequest {
+		logger.Infow("Rebalanced load test", "share", req.Share)
+		h.worker.acknowledge(id, nil)
+	}
 
+	switch kind {
+	case messages.KindStopLoadTestRequest:
 		logger.Infow("Stopping load test")
 		h.worker.stopLoadTest()
+		h.worker.acknowledge(id, nil)
+	case messages.KindRemoveWorkerRequest:
+		logger.Infow("Removed from the cluster by the server")
+		h.worker.Close()
 	}
 }
 
 func (w *Worker) resetLoadTest() {
-	w.attacker = vegeta.NewAttacker(
+	attacker := vegeta.NewAttacker(
 		vegeta.KeepAlive(true),
 		vegeta.HTTP2(true),
 		vegeta.H2C(false),
 	)
 
+	w.loadTestLock.Lock()
+	w.attacker = attacker
+	w.loadTestLock.Unlock()
+
 	w.metricsLock.Lock()
 	w.metrics = vegeta.Metrics{}
+	w.latencies = tdigest.NewWithCompression(messages.LatencyCompression)
+	w.intervals = newIntervalRecorder()
+	w.unsentIntervals = nil
 	w.metricsLock.Unlock()
 }
 
 func (w *Worker) startLoadTest(tr vegeta.Targeter, p vegeta.Pacer, du time.Duration, name string) {
-	w.loadTestState = messages.WorkerStateRunning
 	w.sendWorkerInfoToServer()
 
-	for res := range w.attacker.Attack(tr, p, du, "terjang") {
+	w.loadTestLock.Lock()
+	attacker := w.attacker
+	results := w.results
+	done := w.attackDone
+	w.loadTestLock.Unlock()
+
+	defer close(done)
+
+	// Full batches of results are sent in the background, so sending does not slow down the attack.
+	flush := make(chan struct{}, 1)
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+
+		for range flush {
+			w.sendResultsToServer(results)
+		}
+	}()
+
+	for res := range attacker.Attack(tr, p, du, "terjang") {
 		w.metricsLock.Lock()
 		w.metrics.Add(res)
+		w.latencies.Add(float64(res.Latency), 1)
+		w.intervals.Add(res)
 		w.metricsLock.Unlock()
+
+		if results != nil {
+			if full, err := results.Add(res); err != nil {
+				logger.Errorw("Failed to encode result", "error", err)
+			} else if full {
+				select {
+				case flush <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+
+	close(flush)
+	<-flushed
+
+	if results != nil {
+		w.sendResultsToServer(results)
+
+		if err := results.Close(); err != nil {
+			logger.Errorw("Failed to write results file", "error", err)
+		}
 	}
 
 	// Preserves state if it's stopped
+	w.loadTestLock.Lock()
 	if w.loadTestState != messages.WorkerStateStopped {
 		w.loadTestState = messages.WorkerStateDone
 	}
+	w.loadTestLock.Unlock()
 
 	w.sendWorkerInfoToServer()
 
@@ -242,29 +601,87 @@ func (w *Worker) startLoadTest(tr vegeta.Targeter, p vegeta.Pacer, du time.Durat
 }
 
 func (w *Worker) stopLoadTest() {
+	w.loadTestLock.Lock()
 	w.loadTestState = messages.WorkerStateStopped
-	w.attacker.Stop()
+	attacker := w.attacker
+	w.loadTestLock.Unlock()
+
+	if attacker != nil {
+		attacker.Stop()
+	}
+}
+
+// stopPreviousLoadTest stops the attack of the previous load test if it is still running, and waits for it
+// to finish.
+func (w *Worker) stopPreviousLoadTest() {
+	w.loadTestLock.Lock()
+	done := w.attackDone
+	running := w.loadTestState == messages.WorkerStateRunning
+	w.loadTestLock.Unlock()
+
+	if done == nil {
+		return
+	}
+
+	if running {
+		logger.Infow("Stopping the previous load test")
+		w.stopLoadTest()
+	}
+
+	<-done
+}
+
+// loadTestInfo returns the state of the load test and its run.
+func (w *Worker) loadTestInfo() (messages.WorkerState, string) {
+	w.loadTestLock.Lock()
+	defer w.loadTestLock.Unlock()
+
+	return w.loadTestState, w.runID
 }
 
 // LoopSendMetricsToServer is the loop function that sends metrics to server every second.
 func (w *Worker) LoopSendMetricsToServer() {
+	w.loopSendMetricsToServer(nil)
+}
+
+// loopSendMetricsToServer sends metrics to server every second until done is closed. Metrics of the seconds
+// elapsed while disconnected are kept, and sent once reconnected.
+func (w *Worker) loopSendMetricsToServer(done <-chan struct{}) {
 	for {
-		if w.loadTestState == messages.WorkerStateRunning || w.loadTestState == messages.WorkerStateDone {
+		w.loadTestLock.Lock()
+		state, results := w.loadTestState, w.results
+		w.loadTestLock.Unlock()
+
+		if state == messages.WorkerStateRunning || state == messages.WorkerStateDone {
 			w.SendMetricsToServer()
 		}
 
-		time.Sleep(1 * time.Second)
+		// Batches that failed to be sent, e.g. while disconnected, are sent again once the load test finished.
+		if results != nil && state != messages.WorkerStateNotStarted {
+			w.sendResultsToServer(results)
+		}
+
+		select {
+		case <-time.After(1 * time.Second):
+		case <-done:
+			return
+		}
 	}
 }
 
 // SendMetricsToServer sends metrics to the server.
 func (w *Worker) SendMetricsToServer() {
+	// While running, the current second is still being filled. Once finished, every interval is complete.
+	flushBefore := time.Now().Truncate(time.Second)
+	if state, _ := w.loadTestInfo(); state != messages.WorkerStateRunning {
+		flushBefore = time.Now().Add(time.Second)
+	}
+
 	w.metricsLock.Lock()
 	w.metrics.Close()
-	w.metricsLock.Unlock()
-
-	w.metricsLock.RLock()
-	defer w.metricsLock.RUnlock()
+	latencySketch := messages.NewLatencySketch(w.latencies)
+	intervals := append(w.unsentIntervals, w.intervals.Flush(flushBefore)...)
+	w.unsentIntervals = nil
 
 	workerMetrics := messages.WorkerLoadTestMetrics{}
 	workerMetrics.Duration = w.metrics.Duration
@@ -276,22 +693,50 @@ func (w *Worker) SendMetricsToServer() {
 	workerMetrics.Latencies = w.metrics.Latencies
 	workerMetrics.BytesIn = w.metrics.BytesIn
 	workerMetrics.BytesOut = w.metrics.BytesOut
-	workerMetrics.StatusCodes = w.metrics.StatusCodes
-	workerMetrics.Errors = w.metrics.Errors
-
-	metrics, _ := json.Marshal(workerMetrics)
-	envelope := messages.Envelope{Kind: messages.KindWorkerLoadTestMetrics, Data: string(metrics)}
+	// The attack keeps adding to the status codes and errors once unlocked, they are encoded from copies.
+	workerMetrics.StatusCodes = make(map[string]int, len(w.metrics.StatusCodes))
+	for code, n := range w.metrics.StatusCodes {
+		workerMetrics.StatusCodes[code] = n
+	}
+	workerMetrics.Errors = append(w.metrics.Errors[:0:0], w.metrics.Errors...)
+	workerMetrics.LatencySketch = latencySketch
+	workerMetrics.Intervals = intervals
+	w.metricsLock.Unlock()
 
-	msg, _ := json.Marshal(envelope)
-	w.SendMessageToServer(msg)
+	// Intervals are only reported once, those that could not be sent go with the next metrics.
+	if err := w.sendToServer(messages.KindWorkerLoadTestMetrics, &workerMetrics); err != nil {
+		w.metricsLock.Lock()
+		w.unsentIntervals = append(intervals, w.unsentIntervals...)
+		w.metricsLock.Unlock()
+	}
 }
 
 func (w *Worker) sendWorkerInfoToServer() {
-	workerInfo := &messages.WorkerInfo{State: w.loadTestState}
-	workerInfoJSON, _ := json.Marshal(workerInfo)
+	w.loadTestLock.Lock()
+	info := &messages.WorkerInfo{State: w.loadTestState, RunID: w.runID, WorkerID: w.runWorkerID}
+	w.loadTestLock.Unlock()
 
-	envelope := &messages.Envelope{Kind: messages.KindWorkerInfo, Data: string(workerInfoJSON)}
-	envelopeJSON, _ := json.Marshal(envelope)
+	w.sendToServer(messages.KindWorkerInfo, info)
+}
+
+// acknowledge replies to a command of the server, rejecting it when err is not nil. Commands without an ID,
+// from servers older than acknowledgements, are not acknowledged.
+func (w *Worker) acknowledge(id string, err error) {
+	if id == "" {
+		return
+	}
+
+	ack := &messages.Ack{ID: id}
+	if err != nil {
+		ack.Error = err.Error()
+	}
+
+	w.sendToServer(messages.KindAck, ack)
+}
 
-	w.SendMessageToServer(envelopeJSON)
+// sendResultsToServer sends the raw results encoded since the previous batch, or the batches that failed to be sent.
+func (w *Worker) sendResultsToServer(results *resultsWriter) {
+	results.Flush(func(batch []byte, seq uint64) error {
+		return w.sendToServer(messages.KindWorkerResults, &messages.WorkerResults{RunID: results.runID, Seq: seq, Data: batch})
+	})
 }
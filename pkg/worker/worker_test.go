@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffJitterBounds(t *testing.T) {
+	w := NewWorker()
+	w.SetConnectRetryInterval(100 * time.Millisecond)
+	w.SetMaxConnectRetryInterval(1 * time.Second)
+
+	tests := []struct {
+		attempt int
+		delay   time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{2, 400 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, 1 * time.Second},
+		{100, 1 * time.Second},
+	}
+
+	random := rand.New(rand.NewSource(1))
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.attempt), func(t *testing.T) {
+			// The jitter takes up to half of the delay off.
+			for i := 0; i < 1000; i++ {
+				d := w.backoff(tt.attempt, random)
+				assert.GreaterOrEqual(t, int64(d), int64(tt.delay/2))
+				assert.Less(t, int64(d), int64(tt.delay))
+			}
+		})
+	}
+}
+
+func TestBackoffWithoutRetryInterval(t *testing.T) {
+	w := NewWorker()
+	w.SetConnectRetryInterval(0)
+
+	assert.Equal(t, time.Duration(0), w.backoff(3, rand.New(rand.NewSource(1))))
+}
@@ -0,0 +1,180 @@
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/audit"
+	"github.com/andylibrian/terjang/pkg/client"
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// answerCommands acknowledges the commands received by a raw worker, rejecting them with rejection if not empty.
+func answerCommands(conn *websocket.Conn, rejection string) {
+	go func() {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			id := messages.IDOf(message)
+			if id == "" {
+				continue
+			}
+
+			ack, _ := messages.Encode(messages.EnvelopeEncodingJSONString, messages.KindAck,
+				&messages.Ack{ID: id, Error: rejection})
+			conn.WriteMessage(websocket.TextMessage, ack)
+		}
+	}()
+}
+
+// startAckWorker starts a worker that stops with the test.
+func startAckWorker(t *testing.T, addr string, name string) {
+	w := worker.NewWorker()
+	w.SetName(name)
+	w.SetConnectRetryInterval(connectRetryInterval)
+	t.Cleanup(w.Close)
+
+	connected := make(chan struct{}, 1)
+	w.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go w.Run(addr)
+	<-connected
+}
+
+func TestStartLoadTestReportsWorkerAcks(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10310")
+
+	srv := server.NewServer()
+	srv.SetAckTimeout(500 * time.Millisecond)
+	go srv.Run("127.0.0.1:9299")
+	defer srv.Close()
+
+	startAckWorker(t, "127.0.0.1:9299", "accepting")
+
+	hello := messages.Hello{
+		ProtocolVersion: messages.ProtocolVersion,
+		Features:        messages.Features{Pacers: []string{messages.PacerConstant}},
+	}
+
+	rejecting := dialWorker(t, "127.0.0.1:9299", "rejecting")
+	sendHello(t, rejecting, hello)
+	answerCommands(rejecting, "out of file descriptors")
+
+	silent := dialWorker(t, "127.0.0.1:9299", "silent")
+	sendHello(t, silent, hello)
+
+	// A worker older than acknowledgements.
+	dialWorker(t, "127.0.0.1:9299", "legacy")
+	time.Sleep(200 * time.Millisecond)
+
+	cl := client.NewClient("127.0.0.1:9299")
+	run, err := cl.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10310/hello",
+		Duration: 1,
+		Rate:     10,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []messages.WorkerAck{
+		{Name: "accepting", Status: messages.AckStatusAccepted},
+		{Name: "legacy", Status: messages.AckStatusUnacknowledged},
+		{Name: "rejecting", Status: messages.AckStatusRejected, Error: "out of file descriptors"},
+		{Name: "silent", Status: messages.AckStatusTimedOut},
+	}, run.Acks)
+	assert.Equal(t, "Running", run.State)
+
+	// The rejecting worker left the run.
+	events, err := cl.GetAuditLog(audit.Query{Action: audit.ActionStartLoadTest})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, []string{"accepting", "legacy", "silent"}, events[0].Workers)
+
+	saved, err := cl.GetRun(run.ID)
+	require.NoError(t, err)
+	assert.Equal(t, run.Acks, saved.Acks)
+}
+
+func TestInvalidLoadTestIsRejectedByWorkers(t *testing.T) {
+	srv := server.NewServer()
+	go srv.Run("127.0.0.1:9309")
+	defer srv.Close()
+
+	startAckWorker(t, "127.0.0.1:9309", "worker1")
+	startAckWorker(t, "127.0.0.1:9309", "worker2")
+
+	cl := client.NewClient("127.0.0.1:9309")
+	run, err := cl.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "127.0.0.1:10320/hello",
+		Duration: 1,
+		Rate:     10,
+	})
+	require.NoError(t, err)
+
+	require.Len(t, run.Acks, 2)
+	for _, ack := range run.Acks {
+		assert.Equal(t, messages.AckStatusRejected, ack.Status)
+		assert.Contains(t, ack.Error, "invalid URL")
+	}
+
+	// The load test is stopped rather than reported as running.
+	assert.Equal(t, "Stopped", run.State)
+	assert.NotNil(t, run.EndedAt)
+
+	events, err := cl.GetAuditLog(audit.Query{Action: audit.ActionStopLoadTest})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "no worker accepted the load test", events[0].Reason)
+}
+
+func TestStopLoadTestReportsWorkerAcks(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10340")
+
+	srv := server.NewServer()
+	srv.SetAckTimeout(500 * time.Millisecond)
+	go srv.Run("127.0.0.1:9339")
+	defer srv.Close()
+
+	startAckWorker(t, "127.0.0.1:9339", "accepting")
+
+	silent := dialWorker(t, "127.0.0.1:9339", "silent")
+	sendHello(t, silent, messages.Hello{
+		ProtocolVersion: messages.ProtocolVersion,
+		Features:        messages.Features{Pacers: []string{messages.PacerConstant}},
+	})
+
+	// A worker older than acknowledgements.
+	dialWorker(t, "127.0.0.1:9339", "legacy")
+	time.Sleep(200 * time.Millisecond)
+
+	cl := client.NewClient("127.0.0.1:9339")
+	_, err := cl.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10340/hello",
+		Duration: 10,
+		Rate:     10,
+	})
+	require.NoError(t, err)
+
+	acks, err := cl.StopLoadTest()
+	require.NoError(t, err)
+
+	assert.Equal(t, []messages.WorkerAck{
+		{Name: "accepting", Status: messages.AckStatusAccepted},
+		{Name: "legacy", Status: messages.AckStatusUnacknowledged},
+		{Name: "silent", Status: messages.AckStatusTimedOut},
+	}, acks)
+}
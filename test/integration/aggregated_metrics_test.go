@@ -0,0 +1,80 @@
+package integration
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleLoadTestMetrics(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10100")
+
+	server := server.NewServer()
+	go server.Run("127.0.0.1:9059")
+	defer server.Close()
+
+	connected := make(chan struct{})
+
+	for _, name := range []string{"worker1", "worker2"} {
+		w := worker.NewWorker()
+		w.SetName(name)
+		w.SetConnectRetryInterval(connectRetryInterval)
+		w.AddConnectedCallback(func() {
+			connected <- struct{}{}
+		})
+
+		go w.Run("127.0.0.1:9059")
+		defer w.Close()
+	}
+
+	<-connected
+	<-connected
+
+	duration := 1
+	rate := 10
+	server.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10100/hello",
+		Duration: uint64(duration),
+		Rate:     uint64(rate),
+	})
+
+	// Wait for the load test to complete and the final metrics to be reported.
+	time.Sleep(time.Duration(duration)*time.Second + 1500*time.Millisecond)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/load_test/metrics?quantiles=0.999,0.9999", nil)
+	w := httptest.NewRecorder()
+
+	server.HandleLoadTestMetrics(w, req, nil)
+
+	resp := w.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	var metrics messages.LoadTestMetrics
+	require.NoError(t, json.Unmarshal(bodyBytes, &metrics))
+
+	assert.Equal(t, 2, metrics.NumOfWorkers)
+	assert.Equal(t, uint64(2*duration*rate), metrics.Requests)
+	assert.Equal(t, float64(1), metrics.Success)
+	assert.Equal(t, 2*duration*rate, metrics.StatusCodes["200"])
+	assert.Greater(t, int64(metrics.Latencies.P99), int64(0))
+	assert.LessOrEqual(t, int64(metrics.Latencies.P50), int64(metrics.Latencies.Max))
+
+	require.NotNil(t, metrics.LatencySketch)
+	assert.Contains(t, metrics.Quantiles, "0.999")
+	assert.Contains(t, metrics.Quantiles, "0.9999")
+	assert.LessOrEqual(t, int64(metrics.Latencies.P99), int64(metrics.Quantiles["0.999"]))
+}
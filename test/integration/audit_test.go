@@ -0,0 +1,155 @@
+package integration
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/audit"
+	"github.com/andylibrian/terjang/pkg/client"
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10260")
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := audit.NewFileLog(path)
+	require.NoError(t, err)
+	defer auditLog.Close()
+
+	srv := server.NewServer()
+	srv.SetAuditLog(auditLog)
+	srv.AddUser(server.User{Name: "otto", Token: "operator-token", Role: server.RoleOperator})
+	srv.AddUser(server.User{Name: "ada", Token: "admin-token", Role: server.RoleAdmin})
+
+	go srv.Run("127.0.0.1:9229")
+	defer srv.Close()
+
+	w := worker.NewWorker()
+	w.SetName("worker1")
+	w.SetConnectRetryInterval(connectRetryInterval)
+
+	// Wait for worker to be connected
+	connected := make(chan struct{})
+	w.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go w.Run("127.0.0.1:9229")
+	defer w.Close()
+	<-connected
+
+	operator := client.NewClient("127.0.0.1:9229")
+	operator.SetToken("operator-token")
+
+	admin := client.NewClient("127.0.0.1:9229")
+	admin.SetToken("admin-token")
+
+	// Started and stopped by an operator.
+	first, err := operator.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10260/hello",
+		Duration: 10,
+		Rate:     10,
+	})
+	require.NoError(t, err)
+
+	time.Sleep(500 * time.Millisecond)
+	_, err = operator.StopLoadTest()
+	require.NoError(t, err)
+	time.Sleep(2 * time.Second)
+
+	// Aborted by the server on a threshold breach.
+	second := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:        "GET",
+		URL:           "http://127.0.0.1:10260/hello",
+		Duration:      10,
+		Rate:          10,
+		Thresholds:    []string{"p99 < 1ns"},
+		AbortOnBreach: true,
+	})
+	time.Sleep(3 * time.Second)
+
+	// Stopped when its only worker is removed by an admin.
+	third, err := operator.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10260/hello",
+		Duration: 10,
+		Rate:     10,
+	})
+	require.NoError(t, err)
+
+	time.Sleep(500 * time.Millisecond)
+	require.NoError(t, admin.RemoveWorker("worker1"))
+	time.Sleep(500 * time.Millisecond)
+
+	events, err := admin.GetAuditLog(audit.Query{})
+	require.NoError(t, err)
+
+	var summary []string
+	for _, e := range events {
+		summary = append(summary, e.Action+" "+e.User+" "+e.RunID+" "+strings.Join(e.Workers, ",")+" "+e.Reason)
+	}
+	assert.Equal(t, []string{
+		"load_test.start otto " + first.ID + " worker1 ",
+		"load_test.stop otto " + first.ID + " worker1 ",
+		"load_test.start system " + second.ID + " worker1 ",
+		"load_test.stop system " + second.ID + " worker1 threshold breached: p99 < 1ns",
+		"load_test.start otto " + third.ID + " worker1 ",
+		"worker.remove ada  worker1 ",
+		"load_test.stop system " + third.ID + "  no workers remaining",
+	}, summary)
+
+	start := events[0]
+	assert.Equal(t, "operator", start.Role)
+	assert.True(t, strings.HasPrefix(start.RemoteAddr, "127.0.0.1:"))
+	require.NotNil(t, start.Request)
+	assert.Equal(t, "http://127.0.0.1:10260/hello", start.Request.URL)
+	assert.Equal(t, first.ID, start.Request.RunID)
+
+	// Queries.
+	starts, err := admin.GetAuditLog(audit.Query{Action: audit.ActionStartLoadTest, User: "otto"})
+	require.NoError(t, err)
+	assert.Len(t, starts, 2)
+
+	last, err := admin.GetAuditLog(audit.Query{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, last, 1)
+	assert.Equal(t, "no workers remaining", last[0].Reason)
+
+	byRun, err := admin.GetAuditLog(audit.Query{RunID: second.ID})
+	require.NoError(t, err)
+	assert.Len(t, byRun, 2)
+
+	future, err := admin.GetAuditLog(audit.Query{Since: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	assert.Empty(t, future)
+
+	_, err = operator.GetAuditLog(audit.Query{})
+	assert.Error(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, "http://127.0.0.1:9229/api/v1/audit?since=yesterday", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Events are persisted.
+	reopened, err := audit.NewFileLog(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	persisted, err := reopened.Query(audit.Query{})
+	require.NoError(t, err)
+	assert.Len(t, persisted, len(events))
+}
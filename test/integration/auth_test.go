@@ -0,0 +1,155 @@
+package integration
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/client"
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthentication(t *testing.T) {
+	srv := server.NewServer()
+	srv.SetClusterSecret("cluster-secret")
+	srv.AddAPIToken("token1")
+	srv.AddAPIToken("token2")
+	srv.SetAllowedOrigins([]string{"http://localhost:8080"})
+
+	go srv.Run("127.0.0.1:9199")
+	defer srv.Close()
+
+	// Wait for the server to listen.
+	time.Sleep(100 * time.Millisecond)
+
+	// Workers need the cluster secret to join.
+	_, resp, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:9199/cluster/join?name=intruder", nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	header := http.Header{"Authorization": {"Bearer token1"}}
+	_, resp, err = websocket.DefaultDialer.Dial("ws://127.0.0.1:9199/cluster/join?name=intruder", header)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	w := worker.NewWorker()
+	w.SetName("worker1")
+	w.SetClusterSecret("cluster-secret")
+	w.SetConnectRetryInterval(connectRetryInterval)
+
+	// Wait for worker to be connected
+	connected := make(chan struct{})
+	w.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go w.Run("127.0.0.1:9199")
+	defer w.Close()
+	<-connected
+
+	// The API needs one of the tokens.
+	for _, c := range []struct {
+		url    string
+		header string
+		status int
+	}{
+		{"http://127.0.0.1:9199/api/v1/server_info", "", http.StatusUnauthorized},
+		{"http://127.0.0.1:9199/api/v1/server_info", "Bearer wrong", http.StatusUnauthorized},
+		{"http://127.0.0.1:9199/api/v1/server_info", "Bearer cluster-secret", http.StatusUnauthorized},
+		{"http://127.0.0.1:9199/api/v1/server_info", "Bearer token1", http.StatusOK},
+		{"http://127.0.0.1:9199/api/v1/server_info", "bearer token2", http.StatusOK},
+		{"http://127.0.0.1:9199/api/v1/server_info?access_token=token2", "", http.StatusUnauthorized},
+		{"http://127.0.0.1:9199/notifications?access_token=token2", "", http.StatusUnauthorized},
+		{"http://127.0.0.1:9199/metrics", "", http.StatusUnauthorized},
+		{"http://127.0.0.1:9199/metrics", "Bearer token1", http.StatusOK},
+		{"http://127.0.0.1:9199/healthz", "", http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodGet, c.url, nil)
+		require.NoError(t, err)
+		if c.header != "" {
+			req.Header.Set("Authorization", c.header)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, c.status, resp.StatusCode, "%s with %q", c.url, c.header)
+	}
+
+	anonymous := client.NewClient("127.0.0.1:9199")
+	_, err = anonymous.StartLoadTest(&messages.StartLoadTestRequest{Method: "GET", URL: "http://127.0.0.1:10230/", Duration: 1, Rate: 1})
+	assert.Error(t, err)
+	assert.Error(t, anonymous.Follow(context.Background(), func(messages.Envelope) {}))
+
+	authenticated := client.NewClient("127.0.0.1:9199")
+	authenticated.SetToken("token1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	notified := false
+	authenticated.Follow(ctx, func(messages.Envelope) {
+		notified = true
+		cancel()
+	})
+	assert.True(t, notified)
+
+	// Browsers send the token as a websocket subprotocol.
+	dialer := websocket.Dialer{Subprotocols: []string{"terjang", "base64url.bearer.terjang." + base64.RawURLEncoding.EncodeToString([]byte("token1"))}}
+	conn, _, err := dialer.Dial("ws://127.0.0.1:9199/notifications", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "terjang", conn.Subprotocol())
+	conn.Close()
+
+	dialer.Subprotocols = []string{"terjang", "base64url.bearer.terjang." + base64.RawURLEncoding.EncodeToString([]byte("wrong"))}
+	_, _, err = dialer.Dial("ws://127.0.0.1:9199/notifications", nil)
+	assert.Error(t, err)
+
+	// Browsers can only subscribe from the server's origin or the allowed ones.
+	for _, c := range []struct {
+		origin string
+		ok     bool
+	}{
+		{"http://127.0.0.1:9199", true},
+		{"http://localhost:8080", true},
+		{"http://evil.example.com", false},
+	} {
+		header := http.Header{"Origin": {c.origin}}
+		conn, _, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:9199/notifications?access_token=token1", header)
+		if c.ok {
+			require.NoError(t, err, c.origin)
+			conn.Close()
+		} else {
+			assert.Error(t, err, c.origin)
+		}
+	}
+
+	// Preflight requests of allowed origins may send the token.
+	req, err := http.NewRequest(http.MethodOptions, "http://127.0.0.1:9199/api/v1/load_test", nil)
+	require.NoError(t, err)
+	req.Header.Set("Origin", "http://localhost:8080")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "authorization")
+
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "http://localhost:8080", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, resp.Header.Get("Access-Control-Allow-Headers"), "Authorization")
+
+	req.Header.Set("Origin", "http://evil.example.com")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+}
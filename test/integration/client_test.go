@@ -0,0 +1,87 @@
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/client"
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientRunsLoadTest(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10160")
+
+	server := server.NewServer()
+	go server.Run("127.0.0.1:9129")
+	defer server.Close()
+
+	worker := worker.NewWorker()
+	worker.SetConnectRetryInterval(connectRetryInterval)
+
+	// Wait for worker to be connected
+	connected := make(chan struct{})
+	worker.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go worker.Run("127.0.0.1:9129")
+	defer worker.Close()
+	<-connected
+
+	c := client.NewClient("127.0.0.1:9129")
+
+	run, err := c.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:     "GET",
+		URL:        "http://127.0.0.1:10160/hello",
+		Duration:   2,
+		Rate:       10,
+		Thresholds: []string{"success > 99%", "p99 < 10ms"},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, run.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	progressCount := 0
+	final, err := c.WaitForRun(ctx, run.ID, func(m messages.LoadTestMetrics) {
+		progressCount++
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, run.ID, final.ID)
+	assert.Equal(t, "Done", final.State)
+	assert.Equal(t, uint64(20), final.Metrics.Requests)
+	assert.Greater(t, progressCount, 1)
+
+	require.NotNil(t, final.Verdict)
+	assert.True(t, final.Verdict.Passed)
+}
+
+func TestClientReportsServerErrors(t *testing.T) {
+	server := server.NewServer()
+	go server.Run("127.0.0.1:9129")
+	defer server.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	c := client.NewClient("127.0.0.1:9129")
+
+	_, err := c.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:     "GET",
+		URL:        "http://127.0.0.1:10161/hello",
+		Duration:   1,
+		Rate:       1,
+		Thresholds: []string{"p99 <"},
+	})
+	assert.Error(t, err)
+
+	_, err = c.GetRun("unknown")
+	assert.Error(t, err)
+}
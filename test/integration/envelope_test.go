@@ -0,0 +1,118 @@
+package integration
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readKind returns the next message of a kind, skipping the others.
+func readKind(t *testing.T, conn *websocket.Conn, kind string) []byte {
+	for {
+		_, message, err := conn.ReadMessage()
+		require.NoError(t, err)
+
+		if messages.KindOf(message) == kind {
+			return message
+		}
+	}
+}
+
+func TestEnvelopeEncodings(t *testing.T) {
+	metrics := &messages.WorkerLoadTestMetrics{
+		Requests:    100,
+		Success:     1,
+		StatusCodes: map[string]int{"200": 100},
+		Intervals:   []messages.MetricsInterval{{Timestamp: time.Unix(1600000000, 0).UTC(), Requests: 100}},
+	}
+
+	var sizes []int
+	for _, encoding := range []string{messages.EnvelopeEncodingJSONString, messages.EnvelopeEncodingJSON} {
+		message, err := messages.Encode(encoding, messages.KindWorkerLoadTestMetrics, metrics)
+		require.NoError(t, err)
+		sizes = append(sizes, len(message))
+
+		assert.Equal(t, messages.KindWorkerLoadTestMetrics, messages.KindOf(message))
+
+		kind, payload, err := messages.Decode(message)
+		require.NoError(t, err)
+		assert.Equal(t, messages.KindWorkerLoadTestMetrics, kind)
+		assert.Equal(t, metrics, payload)
+
+		// Kinds without data decode to a nil payload.
+		message, err = messages.Encode(encoding, messages.KindStopLoadTestRequest, nil)
+		require.NoError(t, err)
+
+		kind, payload, err = messages.Decode(message)
+		require.NoError(t, err)
+		assert.Equal(t, messages.KindStopLoadTestRequest, kind)
+		assert.Nil(t, payload)
+	}
+
+	// The data is no longer escaped.
+	assert.Less(t, sizes[1], sizes[0])
+
+	assert.Equal(t, messages.EnvelopeEncodingJSON, messages.NegotiateEnvelopeEncoding([]string{"msgpack", "json"}))
+	assert.Equal(t, messages.EnvelopeEncodingJSONString, messages.NegotiateEnvelopeEncoding(nil))
+}
+
+func TestEnvelopeEncodingIsNegotiatedPerWorker(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10300")
+
+	srv := server.NewServer()
+	// The raw workers do not acknowledge load tests.
+	srv.SetAckTimeout(100 * time.Millisecond)
+	go srv.Run("127.0.0.1:9289")
+	defer srv.Close()
+
+	current := dialWorker(t, "127.0.0.1:9289", "current")
+	sendHello(t, current, messages.Hello{
+		ProtocolVersion:   messages.ProtocolVersion,
+		Features:          messages.Features{Pacers: []string{messages.PacerConstant}},
+		EnvelopeEncodings: []string{"msgpack", messages.EnvelopeEncodingJSON},
+	})
+
+	_, payload, err := messages.Decode(readKind(t, current, messages.KindWelcome))
+	require.NoError(t, err)
+	assert.Equal(t, messages.EnvelopeEncodingJSON, payload.(*messages.Welcome).EnvelopeEncoding)
+
+	// A worker that does not offer envelope encodings keeps the original one.
+	older := dialWorker(t, "127.0.0.1:9289", "older")
+	sendHello(t, older, messages.Hello{
+		ProtocolVersion: messages.ProtocolVersion,
+		Features:        messages.Features{Pacers: []string{messages.PacerConstant}},
+	})
+
+	_, payload, err = messages.Decode(readKind(t, older, messages.KindWelcome))
+	require.NoError(t, err)
+	assert.Equal(t, messages.EnvelopeEncodingJSONString, payload.(*messages.Welcome).EnvelopeEncoding)
+
+	srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10300/hello",
+		Duration: 1,
+		Rate:     10,
+		RateMode: messages.RateModeTotal,
+	})
+
+	message := readKind(t, current, messages.KindStartLoadTestRequest)
+	assert.True(t, bytes.Contains(message, []byte(`"data":{`)), string(message))
+
+	_, payload, err = messages.Decode(message)
+	require.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:10300/hello", payload.(*messages.StartLoadTestRequest).URL)
+
+	message = readKind(t, older, messages.KindStartLoadTestRequest)
+	assert.True(t, bytes.Contains(message, []byte(`"data":"{`)), string(message))
+
+	_, payload, err = messages.Decode(message)
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, payload.(*messages.StartLoadTestRequest).Share)
+}
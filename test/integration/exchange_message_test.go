@@ -1,14 +1,14 @@
 package integration
 
 import (
-	"encoding/json"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/andylibrian/terjang/pkg/messages"
 	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/transport"
 	"github.com/andylibrian/terjang/pkg/worker"
-	"github.com/gorilla/websocket"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -18,42 +18,61 @@ type serverMessageHandlerStub struct {
 	messageCount        int
 	metricsMessageCount int
 	lastMetrics         *messages.WorkerLoadTestMetrics
+	lock                sync.Mutex
 }
 
-func (s *serverMessageHandlerStub) HandleMessage(conn *websocket.Conn, message []byte) {
-	s.messageCount++
-
-	var envelope messages.Envelope
-	json.Unmarshal(message, &envelope)
-
-	if envelope.Kind == messages.KindWorkerLoadTestMetrics {
-		s.metricsMessageCount++
+func (s *serverMessageHandlerStub) HandleMessage(conn transport.Conn, message []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-		var metrics messages.WorkerLoadTestMetrics
-		json.Unmarshal([]byte(envelope.Data), &metrics)
+	s.messageCount++
 
-		s.lastMetrics = &metrics
+	if _, payload, err := messages.Decode(message); err == nil {
+		if metrics, ok := payload.(*messages.WorkerLoadTestMetrics); ok {
+			s.metricsMessageCount++
+			s.lastMetrics = metrics
+		}
 	}
 }
 
 func (s *serverMessageHandlerStub) MessageCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	return s.messageCount
 }
 
 func (s *serverMessageHandlerStub) MetricsMessageCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	return s.metricsMessageCount
 }
 
+func (s *serverMessageHandlerStub) LastMetrics() *messages.WorkerLoadTestMetrics {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.lastMetrics
+}
+
 type workerMessageHandlerStub struct {
 	handlerDelegate worker.MessageHandler
 	messageCount    int
+	lock            sync.Mutex
 }
 
 func (s *workerMessageHandlerStub) HandleMessage(message []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	s.messageCount++
 }
 
 func (s *workerMessageHandlerStub) MessageCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	return s.messageCount
 }
 
@@ -76,6 +95,7 @@ func TestWorkerSendMessageToServer(t *testing.T) {
 	})
 
 	go worker.Run("127.0.0.1:9009")
+	defer worker.Close()
 	<-connected
 
 	worker.SendMessageToServer([]byte("msg1"))
@@ -110,7 +130,9 @@ func TestServerBroadcastMessagesToWorker(t *testing.T) {
 	})
 
 	go worker1.Run("127.0.0.1:9009")
+	defer worker1.Close()
 	go worker2.Run("127.0.0.1:9009")
+	defer worker2.Close()
 
 	<-connected1
 	<-connected2
@@ -0,0 +1,261 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/audit"
+	"github.com/andylibrian/terjang/pkg/client"
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dialWorker joins a server as a worker over a raw websocket, once the server listens.
+func dialWorker(t *testing.T, addr string, name string) *websocket.Conn {
+	var conn *websocket.Conn
+	var err error
+
+	for i := 0; i < 10; i++ {
+		if conn, _, err = websocket.DefaultDialer.Dial("ws://"+addr+"/cluster/join?name="+name, nil); err == nil {
+			t.Cleanup(func() { conn.Close() })
+			return conn
+		}
+
+		time.Sleep(connectRetryInterval)
+	}
+
+	require.NoError(t, err)
+
+	return nil
+}
+
+func sendHello(t *testing.T, conn *websocket.Conn, hello messages.Hello) {
+	data, _ := json.Marshal(hello)
+	envelope, _ := json.Marshal(messages.Envelope{Kind: messages.KindHello, Data: string(data)})
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, envelope))
+}
+
+// receiveKinds reads the messages of a connection in the background, and sends their kinds to the returned channel.
+func receiveKinds(conn *websocket.Conn) <-chan string {
+	kinds := make(chan string, 100)
+
+	go func() {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			var envelope messages.Envelope
+			json.Unmarshal(message, &envelope)
+			kinds <- envelope.Kind
+		}
+	}()
+
+	return kinds
+}
+
+// receivedKinds returns the kinds received for a while.
+func receivedKinds(kinds <-chan string, d time.Duration) []string {
+	var received []string
+
+	timeout := time.After(d)
+	for {
+		select {
+		case kind := <-kinds:
+			received = append(received, kind)
+		case <-timeout:
+			return received
+		}
+	}
+}
+
+func TestWorkersInfoReportsVersions(t *testing.T) {
+	srv := server.NewServer()
+	go srv.Run("127.0.0.1:9259")
+	defer srv.Close()
+
+	w := worker.NewWorker()
+	w.SetName("worker1")
+	w.SetVersion("1.2.3")
+	w.SetConnectRetryInterval(connectRetryInterval)
+
+	connected := make(chan struct{}, 1)
+	w.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go w.Run("127.0.0.1:9259")
+	defer w.Close()
+	<-connected
+
+	// A worker older than the handshake joins without a hello.
+	dialWorker(t, "127.0.0.1:9259", "legacy")
+	time.Sleep(200 * time.Millisecond)
+
+	resp, err := http.Get("http://127.0.0.1:9259/api/v1/workers_info")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var workers []struct {
+		Name            string             `json:"name"`
+		ProtocolVersion int                `json:"protocol_version"`
+		Version         string             `json:"version"`
+		Features        *messages.Features `json:"features"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&workers))
+	require.Len(t, workers, 2)
+
+	for _, wk := range workers {
+		switch wk.Name {
+		case "worker1":
+			assert.Equal(t, messages.ProtocolVersion, wk.ProtocolVersion)
+			assert.Equal(t, "1.2.3", wk.Version)
+			require.NotNil(t, wk.Features)
+			assert.Contains(t, wk.Features.Pacers, messages.PacerSine)
+			assert.Contains(t, wk.Features.Encodings, messages.ResultsEncodingCSV)
+		case "legacy":
+			assert.Equal(t, 0, wk.ProtocolVersion)
+			assert.Empty(t, wk.Version)
+			assert.Nil(t, wk.Features)
+		default:
+			t.Errorf("unexpected worker %s", wk.Name)
+		}
+	}
+}
+
+func TestIncompatibleWorkerIsRejected(t *testing.T) {
+	srv := server.NewServer()
+	srv.SetVersion("2.0.0")
+	go srv.Run("127.0.0.1:9269")
+	defer srv.Close()
+
+	conn := dialWorker(t, "127.0.0.1:9269", "old")
+	sendHello(t, conn, messages.Hello{ProtocolVersion: messages.MinProtocolVersion - 1, Version: "0.1.0"})
+
+	_, message, err := conn.ReadMessage()
+	require.NoError(t, err)
+
+	var envelope messages.Envelope
+	require.NoError(t, json.Unmarshal(message, &envelope))
+	require.Equal(t, messages.KindWelcome, envelope.Kind)
+
+	var welcome messages.Welcome
+	require.NoError(t, json.Unmarshal([]byte(envelope.Data), &welcome))
+	assert.Equal(t, "2.0.0", welcome.Version)
+	assert.Contains(t, welcome.Error, "protocol version")
+
+	// The server closes the connection.
+	_, _, err = conn.ReadMessage()
+	assert.Error(t, err)
+}
+
+func TestWorkerWithoutFeatureIsLeftOut(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10290")
+
+	srv := server.NewServer()
+	// The raw workers do not acknowledge load tests.
+	srv.SetAckTimeout(100 * time.Millisecond)
+	go srv.Run("127.0.0.1:9279")
+	defer srv.Close()
+
+	limited := dialWorker(t, "127.0.0.1:9279", "limited")
+	sendHello(t, limited, messages.Hello{
+		ProtocolVersion: messages.ProtocolVersion,
+		Features:        messages.Features{Pacers: []string{messages.PacerConstant}},
+	})
+	kinds := receiveKinds(limited)
+	assert.Equal(t, []string{messages.KindWelcome}, receivedKinds(kinds, 300*time.Millisecond))
+
+	// A worker older than the handshake only supports a single target at a constant rate.
+	legacy := dialWorker(t, "127.0.0.1:9279", "legacy")
+	legacyKinds := receiveKinds(legacy)
+
+	// The limited worker does not support the linear pacer.
+	srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10290/hello",
+		Duration: 1,
+		Rate:     10,
+		Pacer:    messages.PacerLinear,
+		Slope:    1,
+	})
+	assert.NotContains(t, receivedKinds(kinds, 300*time.Millisecond), messages.KindStartLoadTestRequest)
+	assert.NotContains(t, receivedKinds(legacyKinds, 100*time.Millisecond), messages.KindStartLoadTestRequest)
+
+	// Neither worker supports several targets.
+	srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Duration: 1,
+		Rate:     10,
+		Targets: []messages.LoadTestTarget{
+			{Method: "GET", URL: "http://127.0.0.1:10290/a"},
+			{Method: "GET", URL: "http://127.0.0.1:10290/b"},
+		},
+	})
+	assert.NotContains(t, receivedKinds(kinds, 300*time.Millisecond), messages.KindStartLoadTestRequest)
+	assert.NotContains(t, receivedKinds(legacyKinds, 100*time.Millisecond), messages.KindStartLoadTestRequest)
+
+	srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10290/hello",
+		Duration: 1,
+		Rate:     10,
+	})
+	assert.Contains(t, receivedKinds(kinds, 300*time.Millisecond), messages.KindStartLoadTestRequest)
+	assert.Contains(t, receivedKinds(legacyKinds, 100*time.Millisecond), messages.KindStartLoadTestRequest)
+
+	events, err := client.NewClient("127.0.0.1:9279").GetAuditLog(audit.Query{Action: audit.ActionStartLoadTest})
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Empty(t, events[0].Workers)
+	assert.Empty(t, events[1].Workers)
+	assert.Equal(t, []string{"legacy", "limited"}, events[2].Workers)
+}
+
+func TestWorkerRejectedByServerLeaves(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	// A server rejecting every worker.
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		data, _ := json.Marshal(messages.Welcome{ProtocolVersion: messages.ProtocolVersion, Error: "go away"})
+		envelope, _ := json.Marshal(messages.Envelope{Kind: messages.KindWelcome, Data: string(data)})
+		conn.WriteMessage(websocket.TextMessage, envelope)
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer rejecting.Close()
+
+	w := worker.NewWorker()
+	w.SetConnectRetryInterval(connectRetryInterval)
+
+	done := make(chan struct{})
+	go func() {
+		w.Run(strings.TrimPrefix(rejecting.URL, "http://"))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("rejected worker kept running")
+	}
+}
@@ -0,0 +1,165 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/client"
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func workersStatus(t *testing.T, addr string) map[string]string {
+	resp, err := http.Get("http://" + addr + "/api/v1/workers_info")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var workers []struct {
+		Name   string `json:"name"`
+		Status string `json:"status"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&workers))
+
+	statuses := make(map[string]string)
+	for _, w := range workers {
+		statuses[w.Name] = w.Status
+	}
+
+	return statuses
+}
+
+func TestUnresponsiveWorkerIsEvicted(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10280")
+
+	srv := server.NewServer()
+	srv.SetHeartbeat(100*time.Millisecond, 500*time.Millisecond)
+	srv.SetEvictionGracePeriod(1 * time.Second)
+	go srv.Run("127.0.0.1:9249")
+	defer srv.Close()
+
+	w := worker.NewWorker()
+	w.SetName("worker1")
+	w.SetConnectRetryInterval(connectRetryInterval)
+
+	connected := make(chan struct{}, 1)
+	w.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go w.Run("127.0.0.1:9249")
+	defer w.Close()
+	<-connected
+
+	// A worker that joins and then never answers, as on a half-open connection.
+	var ghost *websocket.Conn
+	var err error
+	for i := 0; i < 10; i++ {
+		if ghost, _, err = websocket.DefaultDialer.Dial("ws://127.0.0.1:9249/cluster/join?name=ghost", nil); err == nil {
+			break
+		}
+
+		time.Sleep(connectRetryInterval)
+	}
+	require.NoError(t, err)
+	defer ghost.Close()
+
+	time.Sleep(200 * time.Millisecond)
+
+	run := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10280/hello",
+		Duration: 1,
+		Rate:     10,
+	})
+
+	time.Sleep(800 * time.Millisecond)
+	assert.Equal(t, map[string]string{"worker1": "Connected", "ghost": "Unresponsive"}, workersStatus(t, "127.0.0.1:9249"))
+
+	// The run completes once the ghost, which never started it, is evicted.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	finished, err := client.NewClient("127.0.0.1:9249").WaitForRun(ctx, run.ID, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Done", finished.State)
+	assert.Equal(t, map[string]string{"worker1": "Connected"}, workersStatus(t, "127.0.0.1:9249"))
+}
+
+func TestWorkerReconnectsToSilentServer(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	joins := make(chan struct{}, 10)
+
+	// A server that accepts workers and never sends anything.
+	silent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		joins <- struct{}{}
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer silent.Close()
+
+	w := worker.NewWorker()
+	w.SetConnectRetryInterval(connectRetryInterval)
+	w.SetHeartbeatTimeout(300 * time.Millisecond)
+
+	go w.Run(strings.TrimPrefix(silent.URL, "http://"))
+	defer w.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-joins:
+		case <-time.After(3 * time.Second):
+			t.Fatal("worker did not reconnect to the silent server")
+		}
+	}
+}
+
+func TestWorkerStaysConnectedToServerWithoutHeartbeats(t *testing.T) {
+	srv := server.NewServer()
+	srv.SetHeartbeat(0, 0)
+	go srv.Run("127.0.0.1:9319")
+	defer srv.Close()
+
+	w := worker.NewWorker()
+	w.SetName("worker1")
+	w.SetConnectRetryInterval(connectRetryInterval)
+	w.SetHeartbeatTimeout(300 * time.Millisecond)
+
+	joins := make(chan struct{}, 10)
+	w.AddConnectedCallback(func() {
+		joins <- struct{}{}
+	})
+
+	go w.Run("127.0.0.1:9319")
+	defer w.Close()
+
+	select {
+	case <-joins:
+	case <-time.After(3 * time.Second):
+		t.Fatal("worker did not connect")
+	}
+
+	// The server said it does not ping, so its silence is not mistaken for a dead connection.
+	time.Sleep(1 * time.Second)
+	assert.Len(t, joins, 0)
+	assert.Equal(t, map[string]string{"worker1": "Connected"}, workersStatus(t, "127.0.0.1:9319"))
+}
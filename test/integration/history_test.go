@@ -0,0 +1,207 @@
+package integration
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/audit"
+	"github.com/andylibrian/terjang/pkg/client"
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/store"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTestHistory(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10110")
+
+	dataDir := t.TempDir()
+	fileStore, err := store.NewFileStore(dataDir)
+	require.NoError(t, err)
+
+	srv := server.NewServer()
+	srv.SetStore(fileStore)
+	go srv.Run("127.0.0.1:9069")
+	defer srv.Close()
+
+	worker := worker.NewWorker()
+	worker.SetName("worker1")
+	worker.SetConnectRetryInterval(connectRetryInterval)
+
+	// Wait for worker to be connected
+	connected := make(chan struct{})
+	worker.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go worker.Run("127.0.0.1:9069")
+	defer worker.Close()
+	<-connected
+
+	duration := 1
+	rate := 10
+	run := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10110/hello",
+		Duration: uint64(duration),
+		Rate:     uint64(rate),
+	})
+	require.NotEmpty(t, run.ID)
+
+	// Wait for the load test to complete and the run to be saved.
+	time.Sleep(time.Duration(duration)*time.Second + 2500*time.Millisecond)
+
+	// A new server using the same directory sees the run, as if the server was restarted.
+	restarted := server.NewServer()
+	reopenedStore, err := store.NewFileStore(dataDir)
+	require.NoError(t, err)
+	restarted.SetStore(reopenedStore)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/load_tests", nil)
+	w := httptest.NewRecorder()
+	restarted.HandleLoadTests(w, req, nil)
+
+	resp := w.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	var runs []messages.LoadTestRun
+	require.NoError(t, json.Unmarshal(bodyBytes, &runs))
+	require.Len(t, runs, 1)
+	assert.Equal(t, run.ID, runs[0].ID)
+	assert.Equal(t, "Done", runs[0].State)
+	assert.NotNil(t, runs[0].EndedAt)
+	assert.Equal(t, uint64(duration*rate), runs[0].Metrics.Requests)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/load_tests/"+run.ID, nil)
+	w = httptest.NewRecorder()
+	restarted.HandleLoadTest(w, req, httprouter.Params{{Key: "id", Value: run.ID}})
+
+	resp = w.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	bodyBytes, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	var stored messages.LoadTestRun
+	require.NoError(t, json.Unmarshal(bodyBytes, &stored))
+	assert.Equal(t, "http://127.0.0.1:10110/hello", stored.Request.URL)
+	require.Len(t, stored.Workers, 1)
+	assert.Equal(t, "worker1", stored.Workers[0].Name)
+	assert.Equal(t, uint64(duration*rate), stored.Workers[0].Metrics.Requests)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/load_tests/unknown", nil)
+	w = httptest.NewRecorder()
+	restarted.HandleLoadTest(w, req, httprouter.Params{{Key: "id", Value: "unknown"}})
+
+	assert.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+}
+
+func TestCorruptRunFileIsSkipped(t *testing.T) {
+	dataDir := t.TempDir()
+	fileStore, err := store.NewFileStore(dataDir)
+	require.NoError(t, err)
+
+	require.NoError(t, fileStore.SaveRun(&messages.LoadTestRun{ID: "20200101-000000-00000000", State: "Done"}))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dataDir, "20200101-000001-00000000.json"), []byte(`{"id": "trunc`), 0644))
+
+	// The corrupt file does not hide the rest of the history.
+	runs, err := fileStore.ListRuns()
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, "20200101-000000-00000000", runs[0].ID)
+}
+
+func TestRunKeepsResultsOfWorkersWithTheSameName(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10370")
+
+	srv := server.NewServer()
+	go srv.Run("127.0.0.1:9379")
+	defer srv.Close()
+
+	startAckWorker(t, "127.0.0.1:9379", "twin")
+	startAckWorker(t, "127.0.0.1:9379", "twin")
+
+	run := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10370/hello",
+		Duration: 1,
+		Rate:     10,
+	})
+
+	// Wait for the load test to complete and the run to be saved.
+	time.Sleep(1*time.Second + 2500*time.Millisecond)
+
+	stored, err := srv.GetRun(run.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint64(20), stored.Metrics.Requests)
+	require.Len(t, stored.Workers, 2)
+
+	ids := []string{stored.Workers[0].ID, stored.Workers[1].ID}
+	assert.ElementsMatch(t, []string{"twin", "twin#2"}, ids)
+	for _, w := range stored.Workers {
+		assert.Equal(t, "twin", w.Name)
+		assert.Equal(t, uint64(10), w.Metrics.Requests)
+	}
+}
+
+func TestStartingLoadTestStopsTheRunningOne(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10380")
+
+	srv := server.NewServer()
+	go srv.Run("127.0.0.1:9389")
+	defer srv.Close()
+
+	startAckWorker(t, "127.0.0.1:9389", "worker1")
+
+	first := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10380/hello?run=first",
+		Duration: 10,
+		Rate:     10,
+	})
+
+	time.Sleep(1500 * time.Millisecond)
+
+	second := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10380/hello?run=second",
+		Duration: 1,
+		Rate:     10,
+	})
+
+	// Wait for the load test to complete and the run to be saved.
+	time.Sleep(1*time.Second + 2500*time.Millisecond)
+
+	stopped, err := srv.GetRun(first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Stopped", stopped.State)
+
+	// The results of the first load test do not leak into the second one.
+	finished, err := srv.GetRun(second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Done", finished.State)
+	assert.Equal(t, uint64(10), finished.Metrics.Requests)
+	last, _ := target.last()
+	assert.Equal(t, "run=second", last.URL.RawQuery)
+
+	events, err := client.NewClient("127.0.0.1:9389").GetAuditLog(audit.Query{Action: audit.ActionStopLoadTest})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, first.ID, events[0].RunID)
+	assert.Equal(t, "superseded by a new load test", events[0].Reason)
+}
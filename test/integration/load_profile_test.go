@@ -0,0 +1,90 @@
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStagesLoadProfile(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10130")
+
+	server := server.NewServer()
+	go server.Run("127.0.0.1:9089")
+	defer server.Close()
+
+	worker := worker.NewWorker()
+	worker.SetConnectRetryInterval(connectRetryInterval)
+
+	// Wait for worker to be connected
+	connected := make(chan struct{})
+	worker.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go worker.Run("127.0.0.1:9089")
+	defer worker.Close()
+	<-connected
+
+	// Ramp from 0 to 20 rps over 1s (10 requests), then hold 20 rps for 1s (20 requests).
+	server.StartLoadTest(&messages.StartLoadTestRequest{
+		Method: "GET",
+		URL:    "http://127.0.0.1:10130/hello",
+		Pacer:  messages.PacerStages,
+		Stages: []messages.LoadTestStage{
+			{Duration: 1, Rate: 20},
+			{Duration: 1, Rate: 20},
+		},
+	})
+
+	time.Sleep(1 * time.Second)
+
+	// The ramp sends fewer requests than the hold
+	assert.InDelta(t, 10, target.count(), 2)
+
+	time.Sleep(1*time.Second + 500*time.Millisecond)
+
+	assert.InDelta(t, 30, target.count(), 2)
+}
+
+func TestLinearLoadProfile(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10131")
+
+	server := server.NewServer()
+	go server.Run("127.0.0.1:9089")
+	defer server.Close()
+
+	worker := worker.NewWorker()
+	worker.SetConnectRetryInterval(connectRetryInterval)
+
+	// Wait for worker to be connected
+	connected := make(chan struct{})
+	worker.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go worker.Run("127.0.0.1:9089")
+	defer worker.Close()
+	<-connected
+
+	// Start at 10 rps and increase by 10 rps every second: 10 + 10*2^2/2 = 40 requests over 2s.
+	duration := 2
+	server.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10131/hello",
+		Duration: uint64(duration),
+		Rate:     10,
+		Pacer:    messages.PacerLinear,
+		Slope:    10,
+	})
+
+	time.Sleep(time.Duration(duration)*time.Second + 500*time.Millisecond)
+
+	assert.InDelta(t, 40, target.count(), 2)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -18,17 +19,35 @@ type targetServer struct {
 	counter  uint32
 	lastReq  *http.Request
 	lastBody []byte
+	lock     sync.Mutex
 }
 
 func (t *targetServer) helloHandler(w http.ResponseWriter, req *http.Request) {
-	t.lastBody, _ = ioutil.ReadAll(req.Body)
+	body, _ := ioutil.ReadAll(req.Body)
 	atomic.AddUint32(&t.counter, 1)
+
+	t.lock.Lock()
+	t.lastBody = body
 	t.lastReq = req
+	t.lock.Unlock()
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Hello"))
 }
 
+// count returns the number of requests received.
+func (t *targetServer) count() int {
+	return int(atomic.LoadUint32(&t.counter))
+}
+
+// last returns the last request received and its body.
+func (t *targetServer) last() (*http.Request, []byte) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.lastReq, t.lastBody
+}
+
 func (t *targetServer) listenAndServe(addr string) {
 	handler := http.NewServeMux()
 	handler.HandleFunc("/hello", t.helloHandler)
@@ -54,6 +73,7 @@ func TestStartLoadTest(t *testing.T) {
 	})
 
 	go worker.Run("127.0.0.1:9019")
+	defer worker.Close()
 	<-connected
 
 	duration := 1
@@ -75,12 +95,13 @@ func TestStartLoadTest(t *testing.T) {
 	time.Sleep(time.Duration(duration) * time.Second)
 	time.Sleep(500 * time.Millisecond)
 
-	assert.Equal(t, rate*duration, int(target.counter))
-	// assert.NotNil(t, target.lastReq)
-	// assert.Equal(t, "POST", target.lastReq.Method)
-	assert.Equal(t, "thebody", string(target.lastBody))
-	assert.Equal(t, "MyLoadTest", target.lastReq.Header.Get("X-Load-Test"))
-	assert.Equal(t, "Bar", target.lastReq.Header.Get("X-Foo"))
+	assert.Equal(t, rate*duration, target.count())
+	lastReq, lastBody := target.last()
+	// assert.NotNil(t, lastReq)
+	// assert.Equal(t, "POST", lastReq.Method)
+	assert.Equal(t, "thebody", string(lastBody))
+	assert.Equal(t, "MyLoadTest", lastReq.Header.Get("X-Load-Test"))
+	assert.Equal(t, "Bar", lastReq.Header.Get("X-Foo"))
 }
 
 func TestStopLoadTest(t *testing.T) {
@@ -101,6 +122,7 @@ func TestStopLoadTest(t *testing.T) {
 	})
 
 	go worker.Run("127.0.0.1:9019")
+	defer worker.Close()
 	<-connected
 
 	duration := 2
@@ -127,6 +149,6 @@ func TestStopLoadTest(t *testing.T) {
 	time.Sleep(200 * time.Millisecond)
 
 	// Expect incomplete, but not zero
-	assert.Less(t, int(target.counter), duration*rate)
-	assert.Greater(t, int(target.counter), 0)
+	assert.Less(t, target.count(), duration*rate)
+	assert.Greater(t, target.count(), 0)
 }
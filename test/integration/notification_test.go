@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"sync"
 	"testing"
 	"time"
 
@@ -25,6 +26,29 @@ type stubNotificationClient struct {
 	messages        []messages.Envelope
 	serverInfoMsgs  []messages.Envelope
 	workersInfoMsgs []messages.Envelope
+	lock            sync.Mutex
+}
+
+// received returns the messages received so far.
+func (s *stubNotificationClient) received() []messages.Envelope {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]messages.Envelope(nil), s.messages...)
+}
+
+func (s *stubNotificationClient) lastServerInfo() messages.Envelope {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.serverInfoMsgs[len(s.serverInfoMsgs)-1]
+}
+
+func (s *stubNotificationClient) lastWorkersInfo() messages.Envelope {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.workersInfoMsgs[len(s.workersInfoMsgs)-1]
 }
 
 func (s *stubNotificationClient) run(addr string) {
@@ -62,6 +86,7 @@ func (s *stubNotificationClient) run(addr string) {
 		var envelope messages.Envelope
 		err = json.Unmarshal(msg, &envelope)
 
+		s.lock.Lock()
 		if err == nil {
 			s.messages = append(s.messages, envelope)
 		}
@@ -71,6 +96,7 @@ func (s *stubNotificationClient) run(addr string) {
 		} else if envelope.Kind == messages.KindWorkersInfo {
 			s.workersInfoMsgs = append(s.workersInfoMsgs, envelope)
 		}
+		s.lock.Unlock()
 	}
 }
 
@@ -87,7 +113,7 @@ func TestServerSendServerInfoNotification(t *testing.T) {
 	// Wait for a notification that comes every second
 	time.Sleep(1*time.Second + 100*time.Millisecond)
 
-	lastMsg := clientStub.serverInfoMsgs[len(clientStub.serverInfoMsgs)-1]
+	lastMsg := clientStub.lastServerInfo()
 	assert.Equal(t, messages.KindServerInfo, lastMsg.Kind)
 
 	var serverInfo messages.ServerInfo
@@ -105,12 +131,13 @@ func TestServerSendServerInfoNotification(t *testing.T) {
 	})
 
 	go worker.Run("127.0.0.1:9029")
+	defer worker.Close()
 	<-connected
 
 	time.Sleep(1*time.Second + 100*time.Millisecond)
 
 	// assert server info
-	lastMsg = clientStub.serverInfoMsgs[len(clientStub.serverInfoMsgs)-1]
+	lastMsg = clientStub.lastServerInfo()
 	assert.Equal(t, messages.KindServerInfo, lastMsg.Kind)
 
 	json.Unmarshal([]byte(lastMsg.Data), &serverInfo)
@@ -140,6 +167,7 @@ func TestServerUpdateServerInfoNotification(t *testing.T) {
 	})
 
 	go worker.Run("127.0.0.1:9039")
+	defer worker.Close()
 	<-connected
 
 	duration := 2
@@ -157,7 +185,7 @@ func TestServerUpdateServerInfoNotification(t *testing.T) {
 	time.Sleep(1 * time.Second)
 	time.Sleep(100 * time.Millisecond)
 
-	lastMsg := clientStub.serverInfoMsgs[len(clientStub.serverInfoMsgs)-1]
+	lastMsg := clientStub.lastServerInfo()
 	assert.Equal(t, messages.KindServerInfo, lastMsg.Kind)
 
 	var serverInfo messages.ServerInfo
@@ -169,14 +197,14 @@ func TestServerUpdateServerInfoNotification(t *testing.T) {
 	time.Sleep(3 * time.Second)
 	time.Sleep(100 * time.Millisecond)
 
-	lastMsg = clientStub.serverInfoMsgs[len(clientStub.serverInfoMsgs)-1]
+	lastMsg = clientStub.lastServerInfo()
 	assert.Equal(t, messages.KindServerInfo, lastMsg.Kind)
 
 	json.Unmarshal([]byte(lastMsg.Data), &serverInfo)
 
 	assert.Equal(t, "Done", serverInfo.State)
 
-	lastWorkersInfo := clientStub.workersInfoMsgs[len(clientStub.workersInfoMsgs)-1]
+	lastWorkersInfo := clientStub.lastWorkersInfo()
 
 	var workersInfo []stubWorker
 	json.Unmarshal([]byte(lastWorkersInfo.Data), &workersInfo)
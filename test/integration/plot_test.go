@@ -0,0 +1,64 @@
+package integration
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/client"
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTestPlot(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10200")
+
+	srv := server.NewServer()
+	go srv.Run("127.0.0.1:9169")
+	defer srv.Close()
+
+	w := worker.NewWorker()
+	w.SetName("worker1")
+	w.SetConnectRetryInterval(connectRetryInterval)
+
+	// Wait for worker to be connected
+	connected := make(chan struct{})
+	w.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go w.Run("127.0.0.1:9169")
+	defer w.Close()
+	<-connected
+
+	run := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:        "GET",
+		URL:           "http://127.0.0.1:10200/hello",
+		Duration:      2,
+		Rate:          10,
+		ResultsOutput: messages.ResultsOutputServer,
+	})
+
+	// Wait for the load test to complete and the run to be saved.
+	time.Sleep(2*time.Second + 2500*time.Millisecond)
+
+	c := client.NewClient("127.0.0.1:9169")
+
+	var results bytes.Buffer
+	require.NoError(t, c.DownloadPlot(run.ID, "", &results))
+	assert.Contains(t, results.String(), "<html>")
+	assert.Contains(t, results.String(), "Terjang load test "+run.ID)
+	assert.Contains(t, results.String(), `"worker1: OK"`)
+
+	var timeSeries bytes.Buffer
+	require.NoError(t, c.DownloadPlot(run.ID, "timeseries", &timeSeries))
+	assert.Contains(t, timeSeries.String(), `"latency: p99"`)
+	assert.Contains(t, timeSeries.String(), `"latency: max"`)
+
+	assert.Error(t, c.DownloadPlot(run.ID, "unknown", &bytes.Buffer{}))
+	assert.Error(t, c.DownloadPlot("unknown", "", &bytes.Buffer{}))
+}
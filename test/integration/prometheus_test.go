@@ -0,0 +1,108 @@
+package integration
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10210")
+
+	srv := server.NewServer()
+	go srv.Run("127.0.0.1:9179")
+	defer srv.Close()
+
+	w := worker.NewWorker()
+	w.SetName("worker1")
+	w.SetConnectRetryInterval(connectRetryInterval)
+
+	// Wait for worker to be connected
+	connected := make(chan struct{})
+	w.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go w.Run("127.0.0.1:9179")
+	defer w.Close()
+	<-connected
+
+	run := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10210/hello",
+		Duration: 1,
+		Rate:     10,
+	})
+
+	// Wait for the load test to complete and the final metrics to be reported.
+	time.Sleep(1*time.Second + 2500*time.Millisecond)
+
+	resp, err := http.Get("http://127.0.0.1:9179/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain; version=0.0.4")
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	metrics := string(body)
+	assert.Contains(t, metrics, "# TYPE terjang_workers gauge\nterjang_workers 1\n")
+	assert.Contains(t, metrics, `terjang_load_test_state{state="Done"} 1`)
+	assert.Contains(t, metrics, `terjang_load_test_state{state="Running"} 0`)
+	assert.Contains(t, metrics, `terjang_load_test_info{run_id="`+run.ID+`"} 1`)
+	assert.Contains(t, metrics, `terjang_worker_state{worker="worker1",state="Done"} 1`)
+	assert.Contains(t, metrics, "terjang_requests_total 10\n")
+	assert.Contains(t, metrics, "terjang_success_ratio 1\n")
+	assert.Contains(t, metrics, `terjang_worker_requests_total{worker="worker1"} 10`)
+	assert.Contains(t, metrics, `terjang_worker_success_ratio{worker="worker1"} 1`)
+	assert.Contains(t, metrics, `terjang_worker_responses_total{worker="worker1",code="200"} 10`)
+	assert.Contains(t, metrics, "# TYPE terjang_worker_latency_seconds summary")
+	assert.Contains(t, metrics, `terjang_worker_latency_seconds{worker="worker1",quantile="0.99"} `)
+	assert.Contains(t, metrics, `terjang_worker_latency_seconds_count{worker="worker1"} 10`)
+}
+
+func TestWorkersWithTheSameNameHaveDistinctSeries(t *testing.T) {
+	srv := server.NewServer()
+	go srv.Run("127.0.0.1:9349")
+	defer srv.Close()
+
+	dialWorker(t, "127.0.0.1:9349", "dup")
+	dialWorker(t, "127.0.0.1:9349", "dup")
+	dialWorker(t, "127.0.0.1:9349", "other")
+	time.Sleep(200 * time.Millisecond)
+
+	resp, err := http.Get("http://127.0.0.1:9349/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	metrics := string(body)
+	assert.Contains(t, metrics, `terjang_worker_state{worker="dup",state="NotStarted"} 1`)
+	assert.Contains(t, metrics, `terjang_worker_state{worker="dup#2",state="NotStarted"} 1`)
+	assert.Contains(t, metrics, `terjang_worker_state{worker="other",state="NotStarted"} 1`)
+
+	// Prometheus rejects scrapes with duplicate series.
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(metrics, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		series := line[:strings.LastIndex(line, " ")]
+		assert.False(t, seen[series], series)
+		seen[series] = true
+	}
+}
@@ -0,0 +1,130 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTotalRateModeSplitsRateByWeight(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10140")
+
+	server := server.NewServer()
+	server.SetResumeGracePeriod(500 * time.Millisecond)
+	go server.Run("127.0.0.1:9099")
+	defer server.Close()
+
+	connected := make(chan struct{})
+
+	weights := map[string]int{"worker1": 1, "worker2": 2}
+
+	for name, weight := range weights {
+		w := worker.NewWorker()
+		w.SetName(name)
+		w.SetWeight(weight)
+		w.SetConnectRetryInterval(connectRetryInterval)
+		w.AddConnectedCallback(func() {
+			connected <- struct{}{}
+		})
+
+		go w.Run("127.0.0.1:9099")
+		defer w.Close()
+	}
+
+	<-connected
+	<-connected
+
+	duration := 1
+	rate := 31
+	run := server.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10140/hello",
+		Duration: uint64(duration),
+		Rate:     uint64(rate),
+		RateMode: messages.RateModeTotal,
+	})
+
+	// Wait for the load test to complete and the run to be saved.
+	time.Sleep(time.Duration(duration)*time.Second + 2500*time.Millisecond)
+
+	assert.Equal(t, rate*duration, target.count())
+
+	stored, err := server.GetRun(run.ID)
+	require.NoError(t, err)
+
+	requests := make(map[string]uint64)
+	for _, w := range stored.Workers {
+		requests[w.Name] = w.Metrics.Requests
+	}
+
+	// 31 rps split by weights 1:2 is 10.33 and 20.67 rps, the remainder goes to the larger fraction.
+	assert.Equal(t, uint64(10), requests["worker1"])
+	assert.Equal(t, uint64(21), requests["worker2"])
+}
+
+func TestTotalRateModeRebalancesOnWorkerDisconnect(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10141")
+
+	server := server.NewServer()
+	server.SetResumeGracePeriod(500 * time.Millisecond)
+	go server.Run("127.0.0.1:9099")
+	defer server.Close()
+
+	worker := worker.NewWorker()
+	worker.SetConnectRetryInterval(connectRetryInterval)
+
+	// Wait for worker to be connected
+	connected := make(chan struct{})
+	worker.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go worker.Run("127.0.0.1:9099")
+	defer worker.Close()
+	<-connected
+
+	// A second worker that receives its share but never runs it, then drops out.
+	joinURL := url.URL{Scheme: "ws", Host: "127.0.0.1:9099", Path: "/cluster/join", RawQuery: "name=dropped"}
+	droppedConn, _, err := websocket.DefaultDialer.Dial(joinURL.String(), nil)
+	require.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	duration := 2
+	rate := 20
+	server.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10141/hello",
+		Duration: uint64(duration),
+		Rate:     uint64(rate),
+		RateMode: messages.RateModeTotal,
+	})
+
+	_, msg, err := droppedConn.ReadMessage()
+	require.NoError(t, err)
+
+	var envelope messages.Envelope
+	require.NoError(t, json.Unmarshal(msg, &envelope))
+
+	var req messages.StartLoadTestRequest
+	require.NoError(t, json.Unmarshal([]byte(envelope.Data), &req))
+	assert.Equal(t, 0.5, req.Share)
+
+	time.Sleep(1 * time.Second)
+	droppedConn.Close()
+
+	time.Sleep(time.Duration(duration-1)*time.Second + 500*time.Millisecond)
+
+	// Half of the rate during the first second and the grace period, then the full rate once rebalanced.
+	assert.InDelta(t, rate/2+rate/4+rate/2, target.count(), 2)
+}
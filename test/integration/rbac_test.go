@@ -0,0 +1,127 @@
+package integration
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/client"
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const usersFile = `{"users": [
+	{"name": "vera", "token": "viewer-token", "role": "viewer"},
+	{"name": "otto", "token": "operator-token", "role": "operator"},
+	{"name": "ada", "token": "admin-token", "role": "admin"}
+]}`
+
+func TestRoleBasedAccessControl(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10250")
+
+	file := filepath.Join(t.TempDir(), "users.json")
+	require.NoError(t, ioutil.WriteFile(file, []byte(usersFile), 0600))
+
+	users, err := server.LoadUsers(file)
+	require.NoError(t, err)
+	require.Len(t, users, 3)
+	assert.Equal(t, server.RoleOperator, users[1].Role)
+
+	srv := server.NewServer()
+	for _, u := range users {
+		srv.AddUser(u)
+	}
+
+	go srv.Run("127.0.0.1:9219")
+	defer srv.Close()
+
+	w := worker.NewWorker()
+	w.SetName("worker1")
+	w.SetConnectRetryInterval(connectRetryInterval)
+
+	// Wait for worker to be connected
+	connected := make(chan struct{})
+	w.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go w.Run("127.0.0.1:9219")
+	defer w.Close()
+	<-connected
+
+	status := func(method string, path string, token string) int {
+		req, err := http.NewRequest(method, "http://127.0.0.1:9219"+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	for _, c := range []struct {
+		method, path, token string
+		status              int
+	}{
+		{"GET", "/api/v1/server_info", "unknown-token", http.StatusUnauthorized},
+		{"GET", "/api/v1/server_info", "viewer-token", http.StatusOK},
+		{"GET", "/api/v1/workers_info", "viewer-token", http.StatusOK},
+		{"GET", "/api/v1/load_tests", "viewer-token", http.StatusOK},
+		{"GET", "/metrics", "viewer-token", http.StatusOK},
+		{"DELETE", "/api/v1/load_test", "viewer-token", http.StatusForbidden},
+		{"DELETE", "/api/v1/workers/worker1", "viewer-token", http.StatusForbidden},
+		{"GET", "/api/v1/server_info", "operator-token", http.StatusOK},
+		{"DELETE", "/api/v1/load_test", "operator-token", http.StatusOK},
+		{"DELETE", "/api/v1/workers/worker1", "operator-token", http.StatusForbidden},
+		{"DELETE", "/api/v1/workers/unknown", "admin-token", http.StatusNotFound},
+	} {
+		assert.Equal(t, c.status, status(c.method, c.path, c.token), "%s %s with %s", c.method, c.path, c.token)
+	}
+
+	req := &messages.StartLoadTestRequest{Method: "GET", URL: "http://127.0.0.1:10250/hello", Duration: 1, Rate: 10}
+
+	viewer := client.NewClient("127.0.0.1:9219")
+	viewer.SetToken("viewer-token")
+	_, err = viewer.StartLoadTest(req)
+	assert.Error(t, err)
+
+	operator := client.NewClient("127.0.0.1:9219")
+	operator.SetToken("operator-token")
+	_, err = operator.StartLoadTest(req)
+	assert.NoError(t, err)
+
+	admin := client.NewClient("127.0.0.1:9219")
+	admin.SetToken("admin-token")
+	require.NoError(t, admin.RemoveWorker("worker1"))
+
+	// Wait for the worker to be removed.
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, http.StatusNotFound, status("DELETE", "/api/v1/workers/worker1", "admin-token"))
+}
+
+func TestInvalidUsersFile(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"role.json":  `{"users": [{"name": "bob", "token": "t", "role": "root"}]}`,
+		"token.json": `{"users": [{"name": "bob", "role": "admin"}]}`,
+		"json.json":  `{"users": `,
+	} {
+		file := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+
+		_, err := server.LoadUsers(file)
+		assert.Error(t, err, name)
+	}
+
+	_, err := server.LoadUsers(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,327 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/client"
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyProxy forwards TCP connections to a server, and can drop them to simulate a network blip.
+type flakyProxy struct {
+	target string
+	conns  []net.Conn
+	down   bool
+	lock   sync.Mutex
+}
+
+func (p *flakyProxy) listenAndServe(t *testing.T, addr string) {
+	listener, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go p.forward(conn)
+		}
+	}()
+}
+
+func (p *flakyProxy) forward(conn net.Conn) {
+	p.lock.Lock()
+	if p.down {
+		p.lock.Unlock()
+		conn.Close()
+		return
+	}
+	p.lock.Unlock()
+
+	upstream, err := net.Dial("tcp", p.target)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	p.lock.Lock()
+	p.conns = append(p.conns, conn, upstream)
+	p.lock.Unlock()
+
+	go func() {
+		io.Copy(upstream, conn)
+		upstream.Close()
+	}()
+
+	io.Copy(conn, upstream)
+	conn.Close()
+}
+
+// drop closes the forwarded connections. While down, new connections are closed right away.
+func (p *flakyProxy) drop(down bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+
+	p.conns = nil
+	p.down = down
+}
+
+func (p *flakyProxy) setDown(down bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.down = down
+}
+
+func TestWorkerResumesLoadTestAfterReconnecting(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10270")
+
+	srv := server.NewServer()
+	go srv.Run("127.0.0.1:9239")
+	defer srv.Close()
+
+	proxy := &flakyProxy{target: "127.0.0.1:9239"}
+	proxy.listenAndServe(t, "127.0.0.1:9240")
+
+	w := worker.NewWorker()
+	w.SetName("worker1")
+	w.SetConnectRetryInterval(connectRetryInterval)
+
+	connected := make(chan struct{}, 2)
+	w.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go w.Run("127.0.0.1:9240")
+	defer w.Close()
+	<-connected
+
+	run := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10270/hello",
+		Duration: 4,
+		Rate:     20,
+	})
+
+	time.Sleep(1500 * time.Millisecond)
+	proxy.drop(false)
+
+	select {
+	case <-connected:
+	case <-time.After(3 * time.Second):
+		t.Fatal("worker did not reconnect")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	finished, err := client.NewClient("127.0.0.1:9239").WaitForRun(ctx, run.ID, nil)
+	require.NoError(t, err)
+
+	// The run is completed by the same worker, with all its requests.
+	assert.Equal(t, "Done", finished.State)
+	assert.Equal(t, uint64(80), finished.Metrics.Requests)
+	require.Len(t, finished.Workers, 1)
+	assert.Equal(t, "worker1", finished.Workers[0].Name)
+}
+
+func TestWorkerReconnectingAfterRunEndedIsStopped(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10271")
+
+	srv := server.NewServer()
+	srv.SetResumeGracePeriod(200 * time.Millisecond)
+	go srv.Run("127.0.0.1:9241")
+	defer srv.Close()
+
+	proxy := &flakyProxy{target: "127.0.0.1:9241"}
+	proxy.listenAndServe(t, "127.0.0.1:9242")
+
+	w := worker.NewWorker()
+	w.SetName("worker1")
+	w.SetConnectRetryInterval(connectRetryInterval)
+
+	connected := make(chan struct{}, 2)
+	w.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go w.Run("127.0.0.1:9242")
+	defer w.Close()
+	<-connected
+
+	run := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10271/hello",
+		Duration: 10,
+		Rate:     10,
+	})
+
+	time.Sleep(500 * time.Millisecond)
+
+	// The worker stays away longer than the grace period, so the run ends without it.
+	proxy.drop(true)
+	time.Sleep(1 * time.Second)
+
+	stopped, err := client.NewClient("127.0.0.1:9241").GetRun(run.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Stopped", stopped.State)
+
+	proxy.setDown(false)
+
+	select {
+	case <-connected:
+	case <-time.After(3 * time.Second):
+		t.Fatal("worker did not reconnect")
+	}
+
+	// The worker is asked to stop the load test of the ended run, the target receives no more requests.
+	time.Sleep(500 * time.Millisecond)
+	count := atomic.LoadUint32(&target.counter)
+	time.Sleep(1 * time.Second)
+	assert.Equal(t, count, atomic.LoadUint32(&target.counter))
+}
+
+func TestRemovedWorkerDoesNotReconnect(t *testing.T) {
+	srv := server.NewServer()
+	go srv.Run("127.0.0.1:9243")
+	defer srv.Close()
+
+	w := worker.NewWorker()
+	w.SetName("worker1")
+	w.SetConnectRetryInterval(connectRetryInterval)
+
+	connected := make(chan struct{}, 1)
+	w.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		w.Run("127.0.0.1:9243")
+		close(done)
+	}()
+	<-connected
+
+	require.NoError(t, client.NewClient("127.0.0.1:9243").RemoveWorker("worker1"))
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("removed worker kept running")
+	}
+}
+
+func TestShareOfDisconnectedWorkerIsReservedDuringGracePeriod(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10330")
+
+	srv := server.NewServer()
+	srv.SetResumeGracePeriod(2 * time.Second)
+	go srv.Run("127.0.0.1:9329")
+	defer srv.Close()
+
+	proxy := &flakyProxy{target: "127.0.0.1:9329"}
+	proxy.listenAndServe(t, "127.0.0.1:9330")
+
+	startAckWorker(t, "127.0.0.1:9329", "worker1")
+	startAckWorker(t, "127.0.0.1:9330", "worker2")
+
+	srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10330/hello",
+		Duration: 6,
+		Rate:     20,
+		RateMode: messages.RateModeTotal,
+	})
+
+	time.Sleep(1 * time.Second)
+
+	// worker2 loses its connection but keeps attacking, so worker1 keeps its half of the total rate.
+	proxy.drop(true)
+	time.Sleep(300 * time.Millisecond)
+
+	count := atomic.LoadUint32(&target.counter)
+	time.Sleep(1 * time.Second)
+	assert.InDelta(t, 20, int(atomic.LoadUint32(&target.counter)-count), 3)
+}
+
+func TestResumedWorkerKeepsItsIDWithinTheRun(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10360")
+
+	srv := server.NewServer()
+	go srv.Run("127.0.0.1:9369")
+	defer srv.Close()
+
+	proxy := &flakyProxy{target: "127.0.0.1:9369"}
+	proxy.listenAndServe(t, "127.0.0.1:9370")
+
+	startAckWorker(t, "127.0.0.1:9369", "twin")
+
+	// The second worker with the name is "twin#2" within the run.
+	w := worker.NewWorker()
+	w.SetName("twin")
+	w.SetConnectRetryInterval(connectRetryInterval)
+
+	connected := make(chan struct{}, 2)
+	w.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go w.Run("127.0.0.1:9370")
+	defer w.Close()
+	<-connected
+
+	run := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:        "GET",
+		URL:           "http://127.0.0.1:10360/hello",
+		Duration:      4,
+		Rate:          20,
+		ResultsOutput: messages.ResultsOutputServer,
+	})
+
+	time.Sleep(1500 * time.Millisecond)
+	proxy.drop(false)
+
+	select {
+	case <-connected:
+	case <-time.After(3 * time.Second):
+		t.Fatal("worker did not reconnect")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c := client.NewClient("127.0.0.1:9369")
+	_, err := c.WaitForRun(ctx, run.ID, nil)
+	require.NoError(t, err)
+	time.Sleep(1500 * time.Millisecond)
+
+	// The results sent after resuming are kept with those sent before.
+	for _, id := range []string{"twin", "twin#2"} {
+		var csv bytes.Buffer
+		require.NoError(t, c.DownloadResults(run.ID, messages.ResultsEncodingCSV, id, &csv), id)
+		assert.Len(t, strings.Split(strings.TrimSpace(csv.String()), "\n"), 80, id)
+	}
+}
@@ -0,0 +1,146 @@
+package integration
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/client"
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/store"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTestReport(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10180")
+
+	srv := server.NewServer()
+	go srv.Run("127.0.0.1:9149")
+	defer srv.Close()
+
+	connected := make(chan struct{})
+
+	for _, name := range []string{"worker1", "worker2"} {
+		w := worker.NewWorker()
+		w.SetName(name)
+		w.SetConnectRetryInterval(connectRetryInterval)
+		w.AddConnectedCallback(func() {
+			connected <- struct{}{}
+		})
+
+		go w.Run("127.0.0.1:9149")
+		defer w.Close()
+	}
+
+	<-connected
+	<-connected
+
+	run := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10180/hello",
+		Duration: 1,
+		Rate:     10,
+	})
+
+	// Wait for the load test to complete and the run to be saved.
+	time.Sleep(1*time.Second + 2500*time.Millisecond)
+
+	c := client.NewClient("127.0.0.1:9149")
+
+	text, err := c.GetReport(run.ID, "", "")
+	require.NoError(t, err)
+	assert.Contains(t, string(text), "Requests      [total, rate, throughput]")
+	assert.Contains(t, string(text), "200:20")
+
+	text, err = c.GetReport(run.ID, "text", "worker1")
+	require.NoError(t, err)
+	assert.Contains(t, string(text), "200:10")
+
+	jsonReport, err := c.GetReport(run.ID, "json", "")
+	require.NoError(t, err)
+
+	var metrics struct {
+		Requests    uint64         `json:"requests"`
+		StatusCodes map[string]int `json:"status_codes"`
+	}
+	require.NoError(t, json.Unmarshal(jsonReport, &metrics))
+	assert.Equal(t, uint64(20), metrics.Requests)
+	assert.Equal(t, 20, metrics.StatusCodes["200"])
+
+	hist, err := c.GetReport(run.ID, "hist[0,1h]", "")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(hist)), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[1], "20")
+	assert.Contains(t, lines[1], "100.00%")
+
+	hdr, err := c.GetReport(run.ID, "hdrplot", "")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(hdr), "Value(ms)"))
+
+	_, err = c.GetReport(run.ID, "unknown", "")
+	assert.Error(t, err)
+
+	_, err = c.GetReport(run.ID, "text", "unknown")
+	assert.Error(t, err)
+}
+
+func TestLoadTestReportOfWorkersWithTheSameName(t *testing.T) {
+	metrics := func(code string, n int) messages.WorkerLoadTestMetrics {
+		return messages.WorkerLoadTestMetrics{Requests: uint64(n), Success: 1, StatusCodes: map[string]int{code: n}}
+	}
+
+	runs := store.NewMemoryStore()
+	require.NoError(t, runs.SaveRun(&messages.LoadTestRun{
+		ID:        "20200101-000000-00000000",
+		StartedAt: time.Now(),
+		Workers: []messages.WorkerLoadTestResult{
+			{Name: "twin", ID: "twin", Metrics: metrics("200", 10)},
+			{Name: "twin", ID: "twin#2", Metrics: metrics("201", 20)},
+		},
+	}))
+	// Saved by an older version, without worker IDs.
+	require.NoError(t, runs.SaveRun(&messages.LoadTestRun{
+		ID:        "20200101-000001-00000000",
+		StartedAt: time.Now(),
+		Workers: []messages.WorkerLoadTestResult{
+			{Name: "twin", Metrics: metrics("200", 10)},
+			{Name: "twin", Metrics: metrics("201", 20)},
+			{Name: "single", Metrics: metrics("202", 30)},
+		},
+	}))
+
+	srv := server.NewServer()
+	srv.SetStore(runs)
+	go srv.Run("127.0.0.1:9399")
+	defer srv.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	c := client.NewClient("127.0.0.1:9399")
+
+	for _, tt := range []struct {
+		run, worker, want string
+	}{
+		{"20200101-000000-00000000", "twin", "200:10"},
+		{"20200101-000000-00000000", "twin#2", "201:20"},
+		{"20200101-000001-00000000", "single", "202:30"},
+	} {
+		text, err := c.GetReport(tt.run, "text", tt.worker)
+		require.NoError(t, err, tt.worker)
+		assert.Contains(t, string(text), tt.want, tt.worker)
+	}
+
+	_, err := c.GetReport("20200101-000001-00000000", "text", "twin")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "400")
+
+	_, err = c.GetReport("20200101-000000-00000000", "text", "twin#3")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "404")
+}
@@ -0,0 +1,153 @@
+package integration
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/client"
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+func TestRawResultsStreamedToServer(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10190")
+
+	srv := server.NewServer()
+	go srv.Run("127.0.0.1:9159")
+	defer srv.Close()
+
+	connected := make(chan struct{})
+
+	for _, name := range []string{"worker1", "worker2"} {
+		w := worker.NewWorker()
+		w.SetName(name)
+		w.SetConnectRetryInterval(connectRetryInterval)
+		w.AddConnectedCallback(func() {
+			connected <- struct{}{}
+		})
+
+		go w.Run("127.0.0.1:9159")
+		defer w.Close()
+	}
+
+	<-connected
+	<-connected
+
+	run := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:        "GET",
+		URL:           "http://127.0.0.1:10190/hello",
+		Duration:      2,
+		Rate:          10,
+		ResultsOutput: messages.ResultsOutputServer,
+	})
+
+	// Wait for the load test to complete and the run to be saved.
+	time.Sleep(2*time.Second + 2500*time.Millisecond)
+
+	c := client.NewClient("127.0.0.1:9159")
+
+	var gob bytes.Buffer
+	require.NoError(t, c.DownloadResults(run.ID, "", "", &gob))
+
+	dec := vegeta.NewDecoder(&gob)
+	count := 0
+	for {
+		var res vegeta.Result
+		if err := dec.Decode(&res); err != nil {
+			break
+		}
+
+		assert.Equal(t, uint16(200), res.Code)
+		count++
+	}
+	assert.Equal(t, 40, count)
+
+	var csv bytes.Buffer
+	require.NoError(t, c.DownloadResults(run.ID, messages.ResultsEncodingCSV, "worker1", &csv))
+	assert.Len(t, strings.Split(strings.TrimSpace(csv.String()), "\n"), 20)
+
+	assert.Error(t, c.DownloadResults(run.ID, messages.ResultsEncodingCSV, "unknown", &csv))
+}
+
+func TestRawResultsWrittenToFile(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10191")
+
+	srv := server.NewServer()
+	go srv.Run("127.0.0.1:9159")
+	defer srv.Close()
+
+	dir := t.TempDir()
+
+	w := worker.NewWorker()
+	w.SetName("worker1")
+	w.SetResultsDir(dir)
+	w.SetConnectRetryInterval(connectRetryInterval)
+
+	// Wait for worker to be connected
+	connected := make(chan struct{})
+	w.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go w.Run("127.0.0.1:9159")
+	defer w.Close()
+	<-connected
+
+	run := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:          "GET",
+		URL:             "http://127.0.0.1:10191/hello",
+		Duration:        1,
+		Rate:            10,
+		ResultsOutput:   messages.ResultsOutputFile,
+		ResultsEncoding: messages.ResultsEncodingCSV,
+	})
+
+	time.Sleep(1*time.Second + 500*time.Millisecond)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, run.ID+"-worker1.csv"))
+	require.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(data)), "\n"), 10)
+}
+
+func TestRawResultsOfWorkersWithTheSameName(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10350")
+
+	srv := server.NewServer()
+	go srv.Run("127.0.0.1:9359")
+	defer srv.Close()
+
+	// Workers are named after their host by default, so names may repeat.
+	startAckWorker(t, "127.0.0.1:9359", "twin")
+	startAckWorker(t, "127.0.0.1:9359", "twin")
+
+	run := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:        "GET",
+		URL:           "http://127.0.0.1:10350/hello",
+		Duration:      2,
+		Rate:          10,
+		ResultsOutput: messages.ResultsOutputServer,
+	})
+
+	// Wait for the load test to complete and the run to be saved.
+	time.Sleep(2*time.Second + 2500*time.Millisecond)
+
+	c := client.NewClient("127.0.0.1:9359")
+
+	// Each worker's batches are kept apart, none is dropped as received twice.
+	for _, id := range []string{"twin", "twin#2"} {
+		var csv bytes.Buffer
+		require.NoError(t, c.DownloadResults(run.ID, messages.ResultsEncodingCSV, id, &csv), id)
+		assert.Len(t, strings.Split(strings.TrimSpace(csv.String()), "\n"), 20, id)
+	}
+}
@@ -69,6 +69,7 @@ func TestHandleWorkersInfo(t *testing.T) {
 
 	worker.SetName("worker1")
 	go worker.Run("127.0.0.1:9019")
+	defer worker.Close()
 	<-connected
 
 	//Http request GET server_info
@@ -0,0 +1,226 @@
+package integration
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/sink"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver records what sinks push to it over HTTP and UDP.
+type receiver struct {
+	lock     sync.Mutex
+	requests map[string][]string
+	packets  map[string][]string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+
+	r.lock.Lock()
+	r.requests[req.URL.Path] = append(r.requests[req.URL.Path], string(body))
+	r.lock.Unlock()
+
+	w.WriteHeader(204)
+}
+
+func (r *receiver) listenUDP(t *testing.T, addr string) {
+	conn, err := net.ListenPacket("udp", addr)
+	require.NoError(t, err)
+
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			r.lock.Lock()
+			r.packets[addr] = append(r.packets[addr], string(buf[:n]))
+			r.lock.Unlock()
+		}
+	}()
+
+	t.Cleanup(func() { conn.Close() })
+}
+
+// lines returns the lines received in the requests to a path, or in the packets sent to a UDP address.
+func (r *receiver) lines(key string) []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var lines []string
+	for _, d := range append(r.requests[key], r.packets[key]...) {
+		lines = append(lines, strings.Split(strings.TrimSpace(d), "\n")...)
+	}
+
+	return lines
+}
+
+func sumMatches(t *testing.T, lines []string, pattern string) int {
+	re := regexp.MustCompile(pattern)
+
+	sum := 0
+	for _, line := range lines {
+		if m := re.FindStringSubmatch(line); m != nil {
+			n, err := strconv.Atoi(m[1])
+			require.NoError(t, err)
+			sum += n
+		}
+	}
+
+	return sum
+}
+
+// sumLastMatches sums the values of the lines matching a pattern, counting only the last line of each timestamp,
+// as InfluxDB keeps the last point written with a timestamp.
+func sumLastMatches(t *testing.T, lines []string, pattern string) int {
+	re := regexp.MustCompile(pattern)
+
+	last := make(map[string]int)
+	for _, line := range lines {
+		if m := re.FindStringSubmatch(line); m != nil {
+			n, err := strconv.Atoi(m[1])
+			require.NoError(t, err)
+			last[line[strings.LastIndex(line, " ")+1:]] = n
+		}
+	}
+
+	sum := 0
+	for _, n := range last {
+		sum += n
+	}
+
+	return sum
+}
+
+func TestMetricsPushedToSinks(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10220")
+
+	recv := &receiver{requests: make(map[string][]string), packets: make(map[string][]string)}
+	httpReceiver := &http.Server{Addr: "127.0.0.1:10221", Handler: recv}
+	go httpReceiver.ListenAndServe()
+	defer httpReceiver.Close()
+
+	recv.listenUDP(t, "127.0.0.1:10222")
+	recv.listenUDP(t, "127.0.0.1:10223")
+	recv.listenUDP(t, "127.0.0.1:10224")
+
+	srv := server.NewServer()
+	for _, u := range []string{
+		"influxdb://127.0.0.1:10221/write?db=terjang",
+		"influxdb+udp://127.0.0.1:10224",
+		"statsd://127.0.0.1:10222",
+		"dogstatsd://127.0.0.1:10223",
+		"otlp://127.0.0.1:10221",
+	} {
+		sk, err := sink.New(u)
+		require.NoError(t, err)
+		srv.AddSink(sk)
+	}
+
+	go srv.Run("127.0.0.1:9189")
+	defer srv.Close()
+
+	w := worker.NewWorker()
+	w.SetName("worker1")
+	w.SetConnectRetryInterval(connectRetryInterval)
+
+	// Wait for worker to be connected
+	connected := make(chan struct{})
+	w.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go w.Run("127.0.0.1:9189")
+	defer w.Close()
+	<-connected
+
+	run := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10220/hello",
+		Duration: 4,
+		Rate:     10,
+	})
+
+	// Points are pushed while the load test runs.
+	time.Sleep(3 * time.Second)
+	assert.NotZero(t, sumMatches(t, recv.lines("127.0.0.1:10222"), `^terjang\.requests:(\d+)\|c$`))
+
+	// Wait for the load test to complete and its last points to be pushed.
+	time.Sleep(3 * time.Second)
+
+	influx := recv.lines("/write")
+	assert.Equal(t, 40, sumLastMatches(t, influx, `^terjang,run_id=`+run.ID+` requests=(\d+)i,`))
+	assert.Equal(t, 40, sumLastMatches(t, influx, `^terjang_status_codes,run_id=`+run.ID+`,code=200 count=(\d+)i`))
+	assert.Regexp(t, `latency_p99=\d+i`, strings.Join(influx, "\n"))
+
+	influxUDP := recv.lines("127.0.0.1:10224")
+	assert.Equal(t, 40, sumLastMatches(t, influxUDP, `^terjang,run_id=`+run.ID+` requests=(\d+)i,`))
+
+	statsd := recv.lines("127.0.0.1:10222")
+	assert.Equal(t, 40, sumMatches(t, statsd, `^terjang\.requests:(\d+)\|c$`))
+	assert.Equal(t, 40, sumMatches(t, statsd, `^terjang\.responses\.200:(\d+)\|c$`))
+	assert.Regexp(t, `terjang\.latency\.p99:[\d.]+\|g`, strings.Join(statsd, "\n"))
+
+	dogstatsd := recv.lines("127.0.0.1:10223")
+	assert.Equal(t, 40, sumMatches(t, dogstatsd, `^terjang\.requests:(\d+)\|c\|#run_id:`+run.ID+`$`))
+	assert.Equal(t, 40, sumMatches(t, dogstatsd, `^terjang\.responses:(\d+)\|c\|#run_id:`+run.ID+`,code:200$`))
+
+	recv.lock.Lock()
+	exports := recv.requests["/v1/metrics"]
+	recv.lock.Unlock()
+	require.NotEmpty(t, exports)
+
+	requests := 0
+	for _, body := range exports {
+		var export struct {
+			ResourceMetrics []struct {
+				ScopeMetrics []struct {
+					Metrics []struct {
+						Name string `json:"name"`
+						Sum  *struct {
+							DataPoints []struct {
+								AsInt string `json:"asInt"`
+							} `json:"dataPoints"`
+						} `json:"sum"`
+					} `json:"metrics"`
+				} `json:"scopeMetrics"`
+			} `json:"resourceMetrics"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(body), &export))
+
+		for _, m := range export.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+			if m.Name != "terjang.requests" {
+				continue
+			}
+
+			for _, p := range m.Sum.DataPoints {
+				n, _ := strconv.Atoi(p.AsInt)
+				requests += n
+			}
+		}
+	}
+	assert.Equal(t, 40, requests)
+	assert.Contains(t, exports[0], `"name":"terjang.latency"`)
+}
+
+func TestUnknownSink(t *testing.T) {
+	_, err := sink.New("graphite://127.0.0.1:2003")
+	assert.Error(t, err)
+}
@@ -0,0 +1,72 @@
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/transport"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInProcessWorkers(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10170")
+
+	srv := server.NewServer()
+	go srv.Run("127.0.0.1:9139")
+	defer srv.Close()
+
+	for _, name := range []string{"local-1", "local-2"} {
+		serverConn, workerConn := transport.Pipe()
+		defer serverConn.Close()
+
+		w := worker.NewWorker()
+		w.SetName(name)
+
+		go srv.ServeWorker(serverConn, name, 1)
+		go w.RunWithConn(workerConn)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	duration := 1
+	rate := 10
+	run := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10170/hello",
+		Duration: uint64(duration),
+		Rate:     uint64(rate),
+	})
+
+	// Wait for the load test to complete and the run to be saved.
+	time.Sleep(time.Duration(duration)*time.Second + 2500*time.Millisecond)
+
+	assert.Equal(t, 2*rate*duration, target.count())
+
+	stored, err := srv.GetRun(run.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Done", stored.State)
+	assert.Len(t, stored.Workers, 2)
+	assert.Equal(t, uint64(2*rate*duration), stored.Metrics.Requests)
+}
+
+func TestPipeClose(t *testing.T) {
+	a, b := transport.Pipe()
+
+	require.NoError(t, a.WriteMessage([]byte("hello")))
+	require.NoError(t, a.Close())
+
+	// Messages written before closing are still delivered.
+	message, err := b.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(message))
+
+	_, err = b.ReadMessage()
+	assert.Equal(t, transport.ErrClosed, err)
+	assert.Equal(t, transport.ErrClosed, b.WriteMessage([]byte("hello")))
+}
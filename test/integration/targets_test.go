@@ -0,0 +1,127 @@
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/stretchr/testify/assert"
+)
+
+type pathCounter struct {
+	lock   sync.Mutex
+	counts map[string]int
+}
+
+func (p *pathCounter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p.lock.Lock()
+	p.counts[req.Method+" "+req.URL.Path]++
+	p.lock.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (p *pathCounter) count(key string) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.counts[key]
+}
+
+func runTargetsLoadTest(t *testing.T, req *messages.StartLoadTestRequest) {
+	server := server.NewServer()
+	go server.Run("127.0.0.1:9109")
+	defer server.Close()
+
+	worker := worker.NewWorker()
+	worker.SetConnectRetryInterval(connectRetryInterval)
+
+	// Wait for worker to be connected
+	connected := make(chan struct{})
+	worker.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go worker.Run("127.0.0.1:9109")
+	defer worker.Close()
+	<-connected
+
+	server.StartLoadTest(req)
+
+	time.Sleep(time.Duration(req.Duration)*time.Second + 500*time.Millisecond)
+}
+
+func TestHTTPTargetsFile(t *testing.T) {
+	counter := &pathCounter{counts: make(map[string]int)}
+	target := httptest.NewServer(counter)
+	defer target.Close()
+
+	runTargetsLoadTest(t, &messages.StartLoadTestRequest{
+		Duration:    1,
+		Rate:        20,
+		TargetsFile: "GET " + target.URL + "/a\nX-Foo: Bar\n\nDELETE " + target.URL + "/b\n",
+	})
+
+	assert.Equal(t, 10, counter.count("GET /a"))
+	assert.Equal(t, 10, counter.count("DELETE /b"))
+}
+
+func TestJSONTargetsFile(t *testing.T) {
+	counter := &pathCounter{counts: make(map[string]int)}
+	target := httptest.NewServer(counter)
+	defer target.Close()
+
+	runTargetsLoadTest(t, &messages.StartLoadTestRequest{
+		Duration:      1,
+		Rate:          20,
+		TargetsFormat: messages.TargetsFormatJSON,
+		TargetsFile: `{"method": "GET", "url": "` + target.URL + `/a"}` + "\n" +
+			`{"method": "POST", "url": "` + target.URL + `/b", "body": "Ym9keQ=="}` + "\n",
+	})
+
+	assert.Equal(t, 10, counter.count("GET /a"))
+	assert.Equal(t, 10, counter.count("POST /b"))
+}
+
+func TestWeightedTargets(t *testing.T) {
+	counter := &pathCounter{counts: make(map[string]int)}
+	target := httptest.NewServer(counter)
+	defer target.Close()
+
+	runTargetsLoadTest(t, &messages.StartLoadTestRequest{
+		Duration: 1,
+		Rate:     20,
+		Targets: []messages.LoadTestTarget{
+			{Method: "GET", URL: target.URL + "/a", Weight: 1},
+			{Method: "GET", URL: target.URL + "/b", Weight: 3},
+		},
+	})
+
+	assert.Equal(t, 5, counter.count("GET /a"))
+	assert.Equal(t, 15, counter.count("GET /b"))
+}
+
+func TestTemplatedTargets(t *testing.T) {
+	counter := &pathCounter{counts: make(map[string]int)}
+	target := httptest.NewServer(counter)
+	defer target.Close()
+
+	runTargetsLoadTest(t, &messages.StartLoadTestRequest{
+		Method:   "POST",
+		URL:      target.URL + "/users/{{.Data.id}}",
+		Header:   "X-Request-Id: {{uuid}}",
+		Body:     `{"seq": {{.Seq}}, "worker": "{{.Worker}}"}`,
+		Duration: 1,
+		Rate:     20,
+		Template: true,
+		DataFeed: "id,name\n1,alice\n2,bob\n",
+	})
+
+	assert.Equal(t, 10, counter.count("POST /users/1"))
+	assert.Equal(t, 10, counter.count("POST /users/2"))
+}
@@ -0,0 +1,123 @@
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startThresholdsServer(t *testing.T) *server.Server {
+	srv := server.NewServer()
+	go srv.Run("127.0.0.1:9119")
+
+	worker := worker.NewWorker()
+	worker.SetConnectRetryInterval(connectRetryInterval)
+
+	// Wait for worker to be connected
+	connected := make(chan struct{})
+	worker.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go worker.Run("127.0.0.1:9119")
+	t.Cleanup(worker.Close)
+	<-connected
+
+	return srv
+}
+
+func TestThresholdsVerdict(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10150")
+
+	srv := startThresholdsServer(t)
+	defer srv.Close()
+
+	run := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:     "GET",
+		URL:        "http://127.0.0.1:10150/hello",
+		Duration:   1,
+		Rate:       10,
+		Thresholds: []string{"p99 < 1s", "success >= 99.5%", "no 5xx", "requests > 100"},
+		// Thresholds on totals are only checked once the run ended, they do not abort it.
+		AbortOnBreach: true,
+	})
+
+	// Wait for the load test to complete and the run to be saved.
+	time.Sleep(1*time.Second + 2500*time.Millisecond)
+
+	stored, err := srv.GetRun(run.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored.Verdict)
+
+	assert.Equal(t, "Done", stored.State)
+	assert.False(t, stored.Verdict.Passed)
+	assert.False(t, stored.Verdict.Aborted)
+	require.Len(t, stored.Verdict.Thresholds, 4)
+
+	assert.True(t, stored.Verdict.Thresholds[0].Passed)
+	assert.True(t, stored.Verdict.Thresholds[1].Passed)
+	assert.Equal(t, "100%", stored.Verdict.Thresholds[1].Actual)
+	assert.True(t, stored.Verdict.Thresholds[2].Passed)
+	assert.False(t, stored.Verdict.Thresholds[3].Passed)
+	assert.Equal(t, "10", stored.Verdict.Thresholds[3].Actual)
+	assert.False(t, stored.Verdict.Thresholds[3].Pending)
+}
+
+func TestThresholdBreachAbortsLoadTest(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer target.Close()
+
+	srv := startThresholdsServer(t)
+	defer srv.Close()
+
+	duration := 10
+	rate := 10
+	run := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:        "GET",
+		URL:           target.URL,
+		Duration:      uint64(duration),
+		Rate:          uint64(rate),
+		Thresholds:    []string{"no 5xx"},
+		AbortOnBreach: true,
+	})
+
+	time.Sleep(5 * time.Second)
+
+	stored, err := srv.GetRun(run.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Stopped", stored.State)
+	assert.NotNil(t, stored.EndedAt)
+	assert.Less(t, stored.Metrics.Requests, uint64(duration*rate/2))
+
+	require.NotNil(t, stored.Verdict)
+	assert.False(t, stored.Verdict.Passed)
+	assert.True(t, stored.Verdict.Aborted)
+	assert.Equal(t, "no 5xx", stored.Verdict.AbortedBy)
+}
+
+func TestInvalidThresholdIsRejected(t *testing.T) {
+	srv := server.NewServer()
+	go srv.Run("127.0.0.1:9119")
+	defer srv.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	body := `{"method": "GET", "url": "http://127.0.0.1:10151", "duration": "1", "rate": "1", "thresholds": ["p99 <"]}`
+	resp, err := http.Post("http://127.0.0.1:9119/api/v1/load_test", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
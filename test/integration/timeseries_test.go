@@ -0,0 +1,100 @@
+package integration
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTestTimeSeries(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10120")
+
+	srv := server.NewServer()
+	go srv.Run("127.0.0.1:9079")
+	defer srv.Close()
+
+	clientStub := stubNotificationClient{isConnectedCh: make(chan struct{})}
+	go clientStub.run("127.0.0.1:9079")
+	<-clientStub.isConnectedCh
+
+	worker := worker.NewWorker()
+	worker.SetConnectRetryInterval(connectRetryInterval)
+
+	// Wait for worker to be connected
+	connected := make(chan struct{})
+	worker.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go worker.Run("127.0.0.1:9079")
+	defer worker.Close()
+	<-connected
+
+	duration := 2
+	rate := 10
+	run := srv.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10120/hello",
+		Duration: uint64(duration),
+		Rate:     uint64(rate),
+	})
+
+	// Wait for the load test to complete and the run to be saved.
+	time.Sleep(time.Duration(duration)*time.Second + 2500*time.Millisecond)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/load_tests/"+run.ID+"/timeseries", nil)
+	w := httptest.NewRecorder()
+	srv.HandleLoadTestTimeSeries(w, req, httprouter.Params{{Key: "id", Value: run.ID}})
+
+	resp := w.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	var points []messages.MetricsInterval
+	require.NoError(t, json.Unmarshal(bodyBytes, &points))
+	require.GreaterOrEqual(t, len(points), duration)
+
+	var requests, successes uint64
+	for i, p := range points {
+		requests += p.Requests
+		successes += p.Successes
+
+		assert.Greater(t, int64(p.Latencies.P99), int64(0))
+		if i > 0 {
+			assert.True(t, p.Timestamp.After(points[i-1].Timestamp))
+		}
+	}
+
+	assert.Equal(t, uint64(duration*rate), requests)
+	assert.Equal(t, requests, successes)
+
+	var streamed uint64
+	for _, envelope := range clientStub.received() {
+		if envelope.Kind != messages.KindLoadTestTimeSeries {
+			continue
+		}
+
+		var updated []messages.MetricsInterval
+		require.NoError(t, json.Unmarshal([]byte(envelope.Data), &updated))
+
+		for _, p := range updated {
+			streamed += p.Requests
+		}
+	}
+
+	// Points may be streamed more than once when late results update them.
+	assert.GreaterOrEqual(t, streamed, requests)
+}
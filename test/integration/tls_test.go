@@ -0,0 +1,194 @@
+package integration
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andylibrian/terjang/pkg/client"
+	"github.com/andylibrian/terjang/pkg/messages"
+	"github.com/andylibrian/terjang/pkg/server"
+	"github.com/andylibrian/terjang/pkg/tlsconfig"
+	"github.com/andylibrian/terjang/pkg/worker"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA issues certificates for tests and writes them as PEM files.
+type testCA struct {
+	t    *testing.T
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T, dir string, name string) *testCA {
+	ca := &testCA{t: t, dir: dir}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	ca.key = newTestKey(t)
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &ca.key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	ca.cert, err = x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	ca.file = filepath.Join(dir, name+".pem")
+	writePEM(t, ca.file, "CERTIFICATE", der)
+
+	return ca
+}
+
+// issue creates a certificate signed by the CA and returns the paths of the certificate and key files.
+func (ca *testCA) issue(name string, usage x509.ExtKeyUsage) (string, string) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"localhost"},
+	}
+
+	key := newTestKey(ca.t)
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(ca.t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(ca.t, err)
+
+	certFile := filepath.Join(ca.dir, name+".pem")
+	keyFile := filepath.Join(ca.dir, name+"-key.pem")
+	writePEM(ca.t, certFile, "CERTIFICATE", der)
+	writePEM(ca.t, keyFile, "EC PRIVATE KEY", keyDER)
+
+	return certFile, keyFile
+}
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	return key
+}
+
+func writePEM(t *testing.T, file string, typ string, der []byte) {
+	require.NoError(t, ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600))
+}
+
+func TestMutualTLS(t *testing.T) {
+	target := targetServer{}
+	go target.listenAndServe(":10240")
+
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	rogueCA := newTestCA(t, dir, "rogue-ca")
+
+	serverCert, serverKey := ca.issue("server", x509.ExtKeyUsageServerAuth)
+	workerCert, workerKey := ca.issue("worker", x509.ExtKeyUsageClientAuth)
+	rogueCert, rogueKey := rogueCA.issue("rogue", x509.ExtKeyUsageClientAuth)
+
+	serverConfig, err := tlsconfig.Server(serverCert, serverKey, ca.file)
+	require.NoError(t, err)
+
+	srv := server.NewServer()
+	srv.SetTLSConfig(serverConfig)
+	go srv.Run("127.0.0.1:9209")
+	defer srv.Close()
+
+	// Wait for the server to listen.
+	time.Sleep(100 * time.Millisecond)
+
+	// Workers without a certificate are rejected by the server, those with a rogue one fail the handshake.
+	noCertConfig, err := tlsconfig.Client(ca.file, "", "")
+	require.NoError(t, err)
+
+	dialer := websocket.Dialer{TLSClientConfig: noCertConfig}
+	_, resp, err := dialer.Dial("wss://127.0.0.1:9209/cluster/join?name=rogue", nil)
+	require.Error(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	rogueConfig, err := tlsconfig.Client(ca.file, rogueCert, rogueKey)
+	require.NoError(t, err)
+
+	dialer = websocket.Dialer{TLSClientConfig: rogueConfig}
+	_, _, err = dialer.Dial("wss://127.0.0.1:9209/cluster/join?name=rogue", nil)
+	assert.Error(t, err)
+
+	workerConfig, err := tlsconfig.Client(ca.file, workerCert, workerKey)
+	require.NoError(t, err)
+
+	w := worker.NewWorker()
+	w.SetName("worker1")
+	w.SetTLSConfig(workerConfig)
+	w.SetConnectRetryInterval(connectRetryInterval)
+
+	// Wait for worker to be connected
+	connected := make(chan struct{})
+	w.AddConnectedCallback(func() {
+		connected <- struct{}{}
+	})
+
+	go w.Run("127.0.0.1:9209")
+	defer w.Close()
+	<-connected
+
+	// API clients only need to trust the server.
+	clientConfig, err := tlsconfig.Client(ca.file, "", "")
+	require.NoError(t, err)
+
+	c := client.NewClient("127.0.0.1:9209")
+	c.SetTLSConfig(clientConfig)
+
+	run, err := c.StartLoadTest(&messages.StartLoadTestRequest{
+		Method:   "GET",
+		URL:      "http://127.0.0.1:10240/hello",
+		Duration: 1,
+		Rate:     10,
+	})
+	require.NoError(t, err)
+
+	// Wait for the load test to complete and the run to be saved.
+	time.Sleep(1*time.Second + 2500*time.Millisecond)
+
+	final, err := c.GetRun(run.ID)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), final.Metrics.Requests)
+
+	// Plain HTTP is not served.
+	_, err = client.NewClient("127.0.0.1:9209").GetRun(run.ID)
+	assert.Error(t, err)
+
+	// The server's certificate must be trusted.
+	untrusting := client.NewClient("127.0.0.1:9209")
+	untrustingConfig, err := tlsconfig.Client(rogueCA.file, "", "")
+	require.NoError(t, err)
+	untrusting.SetTLSConfig(untrustingConfig)
+
+	_, err = untrusting.GetRun(run.ID)
+	assert.Error(t, err)
+}
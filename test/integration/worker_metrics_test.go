@@ -33,6 +33,7 @@ func TestWorkerSendMetricsDuringLoadTest(t *testing.T) {
 	})
 
 	go worker.Run("127.0.0.1:9049")
+	defer worker.Close()
 	<-connected
 
 	duration := 2
@@ -57,7 +58,8 @@ func TestWorkerSendMetricsDuringLoadTest(t *testing.T) {
 	assert.Greater(t, serverMsgHandlerStub.MetricsMessageCount(), 0)
 	assert.Less(t, serverMsgHandlerStub.MetricsMessageCount(), 3)
 
-	assert.Greater(t, serverMsgHandlerStub.lastMetrics.Duration.Seconds(), float64(0))
-	assert.Greater(t, serverMsgHandlerStub.lastMetrics.BytesIn.Total, uint64(0))
-	assert.Equal(t, serverMsgHandlerStub.lastMetrics.Success, float64(1))
+	lastMetrics := serverMsgHandlerStub.LastMetrics()
+	assert.Greater(t, lastMetrics.Duration.Seconds(), float64(0))
+	assert.Greater(t, lastMetrics.BytesIn.Total, uint64(0))
+	assert.Equal(t, lastMetrics.Success, float64(1))
 }
@@ -0,0 +1,18 @@
+// The API token is passed once in the page URL (?token=...) and removed from it, so that it does not stay in the
+// history, bookmarks or Referer headers. It is only remembered for the browser tab.
+export default function getToken() {
+    const params = new URLSearchParams(location.search);
+    const token = params.get('token');
+    if (token) {
+        sessionStorage.setItem('terjang_token', token);
+
+        params.delete('token');
+        const query = params.toString();
+        history.replaceState(history.state, '', location.pathname + (query ? `?${query}` : '') + location.hash);
+    }
+
+    // Earlier versions kept the token across sessions.
+    localStorage.removeItem('terjang_token');
+
+    return sessionStorage.getItem('terjang_token') || '';
+}
@@ -1,4 +1,17 @@
+import getToken from './auth.js'
+
+// base64url encodes the UTF-8 bytes of a string, without padding.
+function base64url(s) {
+    const bytes = new TextEncoder().encode(s);
+    const binary = String.fromCharCode(...bytes);
+    return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+}
+
 export default function(serverBaseUrl) {
-    const  ws = new WebSocket(`${serverBaseUrl}/notifications`);
+    // Browsers can not set headers on websockets, the token is sent as a subprotocol rather than in the URL,
+    // where it would end up in logs. The server selects the "terjang" one.
+    const token = getToken();
+    const protocols = token ? ['terjang', `base64url.bearer.terjang.${base64url(token)}`] : [];
+    const  ws = new WebSocket(`${serverBaseUrl}/notifications`, protocols);
     return ws;
-}
\ No newline at end of file
+}